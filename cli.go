@@ -0,0 +1,1172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+
+	"wg-panel/internal/database"
+	"wg-panel/internal/dns"
+	"wg-panel/internal/server"
+	"wg-panel/internal/wireguard"
+	"wg-panel/pkg/wgserf"
+)
+
+// Команды управления серверами, клиентами и пробросами портов.
+// Если сервис запущен - запросы идут в его API через unix сокет,
+// иначе обработчики API выполняются в этом процессе над db.json и так же,
+// как в сервисе, сразу применяют изменения к системе (wg-quick, iptables/nft)
+
+const cliTimeout = 30 * time.Second
+
+// handlerTransport выполняет запросы обработчиком в текущем процессе
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	w := &responseBuffer{header: make(http.Header)}
+	t.handler.ServeHTTP(w, req)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", w.status, http.StatusText(w.status)),
+		StatusCode:    w.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.header,
+		Body:          io.NopCloser(&w.body),
+		ContentLength: int64(w.body.Len()),
+		Request:       req,
+	}, nil
+}
+
+// responseBuffer http.ResponseWriter, собирающий ответ в памяти
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseBuffer) Header() http.Header { return w.header }
+
+func (w *responseBuffer) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *responseBuffer) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(data)
+}
+
+// connectPanel подключается к запущенному сервису или поднимает API локально
+func connectPanel() (*wgserf.Panel, error) {
+	if isRunning() {
+		if _, err := os.Stat(server.SocketPath); err != nil {
+			return nil, fmt.Errorf("сервис запущен, но сокет %s недоступен - выполните wg_serf restart", server.SocketPath)
+		}
+		return wgserf.New("http://wg_serf", wgserf.WithUnixSocket(server.SocketPath))
+	}
+
+	config, err := database.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки конфигурации: %v", err)
+	}
+	db, err := database.LoadDatabase()
+	if err != nil {
+		db = &database.Database{Servers: []database.Server{}, Clients: []database.Client{}}
+	}
+	server.Config = config
+	server.DB = db
+	server.SetupRoutes()
+	wireguard.SelectFirewall(config.FirewallBackend)
+	dns.Configure(config.DNS)
+
+	// stderr, чтобы не портить вывод client export
+	fmt.Fprintln(os.Stderr, "ℹ️  Сервис не запущен: изменения сохраняются в db.json и сразу применяются к системе (wg-quick, iptables/nft)")
+
+	client := &http.Client{Transport: handlerTransport{handler: server.LocalHandler()}}
+	return wgserf.New("http://wg_serf", wgserf.WithHTTPClient(client))
+}
+
+// runManageCommand выполняет wg_serf server|client|forward ...
+func runManageCommand(command string, args []string) {
+	if len(args) == 0 && command != "sync" && command != "drift" && command != "dns" {
+		fmt.Printf("Использование: wg_serf %s <действие> ... (см. wg_serf help)\n", command)
+		os.Exit(1)
+	}
+
+	panel, err := connectPanel()
+	if err != nil {
+		fmt.Println("❌", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cliTimeout)
+	defer cancel()
+
+	switch command {
+	case "server":
+		err = serverCommand(ctx, panel, args[0], args[1:])
+	case "client":
+		err = clientCommand(ctx, panel, args[0], args[1:])
+	case "forward":
+		err = forwardCommand(ctx, panel, args[0], args[1:])
+	case "acl":
+		err = aclCommand(ctx, panel, args[0], args[1:])
+	case "egress":
+		err = egressCommand(ctx, panel, args[0], args[1:])
+	case "sync":
+		err = syncCommand(ctx, panel, args)
+	case "drift":
+		err = driftCommand(ctx, panel, args)
+	case "dns":
+		err = dnsCommand(ctx, panel, args)
+	}
+
+	if err != nil {
+		var apiErr *wgserf.APIError
+		if errors.As(err, &apiErr) {
+			err = errors.New(apiErr.Message)
+		}
+		fmt.Println("❌", err)
+		os.Exit(1)
+	}
+}
+
+// healthCheck проверяет работающий сервис через сокет (код выхода 1 - недоступен)
+func healthCheck() {
+	panel, err := wgserf.New("http://wg_serf", wgserf.WithUnixSocket(server.SocketPath))
+	if err != nil {
+		fmt.Println("❌", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	health, err := panel.Health(ctx)
+	if err != nil {
+		fmt.Println("❌ Сервис не отвечает:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("✅ %s (версия %s, работает %s)\n", health.Status, health.Version, time.Duration(health.UptimeSeconds)*time.Second)
+	fmt.Printf("🖥  Серверов: %d (активных %d), клиентов: %d\n", health.Servers, health.ServersActive, health.Clients)
+}
+
+// usageError ошибка неверных аргументов
+func usageError(usage string) error {
+	return fmt.Errorf("использование: wg_serf %s", usage)
+}
+
+// driftCommand показывает расхождения с БД из фоновой проверки (--now - проверить сейчас)
+func driftCommand(ctx context.Context, panel *wgserf.Panel, args []string) error {
+	now := len(args) > 0 && args[0] == "--now"
+	status, err := panel.Drift(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	if !status.Enabled {
+		fmt.Println("ℹ️  Фоновая проверка отключена (drift_check.interval < 0 в config.json)")
+	} else {
+		fmt.Printf("🔍 Проверка каждые %ds, автоисправление: %v\n", status.Interval, status.AutoRepair)
+	}
+	if status.CheckedAt == nil {
+		fmt.Println("Проверок еще не было (wg_serf drift --now)")
+		return nil
+	}
+	fmt.Println("Последняя проверка:", status.CheckedAt.Local().Format("2006-01-02 15:04:05"))
+	if status.LastRepairAt != nil && status.LastRepair != nil {
+		fmt.Printf("Последнее исправление: %s (применено %d, ошибок %d)\n",
+			status.LastRepairAt.Local().Format("2006-01-02 15:04:05"), status.LastRepair.Applied, status.LastRepair.Failed)
+	}
+
+	for _, e := range status.Errors {
+		fmt.Println("⚠️ ", e)
+	}
+	if len(status.Changes) == 0 {
+		fmt.Println("✅ Расхождений нет")
+		return nil
+	}
+
+	fmt.Println()
+	if status.DriftSince != nil {
+		fmt.Printf("⚠️  Расхождения с %s:\n", status.DriftSince.Local().Format("2006-01-02 15:04:05"))
+	}
+	for _, change := range status.Changes {
+		line := fmt.Sprintf("%-18s %s %s", change.Action, change.Target, change.Detail)
+		if change.Error != "" {
+			line += " ❌ " + change.Error
+		}
+		fmt.Println(line)
+	}
+	fmt.Println("\nИсправить: wg_serf sync")
+	return nil
+}
+
+// syncCommand приводит систему к БД (--dry-run - только показать разницу)
+func syncCommand(ctx context.Context, panel *wgserf.Panel, args []string) error {
+	dryRun := len(args) > 0 && args[0] == "--dry-run"
+	report, err := panel.Reconcile(ctx, dryRun)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range report.Errors {
+		fmt.Println("⚠️ ", e)
+	}
+	if len(report.Changes) == 0 {
+		fmt.Println("✅ Система совпадает с БД, изменений нет")
+		return nil
+	}
+
+	for _, change := range report.Changes {
+		line := fmt.Sprintf("%-18s %s %s", change.Action, change.Target, change.Detail)
+		if change.Error != "" {
+			line += " ❌ " + change.Error
+		}
+		fmt.Println(line)
+	}
+
+	if dryRun {
+		fmt.Printf("\nИзменений: %d (применить: wg_serf sync)\n", len(report.Changes))
+		return nil
+	}
+	fmt.Printf("\n✅ Применено: %d, ошибок: %d\n", report.Applied, report.Failed)
+	if report.Failed > 0 {
+		return fmt.Errorf("не все изменения применены")
+	}
+	return nil
+}
+
+// === СЕРВЕРЫ ===
+
+func serverCommand(ctx context.Context, panel *wgserf.Panel, action string, args []string) error {
+	switch action {
+	case "list", "ls":
+		servers, err := panel.Servers(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tИМЯ\tИНТЕРФЕЙС\tПОДСЕТЬ\tПОРТ\tСТАТУС")
+		for _, s := range servers {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", s.ID, s.Name, s.Interface, s.Address, s.ListenPort, statusText(s.Enabled))
+		}
+		return w.Flush()
+
+	case "create", "add":
+		if len(args) < 3 {
+			return usageError("server create <имя> <подсеть> <порт> [dns]")
+		}
+		port, err := strconv.Atoi(args[2])
+		if err != nil {
+			return fmt.Errorf("неверный порт: %s", args[2])
+		}
+		req := wgserf.CreateServerRequest{Name: args[0], Address: args[1], ListenPort: port}
+		if len(args) > 3 {
+			req.DNS = args[3]
+		}
+		s, err := panel.CreateServer(ctx, req)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Сервер %s создан (ID: %s, интерфейс: %s, порт: %d)\n", s.Name, s.ID, s.Interface, s.ListenPort)
+		return nil
+
+	case "delete", "rm":
+		if len(args) < 1 {
+			return usageError("server delete <сервер>")
+		}
+		s, err := findServerRef(ctx, panel, args[0])
+		if err != nil {
+			return err
+		}
+		if err := panel.DeleteServer(ctx, s.ID); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Сервер %s удален\n", s.Name)
+		return nil
+
+	case "adopt":
+		return adoptCommand(ctx, panel, args)
+
+	case "isolation":
+		if len(args) < 2 || (args[1] != "on" && args[1] != "off") {
+			return usageError("server isolation <сервер> <on|off>")
+		}
+		s, err := findServerRef(ctx, panel, args[0])
+		if err != nil {
+			return err
+		}
+		s, err = panel.UpdateServer(ctx, s.ID, wgserf.UpdateServerRequest{Isolation: wgserf.Bool(args[1] == "on")})
+		if err != nil {
+			return err
+		}
+		state := "выключена"
+		if s.Isolation {
+			state = "включена"
+		}
+		fmt.Printf("✅ Изоляция клиентов сервера %s %s\n", s.Name, state)
+		return nil
+
+	case "bundle":
+		if len(args) < 2 {
+			return usageError("server bundle <сервер> <файл.zip>")
+		}
+		s, err := findServerRef(ctx, panel, args[0])
+		if err != nil {
+			return err
+		}
+		data, err := panel.ServerBundle(ctx, s.ID)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(args[1], data, 0600); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Конфиги клиентов сервера %s сохранены в %s\n", s.Name, args[1])
+		return nil
+	}
+
+	return fmt.Errorf("неизвестное действие: server %s", action)
+}
+
+// adoptCommand без аргументов показывает интерфейсы вне панели, с аргументами - переносит их
+func adoptCommand(ctx context.Context, panel *wgserf.Panel, args []string) error {
+	if len(args) > 0 {
+		candidates, err := panel.Adopt(ctx, args...)
+		if err != nil {
+			return err
+		}
+		for _, c := range candidates {
+			fmt.Printf("✅ Интерфейс %s перенесен в панель (сервер %s, клиентов: %d)\n", c.Interface, c.Server.ID, len(c.Clients))
+		}
+		return nil
+	}
+
+	candidates, err := panel.AdoptPreview(ctx)
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		fmt.Println("Все интерфейсы WireGuard уже управляются панелью")
+		return nil
+	}
+
+	for _, c := range candidates {
+		state := "остановлен"
+		if c.Running {
+			state = "запущен"
+		}
+		fmt.Printf("🔌 %s (%s) %s порт %d, клиентов: %d\n", c.Interface, state, c.Server.Address, c.Server.ListenPort, len(c.Clients))
+		for _, client := range c.Clients {
+			fmt.Printf("   👤 %s %s\n", client.Address, client.Name)
+		}
+		for _, warning := range c.Warnings {
+			fmt.Printf("   ⚠️  %s\n", warning)
+		}
+		for _, e := range c.Errors {
+			fmt.Printf("   ❌ %s\n", e)
+		}
+	}
+	fmt.Println("\nПеренести: wg_serf server adopt <интерфейс> [интерфейс...]")
+	return nil
+}
+
+// findServerRef ищет сервер по ID, имени или интерфейсу
+func findServerRef(ctx context.Context, panel *wgserf.Panel, ref string) (*wgserf.Server, error) {
+	servers, err := panel.Servers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []wgserf.Server
+	for _, s := range servers {
+		if s.ID == ref {
+			return &s, nil
+		}
+		if s.Name == ref || s.Interface == ref {
+			found = append(found, s)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("сервер %s не найден", ref)
+	case 1:
+		return &found[0], nil
+	}
+	return nil, fmt.Errorf("несколько серверов с именем %s, укажите ID", ref)
+}
+
+// === КЛИЕНТЫ ===
+
+func clientCommand(ctx context.Context, panel *wgserf.Panel, action string, args []string) error {
+	switch action {
+	case "list", "ls":
+		opts := wgserf.ListClientsOptions{}
+		if len(args) > 0 {
+			s, err := findServerRef(ctx, panel, args[0])
+			if err != nil {
+				return err
+			}
+			opts.ServerID = s.ID
+		}
+		clients, err := panel.AllClients(ctx, opts)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tИМЯ\tАДРЕС\tСТАТУС\tКОММЕНТАРИЙ")
+		for _, c := range clients {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.ID, c.Name, c.Address, statusText(c.Enabled), c.Comment)
+		}
+		return w.Flush()
+
+	case "add", "create":
+		if len(args) < 2 {
+			return usageError("client add <сервер> <имя> [комментарий]")
+		}
+		s, err := findServerRef(ctx, panel, args[0])
+		if err != nil {
+			return err
+		}
+		req := wgserf.CreateClientRequest{Name: args[1]}
+		if len(args) > 2 {
+			req.Comment = strings.Join(args[2:], " ")
+		}
+		c, err := panel.CreateClient(ctx, s.ID, req)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Клиент %s создан (ID: %s, адрес: %s)\n", c.Name, c.ID, c.Address)
+		fmt.Printf("📋 Конфиг: wg_serf client show-config %s\n", c.ID)
+		return nil
+
+	case "rm", "delete":
+		c, err := clientArg(ctx, panel, args, "client rm <клиент>")
+		if err != nil {
+			return err
+		}
+		if err := panel.DeleteClient(ctx, c.ID); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Клиент %s удален\n", c.Name)
+		return nil
+
+	case "enable", "disable":
+		c, err := clientArg(ctx, panel, args, "client "+action+" <клиент>")
+		if err != nil {
+			return err
+		}
+		c, err = panel.UpdateClient(ctx, c.ID, wgserf.UpdateClientRequest{Enabled: wgserf.Bool(action == "enable")})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Клиент %s %s\n", c.Name, statusText(c.Enabled))
+		return nil
+
+	case "groups":
+		if len(args) < 2 {
+			return usageError("client groups <клиент> <группа,...|->")
+		}
+		c, err := findClientRef(ctx, panel, args[0])
+		if err != nil {
+			return err
+		}
+		groups := []string{}
+		if args[1] != "-" {
+			groups = strings.Split(args[1], ",")
+		}
+		c, err = panel.UpdateClient(ctx, c.ID, wgserf.UpdateClientRequest{Groups: &groups})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Группы клиента %s: %s\n", c.Name, strings.Join(c.Groups, ", "))
+		return nil
+
+	case "show-config", "config":
+		c, err := clientArg(ctx, panel, args, "client show-config <клиент>")
+		if err != nil {
+			return err
+		}
+		config, err := panel.ClientConfig(ctx, c.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Print(config)
+		return nil
+
+	case "qr":
+		c, err := clientArg(ctx, panel, args, "client qr <клиент>")
+		if err != nil {
+			return err
+		}
+		config, err := panel.ClientConfig(ctx, c.ID)
+		if err != nil {
+			return err
+		}
+		return printQR(config)
+
+	case "import":
+		return importClients(ctx, panel, args)
+
+	case "export":
+		format := "csv"
+		if len(args) > 0 {
+			format = args[0]
+		}
+		serverID := ""
+		if len(args) > 1 {
+			s, err := findServerRef(ctx, panel, args[1])
+			if err != nil {
+				return err
+			}
+			serverID = s.ID
+		}
+		data, err := panel.ExportClients(ctx, format, serverID)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	return fmt.Errorf("неизвестное действие: client %s", action)
+}
+
+// importClients загружает клиентов из CSV или JSON файла
+func importClients(ctx context.Context, panel *wgserf.Panel, args []string) error {
+	usage := "client import <файл> [сервер] [--dry-run]"
+	opts := wgserf.ImportOptions{}
+	var positional []string
+	for _, arg := range args {
+		if arg == "--dry-run" {
+			opts.DryRun = true
+			continue
+		}
+		positional = append(positional, arg)
+	}
+	if len(positional) < 1 {
+		return usageError(usage)
+	}
+
+	data, err := os.ReadFile(positional[0])
+	if err != nil {
+		return err
+	}
+	if len(positional) > 1 {
+		s, err := findServerRef(ctx, panel, positional[1])
+		if err != nil {
+			return err
+		}
+		opts.ServerID = s.ID
+	}
+
+	report, err := panel.ImportClients(ctx, data, opts)
+	if report == nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "СТРОКА\tИМЯ\tАДРЕС\tСТАТУС")
+	for _, row := range report.Rows {
+		status := row.Status
+		if row.Error != "" {
+			status = "❌ " + row.Error
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", row.Row, row.Name, row.Address, status)
+	}
+	w.Flush()
+
+	if err != nil {
+		return fmt.Errorf("строк с ошибками: %d, клиенты не созданы", report.Errors)
+	}
+	if report.DryRun {
+		fmt.Printf("✅ Проверка пройдена: %d строк\n", len(report.Rows))
+	} else {
+		fmt.Printf("✅ Создано клиентов: %d\n", report.Created)
+	}
+	return nil
+}
+
+// clientArg ищет клиента по первому аргументу
+func clientArg(ctx context.Context, panel *wgserf.Panel, args []string, usage string) (*wgserf.Client, error) {
+	if len(args) < 1 {
+		return nil, usageError(usage)
+	}
+	return findClientRef(ctx, panel, args[0])
+}
+
+// findClientRef ищет клиента по ID или имени
+func findClientRef(ctx context.Context, panel *wgserf.Panel, ref string) (*wgserf.Client, error) {
+	if c, err := panel.Client(ctx, ref); err == nil {
+		return c, nil
+	} else if !errors.Is(err, wgserf.ErrNotFound) {
+		return nil, err
+	}
+
+	clients, err := panel.AllClients(ctx, wgserf.ListClientsOptions{Query: ref})
+	if err != nil {
+		return nil, err
+	}
+
+	var found []wgserf.Client
+	for _, c := range clients {
+		if c.Name == ref {
+			found = append(found, c)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("клиент %s не найден", ref)
+	case 1:
+		return &found[0], nil
+	}
+	return nil, fmt.Errorf("несколько клиентов с именем %s, укажите ID", ref)
+}
+
+// printQR выводит QR код в терминал (два ряда модулей на строку)
+func printQR(content string) error {
+	qr, err := qrcode.New(content, qrcode.Low)
+	if err != nil {
+		return err
+	}
+
+	// ANSI цвет: base+0 - черный, base+7 - белый (30 - символ, 40 - фон)
+	color := func(dark bool, base int) string {
+		if dark {
+			return strconv.Itoa(base)
+		}
+		return strconv.Itoa(base + 7)
+	}
+
+	bitmap := qr.Bitmap()
+	var sb strings.Builder
+	for y := 0; y < len(bitmap); y += 2 {
+		for x := range bitmap[y] {
+			top := bitmap[y][x]
+			bottom := false
+			if y+1 < len(bitmap) {
+				bottom = bitmap[y+1][x]
+			}
+			// Верхний модуль - цвет символа ▀, нижний - цвет фона
+			sb.WriteString("\033[" + color(top, 30) + ";" + color(bottom, 40) + "m▀")
+		}
+		sb.WriteString("\033[0m\n")
+	}
+
+	fmt.Print(sb.String())
+	return nil
+}
+
+// === ПРОБРОСЫ ПОРТОВ ===
+
+func forwardCommand(ctx context.Context, panel *wgserf.Panel, action string, args []string) error {
+	switch action {
+	case "list", "ls":
+		c, err := clientArg(ctx, panel, args, "forward list <клиент>")
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ПОРТ\tНА КЛИЕНТЕ\tПРОТОКОЛ\tИСТОЧНИКИ\tОПИСАНИЕ")
+		for _, pf := range c.PortForwards {
+			external := strconv.Itoa(pf.Port)
+			if pf.PortEnd > pf.Port {
+				external += "-" + strconv.Itoa(pf.PortEnd)
+			}
+			internal := external
+			if pf.InternalPort != 0 {
+				internal = strconv.Itoa(pf.InternalPort)
+			}
+			sources := "любые"
+			if len(pf.SourceCIDRs) > 0 {
+				sources = strings.Join(pf.SourceCIDRs, ",")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", external, internal, pf.Protocol, sources, pf.Description)
+		}
+		return w.Flush()
+
+	case "add":
+		const usage = "forward add <клиент> <порт[-конец][:порт на клиенте]> <tcp|udp|both> [--from CIDR,...] [--all-interfaces] [описание]"
+		if len(args) < 3 {
+			return usageError(usage)
+		}
+		c, err := findClientRef(ctx, panel, args[0])
+		if err != nil {
+			return err
+		}
+		pf, err := parseForwardPorts(args[1])
+		if err != nil {
+			return err
+		}
+		pf.Protocol = args[2]
+
+		rest := args[3:]
+		for len(rest) > 0 && strings.HasPrefix(rest[0], "--") {
+			switch {
+			case rest[0] == "--from" && len(rest) > 1:
+				pf.SourceCIDRs = strings.Split(rest[1], ",")
+				rest = rest[2:]
+			case rest[0] == "--all-interfaces":
+				pf.AllInterfaces = true
+				rest = rest[1:]
+			default:
+				return usageError(usage)
+			}
+		}
+		pf.Description = strings.Join(rest, " ")
+
+		if _, err := panel.AddPortForward(ctx, c.ID, pf); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Порт %s/%s проброшен на %s (%s)\n", args[1], pf.Protocol, c.Name, c.Address)
+		return nil
+
+	case "rm", "delete":
+		if len(args) < 3 {
+			return usageError("forward rm <клиент> <порт> <протокол>")
+		}
+		c, err := findClientRef(ctx, panel, args[0])
+		if err != nil {
+			return err
+		}
+		port, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("неверный порт: %s", args[1])
+		}
+		if _, err := panel.RemovePortForward(ctx, c.ID, port, args[2]); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Проброс %d/%s удален\n", port, args[2])
+		return nil
+	}
+
+	return fmt.Errorf("неизвестное действие: forward %s", action)
+}
+
+// === ACL ===
+
+// aclCommand правила доступа между группами клиентов изолированного сервера
+func aclCommand(ctx context.Context, panel *wgserf.Panel, action string, args []string) error {
+	switch action {
+	case "list", "ls":
+		if len(args) < 1 {
+			return usageError("acl list <сервер>")
+		}
+		s, err := findServerRef(ctx, panel, args[0])
+		if err != nil {
+			return err
+		}
+		if !s.Isolation {
+			fmt.Printf("⚠️  Изоляция сервера %s выключена - клиенты видят друг друга без ограничений\n", s.Name)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "#\tИЗ\tВ\tПРОТОКОЛ\tПОРТЫ\tОПИСАНИЕ")
+		for i, acl := range s.ACLs {
+			protocol, ports := acl.Protocol, acl.Ports
+			if protocol == "" {
+				protocol = "любой"
+			}
+			if ports == "" {
+				ports = "все"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", i+1, acl.From, acl.To, protocol, ports, acl.Description)
+		}
+		return w.Flush()
+
+	case "add":
+		const usage = "acl add <сервер> <из группы> <в группу> [tcp|udp|both [порты]] [описание]"
+		if len(args) < 3 {
+			return usageError(usage)
+		}
+		s, err := findServerRef(ctx, panel, args[0])
+		if err != nil {
+			return err
+		}
+		acl := wgserf.ACLRule{From: args[1], To: args[2]}
+		rest := args[3:]
+		if len(rest) > 0 && (rest[0] == "tcp" || rest[0] == "udp" || rest[0] == "both") {
+			acl.Protocol = rest[0]
+			rest = rest[1:]
+			if len(rest) > 0 && rest[0] != "" && strings.Trim(rest[0], "0123456789-") == "" {
+				acl.Ports = rest[0]
+				rest = rest[1:]
+			}
+		}
+		acl.Description = strings.Join(rest, " ")
+
+		acls := append(s.ACLs, acl)
+		if _, err := panel.UpdateServer(ctx, s.ID, wgserf.UpdateServerRequest{ACLs: &acls}); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Группе %s разрешен доступ к %s на сервере %s\n", acl.From, acl.To, s.Name)
+		return nil
+
+	case "rm", "delete":
+		if len(args) < 2 {
+			return usageError("acl rm <сервер> <номер>")
+		}
+		s, err := findServerRef(ctx, panel, args[0])
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > len(s.ACLs) {
+			return fmt.Errorf("нет правила №%s (см. wg_serf acl list %s)", args[1], args[0])
+		}
+		acls := append(append([]wgserf.ACLRule{}, s.ACLs[:n-1]...), s.ACLs[n:]...)
+		if _, err := panel.UpdateServer(ctx, s.ID, wgserf.UpdateServerRequest{ACLs: &acls}); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Правило №%d удалено\n", n)
+		return nil
+	}
+
+	return fmt.Errorf("неизвестное действие: acl %s", action)
+}
+
+// === ПОЛИТИКИ ИСХОДЯЩЕГО ТРАФИКА ===
+
+// egressCommand показывает и меняет политику исходящего трафика клиента
+func egressCommand(ctx context.Context, panel *wgserf.Panel, action string, args []string) error {
+	c, err := clientArg(ctx, panel, args, "egress "+action+" <клиент> ...")
+	if err != nil {
+		return err
+	}
+	policy := wgserf.EgressPolicy{}
+	if c.Egress != nil {
+		policy = *c.Egress
+	}
+
+	switch action {
+	case "show":
+		if c.Egress == nil {
+			fmt.Printf("Клиент %s: без ограничений\n", c.Name)
+			return nil
+		}
+		internet := "открыт"
+		if policy.BlockInternet {
+			internet = "закрыт"
+		}
+		fmt.Printf("Клиент %s: интернет %s\n", c.Name, internet)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "СПИСОК\t#\tСЕТЬ\tПРОТОКОЛ\tПОРТЫ")
+		for _, list := range []struct {
+			name  string
+			rules []wgserf.EgressRule
+		}{{"deny", policy.Deny}, {"allow", policy.Allow}} {
+			for i, rule := range list.rules {
+				protocol, ports := rule.Protocol, rule.Ports
+				if protocol == "" {
+					protocol = "любой"
+				}
+				if ports == "" {
+					ports = "все"
+				}
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", list.name, i+1, rule.CIDR, protocol, ports)
+			}
+		}
+		return w.Flush()
+
+	case "internet":
+		if len(args) < 2 || (args[1] != "on" && args[1] != "off") {
+			return usageError("egress internet <клиент> <on|off>")
+		}
+		policy.BlockInternet = args[1] == "off"
+
+	case "allow", "deny":
+		if len(args) < 2 {
+			return usageError("egress " + action + " <клиент> <CIDR> [tcp|udp|both [порты]]")
+		}
+		rule := wgserf.EgressRule{CIDR: args[1]}
+		if len(args) > 2 {
+			rule.Protocol = args[2]
+		}
+		if len(args) > 3 {
+			rule.Ports = args[3]
+		}
+		if action == "allow" {
+			policy.Allow = append(policy.Allow, rule)
+		} else {
+			policy.Deny = append(policy.Deny, rule)
+		}
+
+	case "rm", "delete":
+		if len(args) < 3 || (args[1] != "allow" && args[1] != "deny") {
+			return usageError("egress rm <клиент> <allow|deny> <номер>")
+		}
+		list := &policy.Allow
+		if args[1] == "deny" {
+			list = &policy.Deny
+		}
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 1 || n > len(*list) {
+			return fmt.Errorf("нет правила %s №%s (см. wg_serf egress show %s)", args[1], args[2], args[0])
+		}
+		*list = append(append([]wgserf.EgressRule{}, (*list)[:n-1]...), (*list)[n:]...)
+
+	case "clear":
+		policy = wgserf.EgressPolicy{}
+
+	default:
+		return fmt.Errorf("неизвестное действие: egress %s", action)
+	}
+
+	c, err = panel.UpdateClient(ctx, c.ID, wgserf.UpdateClientRequest{Egress: &policy})
+	if err != nil {
+		return err
+	}
+	if c.Egress == nil {
+		fmt.Printf("✅ Клиент %s: без ограничений\n", c.Name)
+	} else {
+		fmt.Printf("✅ Политика клиента %s обновлена (разрешений %d, запретов %d)\n", c.Name, len(c.Egress.Allow), len(c.Egress.Deny))
+	}
+	return nil
+}
+
+// === DNS ===
+
+// dnsCommand списки блокировки, исключения и статистика встроенного DNS
+func dnsCommand(ctx context.Context, panel *wgserf.Panel, args []string) error {
+	action := "status"
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+
+	switch action {
+	case "status":
+		status, err := panel.DNS(ctx)
+		if err != nil {
+			return err
+		}
+		return printDNSStatus(status)
+
+	case "refresh":
+		status, err := panel.RefreshDNS(ctx)
+		if err != nil {
+			return err
+		}
+		return printDNSStatus(status)
+
+	case "stats":
+		serverID := ""
+		if len(args) > 0 {
+			s, err := findServerRef(ctx, panel, args[0])
+			if err != nil {
+				return err
+			}
+			serverID = s.ID
+		}
+		stats, err := panel.DNSStats(ctx, serverID, 3)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "КЛИЕНТ	АДРЕС	ЗАПРОСОВ	ЗАБЛОКИРОВАНО	ЧАЩЕ ВСЕГО")
+		for _, entry := range stats {
+			var top []string
+			for _, domain := range entry.TopBlocked {
+				top = append(top, fmt.Sprintf("%s (%d)", domain.Domain, domain.Count))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", entry.Name, entry.Address, entry.Queries, entry.Blocked, strings.Join(top, ", "))
+		}
+		return w.Flush()
+
+	case "filter":
+		if len(args) < 2 || (args[1] != "on" && args[1] != "off") {
+			return usageError("dns filter <сервер> <on|off>")
+		}
+		s, err := findServerRef(ctx, panel, args[0])
+		if err != nil {
+			return err
+		}
+		s, err = panel.UpdateServer(ctx, s.ID, wgserf.UpdateServerRequest{DNSFilter: wgserf.Bool(args[1] == "on")})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Фильтр DNS сервера %s %s\n", s.Name, statusText(s.DNSFilter))
+		return nil
+
+	case "upload":
+		if len(args) < 2 {
+			return usageError("dns upload <имя> <файл>")
+		}
+		data, err := os.ReadFile(args[1])
+		if err != nil {
+			return err
+		}
+		list, err := panel.UploadBlocklist(ctx, args[0], data)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Список %s загружен: доменов %d, исключений %d\n", list.Source, list.Domains, list.Allowed)
+		return nil
+
+	case "list", "allow", "block":
+		if len(args) < 2 || (args[0] != "add" && args[0] != "rm") {
+			return usageError("dns " + action + " <add|rm> <значение>")
+		}
+		return dnsListCommand(ctx, panel, action, args[0] == "add", args[1])
+	}
+
+	return fmt.Errorf("неизвестное действие: dns %s", action)
+}
+
+// dnsListCommand добавляет или убирает источник списка, исключение или заблокированный домен
+func dnsListCommand(ctx context.Context, panel *wgserf.Panel, kind string, add bool, value string) error {
+	status, err := panel.DNS(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Загруженные списки удаляются по имени
+	if kind == "list" && !add {
+		for _, list := range status.Lists {
+			if list.Kind == "upload" && list.Source == value {
+				if err := panel.DeleteBlocklist(ctx, value); err != nil {
+					return err
+				}
+				fmt.Printf("✅ Список %s удален\n", value)
+				return nil
+			}
+		}
+	}
+
+	var values []string
+	var req wgserf.UpdateDNSRequest
+	switch kind {
+	case "list":
+		for _, list := range status.Lists {
+			if list.Kind != "upload" {
+				values = append(values, list.Source)
+			}
+		}
+		// Сервис может работать в другом каталоге
+		if !strings.Contains(value, "://") {
+			if abs, err := filepath.Abs(value); err == nil {
+				value = abs
+			}
+		}
+		req.Blocklists = &values
+	case "allow":
+		values = status.Allowlist
+		req.Allowlist = &values
+	case "block":
+		values = status.Blocklist
+		req.Blocklist = &values
+	}
+
+	found := -1
+	for i, existing := range values {
+		if strings.EqualFold(existing, value) {
+			found = i
+		}
+	}
+	switch {
+	case add && found >= 0:
+		return fmt.Errorf("%s уже есть в списке", value)
+	case add:
+		values = append(values, value)
+	case found < 0:
+		return fmt.Errorf("%s нет в списке (см. wg_serf dns)", value)
+	default:
+		values = append(values[:found], values[found+1:]...)
+	}
+
+	status, err = panel.UpdateDNS(ctx, req)
+	if err != nil {
+		return err
+	}
+	if kind == "list" && add {
+		for _, list := range status.Lists {
+			if list.Source == value && list.Error != "" {
+				fmt.Printf("⚠️  Список добавлен, но не загружен: %s\n", list.Error)
+				return nil
+			}
+		}
+	}
+	fmt.Printf("✅ Готово, в фильтре %d доменов из списков\n", status.BlockedDomains)
+	return nil
+}
+
+// printDNSStatus состояние DNS и списков
+func printDNSStatus(status *wgserf.DNSStatus) error {
+	fmt.Printf("Встроенный DNS %s, зона %s, списки по URL обновляются раз в %d ч\n", statusText(status.Enabled), status.Domain, status.RefreshHours)
+	if !status.Enabled {
+		fmt.Println("⚠️  Фильтр работает только при \"dns\": {\"enabled\": true} в config.json")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "СПИСОК\tВИД\tДОМЕНОВ\tИСКЛЮЧЕНИЙ\tОБНОВЛЕН\tОШИБКА")
+	for _, list := range status.Lists {
+		updated := "-"
+		if list.UpdatedAt != nil {
+			updated = list.UpdatedAt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", list.Source, list.Kind, list.Domains, list.Allowed, updated, list.Error)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Всего доменов в списках: %d\n", status.BlockedDomains)
+	if len(status.Blocklist) > 0 {
+		fmt.Printf("Заблокированы вручную: %s\n", strings.Join(status.Blocklist, ", "))
+	}
+	if len(status.Allowlist) > 0 {
+		fmt.Printf("Исключения: %s\n", strings.Join(status.Allowlist, ", "))
+	}
+	return nil
+}
+
+// parseForwardPorts разбирает 2222:22, 8000-8010 или 80
+func parseForwardPorts(value string) (wgserf.PortForward, error) {
+	var pf wgserf.PortForward
+	external, internal, hasInternal := strings.Cut(value, ":")
+	start, end, isRange := strings.Cut(external, "-")
+
+	var err error
+	if pf.Port, err = strconv.Atoi(start); err != nil {
+		return pf, fmt.Errorf("неверный порт: %s", value)
+	}
+	if isRange {
+		if pf.PortEnd, err = strconv.Atoi(end); err != nil {
+			return pf, fmt.Errorf("неверный конец диапазона: %s", value)
+		}
+	}
+	if hasInternal {
+		if pf.InternalPort, err = strconv.Atoi(internal); err != nil {
+			return pf, fmt.Errorf("неверный порт на клиенте: %s", value)
+		}
+	}
+	return pf, nil
+}
+
+// statusText статус для вывода
+func statusText(enabled bool) string {
+	if enabled {
+		return "включен"
+	}
+	return "выключен"
+}
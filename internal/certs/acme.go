@@ -0,0 +1,520 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"wg-panel/internal/database"
+)
+
+// Минимальный ACME клиент (RFC 8555) с проверками HTTP-01 и TLS-ALPN-01 (RFC 8737)
+
+const (
+	challengeHTTP    = "http-01"
+	challengeTLSALPN = "tls-alpn-01"
+
+	acmePollTimeout = 2 * time.Minute
+)
+
+// acmePollInterval пауза между опросами заказа и авторизации
+var acmePollInterval = 2 * time.Second
+
+// OID расширения id-pe-acmeIdentifier для TLS-ALPN-01
+var oidACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+func acmeAccountKeyPath() string { return filepath.Join(tlsDir, "acme_account.key") }
+func acmeCertPath() string       { return filepath.Join(tlsDir, "acme.crt") }
+func acmeKeyPath() string        { return filepath.Join(tlsDir, "acme.key") }
+
+// acmeDirectory каталог ACME сервера
+type acmeDirectory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+// acmeProblem ошибка ACME сервера (RFC 7807)
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+type acmeOrder struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+}
+
+type acmeChallenge struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+}
+
+type acmeAuthorization struct {
+	Status     string `json:"status"`
+	Identifier struct {
+		Value string `json:"value"`
+	} `json:"identifier"`
+	Challenges []acmeChallenge `json:"challenges"`
+}
+
+// acmeClient клиент ACME с ключом аккаунта
+type acmeClient struct {
+	directoryURL string
+	email        string
+	challenge    string
+	client       *http.Client
+	key          *ecdsa.PrivateKey
+
+	dir   *acmeDirectory
+	kid   string
+	nonce string
+
+	mu         sync.Mutex
+	httpTokens map[string]string           // token -> key authorization (HTTP-01)
+	alpnCerts  map[string]*tls.Certificate // домен -> сертификат проверки (TLS-ALPN-01)
+}
+
+// newACMEClient создает клиента и загружает (или создает) ключ аккаунта
+func newACMEClient(cfg database.TLSConfig) (*acmeClient, error) {
+	directoryURL := cfg.ACMEDirectory
+	if directoryURL == "" {
+		directoryURL = letsEncryptURL
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.ACMECACert != "" {
+		caPEM, err := os.ReadFile(cfg.ACMECACert)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать acme_ca_cert: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("acme_ca_cert не содержит сертификатов")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	key, err := loadOrCreateAccountKey()
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := challengeHTTP
+	if cfg.ACMEChallenge == challengeTLSALPN {
+		challenge = challengeTLSALPN
+	}
+
+	return &acmeClient{
+		directoryURL: directoryURL,
+		email:        cfg.ACMEEmail,
+		challenge:    challenge,
+		client:       &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		key:          key,
+		httpTokens:   make(map[string]string),
+		alpnCerts:    make(map[string]*tls.Certificate),
+	}, nil
+}
+
+// loadOrCreateAccountKey загружает ключ ACME аккаунта
+func loadOrCreateAccountKey() (*ecdsa.PrivateKey, error) {
+	if data, err := os.ReadFile(acmeAccountKeyPath()); err == nil {
+		block, _ := pem.Decode(data)
+		if block != nil {
+			if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+				return key, nil
+			}
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(acmeAccountKeyPath(), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// httpKeyAuth возвращает ответ на HTTP-01 проверку
+func (c *acmeClient) httpKeyAuth(token string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keyAuth, ok := c.httpTokens[token]
+	return keyAuth, ok
+}
+
+// alpnCertificate возвращает сертификат для TLS-ALPN-01 проверки
+func (c *acmeClient) alpnCertificate(domain string) *tls.Certificate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.alpnCerts[strings.ToLower(domain)]
+}
+
+// obtain выпускает сертификат для доменов и сохраняет его на диск
+func (c *acmeClient) obtain(domains []string) (*tls.Certificate, error) {
+	if err := c.ensureAccount(); err != nil {
+		return nil, fmt.Errorf("аккаунт: %v", err)
+	}
+
+	// Создаем заказ
+	var identifiers []map[string]string
+	for _, d := range domains {
+		identifiers = append(identifiers, map[string]string{"type": "dns", "value": d})
+	}
+	var order acmeOrder
+	resp, err := c.post(c.dir.NewOrder, map[string]interface{}{"identifiers": identifiers}, &order)
+	if err != nil {
+		return nil, fmt.Errorf("заказ: %v", err)
+	}
+	orderURL := resp.Header.Get("Location")
+
+	// Проходим проверки владения доменами
+	for _, authzURL := range order.Authorizations {
+		if err := c.authorize(authzURL); err != nil {
+			return nil, err
+		}
+	}
+
+	// Отправляем CSR
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, certKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.post(order.Finalize, map[string]string{"csr": b64(csr)}, &order); err != nil {
+		return nil, fmt.Errorf("finalize: %v", err)
+	}
+
+	// Ждем выпуска
+	deadline := time.Now().Add(acmePollTimeout)
+	for order.Status != "valid" {
+		if order.Status == "invalid" {
+			return nil, fmt.Errorf("заказ отклонен сервером")
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("таймаут ожидания выпуска сертификата")
+		}
+		time.Sleep(acmePollInterval)
+		if _, err := c.post(orderURL, nil, &order); err != nil {
+			return nil, err
+		}
+	}
+
+	// Скачиваем цепочку
+	_, body, err := c.postRaw(order.Certificate, nil)
+	if err != nil {
+		return nil, fmt.Errorf("скачивание сертификата: %v", err)
+	}
+	var chain [][]byte
+	for rest := body; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			chain = append(chain, block.Bytes)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("сервер вернул пустую цепочку сертификатов")
+	}
+
+	if err := writeCertPair(acmeCertPath(), acmeKeyPath(), chain, certKey); err != nil {
+		return nil, err
+	}
+	return loadCertPair(acmeCertPath(), acmeKeyPath())
+}
+
+// ensureAccount загружает каталог и регистрирует (или находит) аккаунт
+func (c *acmeClient) ensureAccount() error {
+	if c.dir == nil {
+		resp, err := c.client.Get(c.directoryURL)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		var dir acmeDirectory
+		if err := json.NewDecoder(resp.Body).Decode(&dir); err != nil {
+			return err
+		}
+		c.dir = &dir
+	}
+
+	if c.kid != "" {
+		return nil
+	}
+
+	payload := map[string]interface{}{"termsOfServiceAgreed": true}
+	if c.email != "" {
+		payload["contact"] = []string{"mailto:" + c.email}
+	}
+	resp, err := c.post(c.dir.NewAccount, payload, nil)
+	if err != nil {
+		return err
+	}
+	c.kid = resp.Header.Get("Location")
+	if c.kid == "" {
+		return fmt.Errorf("сервер не вернул URL аккаунта")
+	}
+	return nil
+}
+
+// authorize проходит проверку для одной авторизации
+func (c *acmeClient) authorize(authzURL string) error {
+	var authz acmeAuthorization
+	if _, err := c.post(authzURL, nil, &authz); err != nil {
+		return err
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+
+	domain := strings.ToLower(authz.Identifier.Value)
+	var challenge *acmeChallenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == c.challenge {
+			challenge = &authz.Challenges[i]
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("сервер не предлагает проверку %s для %s", c.challenge, domain)
+	}
+
+	keyAuth := challenge.Token + "." + c.thumbprint()
+
+	// Готовим ответ на проверку
+	c.mu.Lock()
+	if c.challenge == challengeHTTP {
+		c.httpTokens[challenge.Token] = keyAuth
+	} else {
+		cert, err := alpnChallengeCert(domain, keyAuth)
+		if err != nil {
+			c.mu.Unlock()
+			return err
+		}
+		c.alpnCerts[domain] = cert
+	}
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.httpTokens, challenge.Token)
+		delete(c.alpnCerts, domain)
+		c.mu.Unlock()
+	}()
+
+	log.Printf("🔐 ACME: проверка %s для %s...", c.challenge, domain)
+	if _, err := c.post(challenge.URL, map[string]interface{}{}, nil); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(acmePollTimeout)
+	for {
+		time.Sleep(acmePollInterval)
+		if _, err := c.post(authzURL, nil, &authz); err != nil {
+			return err
+		}
+		switch authz.Status {
+		case "valid":
+			return nil
+		case "invalid", "deactivated", "expired", "revoked":
+			return fmt.Errorf("проверка %s не пройдена (%s)", domain, authz.Status)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("таймаут проверки %s", domain)
+		}
+	}
+}
+
+// alpnChallengeCert создает сертификат для TLS-ALPN-01 (RFC 8737)
+func alpnChallengeCert(domain, keyAuth string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(keyAuth))
+	extValue, err := asn1.Marshal(digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		DNSNames:     []string{domain},
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidACMEIdentifier, Critical: true, Value: extValue},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// post отправляет подписанный запрос и декодирует JSON ответ
+func (c *acmeClient) post(url string, payload interface{}, out interface{}) (*http.Response, error) {
+	resp, body, err := c.postRaw(url, payload)
+	if err != nil {
+		return nil, err
+	}
+	if out != nil {
+		if err := json.Unmarshal(body, out); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// postRaw отправляет JWS запрос (payload nil - POST-as-GET) с повтором при badNonce
+func (c *acmeClient) postRaw(url string, payload interface{}) (*http.Response, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		body, err := c.signedBody(url, payload)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		resp, err := c.client.Post(url, "application/jose+json", bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		c.nonce = resp.Header.Get("Replay-Nonce")
+
+		if resp.StatusCode < 400 {
+			return resp, data, nil
+		}
+
+		var problem acmeProblem
+		json.Unmarshal(data, &problem)
+		if problem.Type == "urn:ietf:params:acme:error:badNonce" && attempt < 2 {
+			continue
+		}
+		return nil, nil, fmt.Errorf("%s: %s (HTTP %d)", problem.Type, problem.Detail, resp.StatusCode)
+	}
+}
+
+// signedBody формирует JWS в формате Flattened JSON (ES256)
+func (c *acmeClient) signedBody(url string, payload interface{}) ([]byte, error) {
+	if c.nonce == "" {
+		resp, err := c.client.Head(c.dir.NewNonce)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		c.nonce = resp.Header.Get("Replay-Nonce")
+	}
+
+	protected := map[string]interface{}{
+		"alg":   "ES256",
+		"nonce": c.nonce,
+		"url":   url,
+	}
+	if c.kid != "" {
+		protected["kid"] = c.kid
+	} else {
+		protected["jwk"] = c.jwk()
+	}
+	c.nonce = ""
+
+	protectedJSON, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+
+	payload64 := ""
+	if payload != nil {
+		payloadJSON, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		payload64 = b64(payloadJSON)
+	}
+
+	signingInput := b64(protectedJSON) + "." + payload64
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return json.Marshal(map[string]string{
+		"protected": b64(protectedJSON),
+		"payload":   payload64,
+		"signature": b64(signature),
+	})
+}
+
+// jwk возвращает публичный ключ аккаунта в формате JWK
+func (c *acmeClient) jwk() map[string]string {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	c.key.PublicKey.X.FillBytes(x)
+	c.key.PublicKey.Y.FillBytes(y)
+	return map[string]string{"crv": "P-256", "kty": "EC", "x": b64(x), "y": b64(y)}
+}
+
+// thumbprint вычисляет отпечаток JWK (RFC 7638)
+func (c *acmeClient) thumbprint() string {
+	jwk := c.jwk()
+	// Поля в лексикографическом порядке без пробелов
+	canonical := fmt.Sprintf(`{"crv":"%s","kty":"%s","x":"%s","y":"%s"}`, jwk["crv"], jwk["kty"], jwk["x"], jwk["y"])
+	sum := sha256.Sum256([]byte(canonical))
+	return b64(sum[:])
+}
+
+// b64 кодирует в base64url без выравнивания
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,103 @@
+//go:build pebble
+
+package certs
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"wg-panel/internal/database"
+)
+
+// Выпуск через настоящий ACME сервер pebble (https://github.com/letsencrypt/pebble).
+// Pebble отклоняет часть nonce (PEBBLE_WFE_NONCEREJECT), так что проверяется и повтор при badNonce:
+//
+//	pebble -config test/config/pebble-config.json &
+//	PEBBLE_CA=/path/to/pebble/test/certs/pebble.minica.pem go test -tags pebble ./internal/certs/
+//
+// PEBBLE_DIRECTORY, PEBBLE_DOMAIN, PEBBLE_HTTP_PORT и PEBBLE_TLS_PORT меняют значения по умолчанию
+func TestObtainPebble(t *testing.T) {
+	caCert := os.Getenv("PEBBLE_CA")
+	if caCert == "" {
+		t.Skip("PEBBLE_CA не задан")
+	}
+	directory := envOr("PEBBLE_DIRECTORY", "https://localhost:14000/dir")
+	domain := envOr("PEBBLE_DOMAIN", "localhost")
+
+	acmePollInterval = 200 * time.Millisecond
+	t.Cleanup(func() {
+		tlsDir = "/opt/wg_serf/tls"
+		acmePollInterval = 2 * time.Second
+	})
+
+	ports := map[string]string{
+		challengeHTTP:    envOr("PEBBLE_HTTP_PORT", "5002"),
+		challengeTLSALPN: envOr("PEBBLE_TLS_PORT", "5001"),
+	}
+	for _, challenge := range []string{challengeHTTP, challengeTLSALPN} {
+		t.Run(challenge, func(t *testing.T) {
+			tlsDir = t.TempDir()
+			m, err := NewManager(database.TLSConfig{
+				Mode:          database.TLSModeACME,
+				Domains:       []string{domain},
+				ACMEDirectory: directory,
+				ACMEEmail:     "admin@example.com",
+				ACMEChallenge: challenge,
+				ACMECACert:    caCert,
+				HTTPPort:      ports[challenge],
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Pebble проверяет домен на этих портах
+			if challenge == challengeHTTP {
+				ln, err := net.Listen("tcp", ":"+m.HTTPPort())
+				if err != nil {
+					t.Fatal(err)
+				}
+				srv := &http.Server{Handler: m.HTTPHandler("443")}
+				go srv.Serve(ln)
+				t.Cleanup(func() { srv.Close() })
+			} else {
+				ln, err := tls.Listen("tcp", ":"+ports[challenge], m.TLSConfig())
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { ln.Close() })
+				go func() {
+					for {
+						conn, err := ln.Accept()
+						if err != nil {
+							return
+						}
+						conn.(*tls.Conn).Handshake()
+						conn.Close()
+					}
+				}()
+			}
+
+			cert, err := m.acme.obtain(m.cfg.Domains)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cert.Leaf.DNSNames) != 1 || cert.Leaf.DNSNames[0] != domain {
+				t.Errorf("DNSNames = %v, want [%s]", cert.Leaf.DNSNames, domain)
+			}
+			if cert.Leaf.Issuer.String() == cert.Leaf.Subject.String() {
+				t.Errorf("certificate is self-signed: %s", cert.Leaf.Issuer)
+			}
+		})
+	}
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,549 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"wg-panel/internal/database"
+)
+
+// jwsMessage запрос ACME в формате Flattened JSON
+type jwsMessage struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type jwsHeader struct {
+	Alg   string            `json:"alg"`
+	Nonce string            `json:"nonce"`
+	URL   string            `json:"url"`
+	Kid   string            `json:"kid"`
+	JWK   map[string]string `json:"jwk"`
+}
+
+// jwkPublicKey восстанавливает ключ P-256 из JWK
+func jwkPublicKey(jwk map[string]string) (*ecdsa.PublicKey, error) {
+	if jwk["kty"] != "EC" || jwk["crv"] != "P-256" {
+		return nil, fmt.Errorf("unexpected jwk %v", jwk)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(jwk["x"])
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(jwk["y"])
+	if err != nil {
+		return nil, err
+	}
+	if len(x) != 32 || len(y) != 32 {
+		return nil, fmt.Errorf("jwk coordinates are %d and %d bytes, want 32", len(x), len(y))
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
+
+// verifyJWS проверяет подпись ES256 (r||s по 32 байта) и возвращает заголовок и payload
+func verifyJWS(body []byte, key *ecdsa.PublicKey) (*jwsHeader, []byte, error) {
+	var msg jwsMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, nil, err
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(msg.Protected)
+	if err != nil {
+		return nil, nil, err
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return nil, nil, err
+	}
+	if header.Alg != "ES256" {
+		return nil, nil, fmt.Errorf("alg = %q, want ES256", header.Alg)
+	}
+	if key == nil {
+		if key, err = jwkPublicKey(header.JWK); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(signature) != 64 {
+		return nil, nil, fmt.Errorf("signature is %d bytes, want 64", len(signature))
+	}
+	digest := sha256.Sum256([]byte(msg.Protected + "." + msg.Payload))
+	r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(key, digest[:], r, s) {
+		return nil, nil, fmt.Errorf("bad signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(msg.Payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &header, payload, nil
+}
+
+// newTestClient клиент ACME с новым ключом без обращения к диску
+func newTestClient(t *testing.T, directoryURL string) *acmeClient {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &acmeClient{
+		directoryURL: directoryURL,
+		challenge:    challengeHTTP,
+		client:       http.DefaultClient,
+		key:          key,
+		httpTokens:   make(map[string]string),
+		alpnCerts:    make(map[string]*tls.Certificate),
+	}
+}
+
+// Подпись всегда 64 байта: r и s дополняются нулями слева, даже если короче 32 байт
+func TestSignedBodyES256(t *testing.T) {
+	c := newTestClient(t, "")
+	c.dir = &acmeDirectory{}
+
+	short := 0
+	for i := 0; i < 2000 && short < 2; i++ {
+		c.nonce = fmt.Sprintf("nonce-%d", i)
+		body, err := c.signedBody("https://acme.test/new-order", map[string]string{"n": fmt.Sprint(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, payload, err := verifyJWS(body, &c.key.PublicKey)
+		if err != nil {
+			t.Fatalf("signature %d: %v", i, err)
+		}
+		if header.Nonce != fmt.Sprintf("nonce-%d", i) || header.URL != "https://acme.test/new-order" {
+			t.Fatalf("header = %+v", header)
+		}
+		if string(payload) != fmt.Sprintf(`{"n":"%d"}`, i) {
+			t.Fatalf("payload = %s", payload)
+		}
+
+		var msg jwsMessage
+		json.Unmarshal(body, &msg)
+		signature, _ := base64.RawURLEncoding.DecodeString(msg.Signature)
+		if signature[0] == 0 || signature[32] == 0 {
+			short++
+		}
+	}
+	if short == 0 {
+		t.Fatal("no signature with a short r or s in 2000 attempts")
+	}
+}
+
+func TestSignedBodyHeader(t *testing.T) {
+	c := newTestClient(t, "")
+	c.dir = &acmeDirectory{}
+
+	// До регистрации аккаунта - jwk, после - kid
+	c.nonce = "first"
+	body, err := c.signedBody("https://acme.test/new-account", map[string]bool{"termsOfServiceAgreed": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, _, err := verifyJWS(body, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Kid != "" || header.JWK["x"] == "" {
+		t.Errorf("new-account header = %+v, want jwk", header)
+	}
+	if c.nonce != "" {
+		t.Errorf("nonce %q is not consumed", c.nonce)
+	}
+
+	c.kid = "https://acme.test/acct/1"
+	c.nonce = "second"
+	body, err = c.signedBody("https://acme.test/order/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, payload, err := verifyJWS(body, &c.key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Kid != c.kid || header.JWK != nil {
+		t.Errorf("order header = %+v, want kid", header)
+	}
+	if len(payload) != 0 {
+		t.Errorf("POST-as-GET payload = %q, want empty", payload)
+	}
+}
+
+// Отпечаток JWK совпадает с RFC 7638 (json.Marshal сортирует ключи карты)
+func TestThumbprint(t *testing.T) {
+	c := newTestClient(t, "")
+	canonical, _ := json.Marshal(c.jwk())
+	sum := sha256.Sum256(canonical)
+	if got, want := c.thumbprint(), base64.RawURLEncoding.EncodeToString(sum[:]); got != want {
+		t.Errorf("thumbprint = %s, want %s", got, want)
+	}
+}
+
+// acmeStub минимальный ACME сервер: проверяет подписи и одноразовость nonce,
+// проверку владения доменом выполняет через validate
+type acmeStub struct {
+	t        *testing.T
+	srv      *httptest.Server
+	domain   string
+	token    string
+	validate func(challenge, domain, keyAuth string) error
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+
+	mu          sync.Mutex
+	nonces      map[string]bool // Выданные и еще не использованные
+	nonceSeq    int
+	nonceHeads  int
+	badNonces   int // Сколько запросов отклонить с badNonce
+	account     *ecdsa.PublicKey
+	authzStatus string
+	orderStatus string
+	certDER     []byte
+}
+
+func newACMEStub(t *testing.T, domain string, validate func(challenge, domain, keyAuth string) error) *acmeStub {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stub ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(der)
+
+	s := &acmeStub{
+		t:           t,
+		domain:      domain,
+		token:       "stub-token_1",
+		validate:    validate,
+		caKey:       caKey,
+		caCert:      caCert,
+		nonces:      make(map[string]bool),
+		authzStatus: "pending",
+		orderStatus: "pending",
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+func (s *acmeStub) url(path string) string { return s.srv.URL + path }
+
+// newNonce выдает nonce (вызывается под s.mu)
+func (s *acmeStub) newNonce(w http.ResponseWriter) {
+	s.nonceSeq++
+	nonce := fmt.Sprintf("nonce-%d", s.nonceSeq)
+	s.nonces[nonce] = true
+	w.Header().Set("Replay-Nonce", nonce)
+}
+
+func (s *acmeStub) problem(w http.ResponseWriter, status int, kind, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(acmeProblem{Type: "urn:ietf:params:acme:error:" + kind, Detail: detail})
+}
+
+func (s *acmeStub) reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *acmeStub) authz() map[string]interface{} {
+	return map[string]interface{}{
+		"status":     s.authzStatus,
+		"identifier": map[string]string{"type": "dns", "value": s.domain},
+		"challenges": []acmeChallenge{
+			{Type: challengeHTTP, URL: s.url("/chall/" + challengeHTTP), Token: s.token, Status: "pending"},
+			{Type: challengeTLSALPN, URL: s.url("/chall/" + challengeTLSALPN), Token: s.token, Status: "pending"},
+		},
+	}
+}
+
+func (s *acmeStub) order() acmeOrder {
+	order := acmeOrder{
+		Status:         s.orderStatus,
+		Authorizations: []string{s.url("/authz/1")},
+		Finalize:       s.url("/finalize/1"),
+	}
+	if s.orderStatus == "valid" {
+		order.Certificate = s.url("/cert/1")
+	}
+	return order
+}
+
+func (s *acmeStub) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/dir":
+		s.reply(w, http.StatusOK, acmeDirectory{NewNonce: s.url("/nonce"), NewAccount: s.url("/account"), NewOrder: s.url("/order")})
+		return
+	case r.Method == "HEAD" && r.URL.Path == "/nonce":
+		s.nonceHeads++
+		s.newNonce(w)
+		return
+	case r.Method != "POST":
+		http.NotFound(w, r)
+		return
+	}
+
+	s.newNonce(w)
+	if r.Header.Get("Content-Type") != "application/jose+json" {
+		s.problem(w, http.StatusUnsupportedMediaType, "malformed", "wrong content type")
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	header, payload, err := verifyJWS(body, s.account)
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	if !s.nonces[header.Nonce] {
+		s.problem(w, http.StatusBadRequest, "badNonce", "nonce reused or unknown: "+header.Nonce)
+		return
+	}
+	delete(s.nonces, header.Nonce)
+	if s.badNonces > 0 {
+		s.badNonces--
+		s.problem(w, http.StatusBadRequest, "badNonce", "injected")
+		return
+	}
+	if header.URL != s.url(r.URL.Path) {
+		s.problem(w, http.StatusUnauthorized, "unauthorized", "url mismatch: "+header.URL)
+		return
+	}
+	if r.URL.Path == "/account" {
+		if header.JWK == nil || header.Kid != "" {
+			s.problem(w, http.StatusBadRequest, "malformed", "new-account must use jwk")
+			return
+		}
+		s.account, _ = jwkPublicKey(header.JWK)
+		w.Header().Set("Location", s.url("/acct/1"))
+		s.reply(w, http.StatusCreated, map[string]string{"status": "valid"})
+		return
+	}
+	if header.Kid != s.url("/acct/1") {
+		s.problem(w, http.StatusUnauthorized, "accountDoesNotExist", "kid = "+header.Kid)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/order":
+		var req struct {
+			Identifiers []map[string]string `json:"identifiers"`
+		}
+		json.Unmarshal(payload, &req)
+		if len(req.Identifiers) != 1 || req.Identifiers[0]["value"] != s.domain {
+			s.problem(w, http.StatusBadRequest, "rejectedIdentifier", fmt.Sprint(req.Identifiers))
+			return
+		}
+		w.Header().Set("Location", s.url("/order/1"))
+		s.reply(w, http.StatusCreated, s.order())
+
+	case r.URL.Path == "/order/1":
+		// Заказ выпускается со второго опроса
+		if s.orderStatus == "processing" {
+			s.orderStatus = "valid"
+		}
+		s.reply(w, http.StatusOK, s.order())
+
+	case r.URL.Path == "/authz/1":
+		if len(payload) != 0 {
+			s.problem(w, http.StatusBadRequest, "malformed", "POST-as-GET with payload")
+			return
+		}
+		s.reply(w, http.StatusOK, s.authz())
+
+	case strings.HasPrefix(r.URL.Path, "/chall/"):
+		if string(payload) != "{}" {
+			s.problem(w, http.StatusBadRequest, "malformed", "challenge payload must be {}")
+			return
+		}
+		canonical, _ := json.Marshal(map[string]string{
+			"crv": "P-256", "kty": "EC",
+			"x": b64(s.account.X.FillBytes(make([]byte, 32))),
+			"y": b64(s.account.Y.FillBytes(make([]byte, 32))),
+		})
+		thumbprint := sha256.Sum256(canonical)
+		keyAuth := s.token + "." + b64(thumbprint[:])
+
+		s.authzStatus = "valid"
+		if err := s.validate(strings.TrimPrefix(r.URL.Path, "/chall/"), s.domain, keyAuth); err != nil {
+			s.t.Errorf("validation: %v", err)
+			s.authzStatus = "invalid"
+		}
+		s.reply(w, http.StatusOK, map[string]string{"status": "processing"})
+
+	case r.URL.Path == "/finalize/1":
+		if s.authzStatus != "valid" {
+			s.problem(w, http.StatusForbidden, "orderNotReady", "authorization is "+s.authzStatus)
+			return
+		}
+		var req struct {
+			CSR string `json:"csr"`
+		}
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil || csr.CheckSignature() != nil || len(csr.DNSNames) != 1 || csr.DNSNames[0] != s.domain {
+			s.problem(w, http.StatusBadRequest, "badCSR", fmt.Sprint(err))
+			return
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: s.domain},
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		s.certDER, _ = x509.CreateCertificate(rand.Reader, template, s.caCert, csr.PublicKey, s.caKey)
+		s.orderStatus = "processing"
+		s.reply(w, http.StatusOK, s.order())
+
+	case r.URL.Path == "/cert/1":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: s.certDER})
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: s.caCert.Raw})
+
+	default:
+		s.problem(w, http.StatusNotFound, "malformed", "unknown "+r.URL.Path)
+	}
+}
+
+// Полный выпуск через Manager: аккаунт, заказ, проверка, CSR, опрос заказа и цепочка
+func TestObtainAgainstStub(t *testing.T) {
+	acmePollInterval = 10 * time.Millisecond
+	t.Cleanup(func() {
+		tlsDir = "/opt/wg_serf/tls"
+		acmePollInterval = 2 * time.Second
+	})
+
+	const domain = "panel.example.test"
+
+	for _, challenge := range []string{challengeHTTP, challengeTLSALPN} {
+		t.Run(challenge, func(t *testing.T) {
+			tlsDir = t.TempDir()
+			var m *Manager
+			stub := newACMEStub(t, domain, func(kind, domain, keyAuth string) error {
+				if kind != challenge {
+					return fmt.Errorf("client answered %s, configured %s", kind, challenge)
+				}
+				switch kind {
+				case challengeHTTP:
+					w := httptest.NewRecorder()
+					m.HTTPHandler("443").ServeHTTP(w, httptest.NewRequest("GET", "http://"+domain+"/.well-known/acme-challenge/stub-token_1", nil))
+					if w.Code != http.StatusOK || w.Body.String() != keyAuth {
+						return fmt.Errorf("http-01 answer %d %q, want %q", w.Code, w.Body.String(), keyAuth)
+					}
+
+				case challengeTLSALPN:
+					cert, err := m.getCertificate(&tls.ClientHelloInfo{ServerName: domain, SupportedProtos: []string{acmeALPNProto}})
+					if err != nil {
+						return err
+					}
+					leaf, err := x509.ParseCertificate(cert.Certificate[0])
+					if err != nil {
+						return err
+					}
+					if len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != domain {
+						return fmt.Errorf("tls-alpn-01 SAN = %v", leaf.DNSNames)
+					}
+					want := sha256.Sum256([]byte(keyAuth))
+					for _, ext := range leaf.Extensions {
+						if !ext.Id.Equal(oidACMEIdentifier) {
+							continue
+						}
+						var got []byte
+						if _, err := asn1.Unmarshal(ext.Value, &got); err != nil {
+							return err
+						}
+						if !ext.Critical || string(got) != string(want[:]) {
+							return fmt.Errorf("acmeIdentifier critical=%v value=%x, want %x", ext.Critical, got, want)
+						}
+						return nil
+					}
+					return fmt.Errorf("no acmeIdentifier extension")
+				}
+				return nil
+			})
+			// Первый запрос заказа отклоняется с badNonce - клиент повторяет со свежим nonce
+			stub.badNonces = 1
+
+			var err error
+			m, err = NewManager(database.TLSConfig{
+				Mode:          database.TLSModeACME,
+				Domains:       []string{domain},
+				ACMEDirectory: stub.url("/dir"),
+				ACMEChallenge: challenge,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !m.needsRenewal() {
+				t.Fatal("self-signed placeholder should need renewal")
+			}
+
+			cert, err := m.acme.obtain(m.cfg.Domains)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cert.Leaf.Issuer.CommonName != "stub ACME CA" || len(cert.Certificate) != 2 {
+				t.Errorf("issuer = %s, chain = %d", cert.Leaf.Issuer, len(cert.Certificate))
+			}
+			if _, err := loadCertPair(acmeCertPath(), acmeKeyPath()); err != nil {
+				t.Errorf("saved certificate: %v", err)
+			}
+
+			// Ответы на проверку убраны после завершения
+			if _, ok := m.acme.httpKeyAuth(stub.token); ok || m.acme.alpnCertificate(domain) != nil {
+				t.Error("challenge answers are still served")
+			}
+
+			// Новый nonce берется через HEAD только для первого запроса,
+			// дальше - из Replay-Nonce ответов, включая ответ с badNonce
+			if stub.nonceHeads != 1 {
+				t.Errorf("newNonce requested %d times, want 1", stub.nonceHeads)
+			}
+		})
+	}
+}
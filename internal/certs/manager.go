@@ -0,0 +1,260 @@
+package certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"wg-panel/internal/database"
+)
+
+// tlsDir каталог сертификатов панели (переменная, чтобы тесты могли подменить)
+var tlsDir = "/opt/wg_serf/tls"
+
+const (
+	fileCheckPeriod  = 10 * time.Second    // Как часто проверять изменение файлов сертификата
+	renewCheckPeriod = 12 * time.Hour      // Как часто проверять срок действия ACME сертификата
+	renewBefore      = 30 * 24 * time.Hour // За сколько до истечения обновлять
+	acmeRetryPeriod  = 10 * time.Minute    // Повтор после неудачного выпуска
+	letsEncryptURL   = "https://acme-v02.api.letsencrypt.org/directory"
+	acmeALPNProto    = "acme-tls/1"
+)
+
+// Manager выдает сертификат веб-панели в зависимости от режима TLS
+type Manager struct {
+	cfg database.TLSConfig
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	certMod   time.Time // Время изменения файлов (режим file)
+	keyMod    time.Time
+	lastCheck time.Time
+
+	acme *acmeClient
+}
+
+// NewManager создает менеджер сертификатов и загружает текущий сертификат
+func NewManager(cfg database.TLSConfig) (*Manager, error) {
+	if err := os.MkdirAll(tlsDir, 0700); err != nil {
+		return nil, err
+	}
+
+	m := &Manager{cfg: cfg}
+
+	switch cfg.Mode {
+	case database.TLSModeSelfSigned:
+		cert, err := loadOrCreateSelfSigned(cfg.Domains)
+		if err != nil {
+			return nil, err
+		}
+		m.cert = cert
+
+	case database.TLSModeFile:
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, fmt.Errorf("для режима file нужны cert_file и key_file")
+		}
+		if err := m.reloadFiles(); err != nil {
+			return nil, err
+		}
+
+	case database.TLSModeACME:
+		if len(cfg.Domains) == 0 {
+			return nil, fmt.Errorf("для режима acme нужен хотя бы один домен")
+		}
+		client, err := newACMEClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		m.acme = client
+
+		// Пока сертификат не выпущен - работаем на самоподписанном
+		if cert, err := loadCertPair(acmeCertPath(), acmeKeyPath()); err == nil {
+			m.cert = cert
+		} else if cert, err := loadOrCreateSelfSigned(cfg.Domains); err == nil {
+			m.cert = cert
+		} else {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("неизвестный режим TLS: %s", cfg.Mode)
+	}
+
+	return m, nil
+}
+
+// TLSConfig возвращает конфигурацию для http.Server
+func (m *Manager) TLSConfig() *tls.Config {
+	protos := []string{"h2", "http/1.1"}
+	if m.acme != nil && m.challengeType() == challengeTLSALPN {
+		protos = append(protos, acmeALPNProto)
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     protos,
+		GetCertificate: m.getCertificate,
+	}
+}
+
+// getCertificate выбирает сертификат для TLS рукопожатия
+func (m *Manager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	// Проверка TLS-ALPN-01 от ACME сервера
+	if m.acme != nil && len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acmeALPNProto {
+		if cert := m.acme.alpnCertificate(hello.ServerName); cert != nil {
+			return cert, nil
+		}
+		return nil, fmt.Errorf("нет tls-alpn-01 сертификата для %s", hello.ServerName)
+	}
+
+	if m.cfg.Mode == database.TLSModeFile {
+		m.checkFiles()
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cert, nil
+}
+
+// checkFiles перечитывает сертификат если файлы изменились (не чаще fileCheckPeriod)
+func (m *Manager) checkFiles() {
+	m.mu.RLock()
+	due := time.Since(m.lastCheck) > fileCheckPeriod
+	m.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	if err := m.reloadFiles(); err != nil {
+		log.Printf("⚠️  TLS: не удалось перечитать сертификат: %v", err)
+	}
+}
+
+// reloadFiles загружает сертификат из cert_file/key_file если они изменились
+func (m *Manager) reloadFiles() error {
+	certInfo, err := os.Stat(m.cfg.CertFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(m.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.lastCheck = time.Now()
+	changed := m.cert == nil || !certInfo.ModTime().Equal(m.certMod) || !keyInfo.ModTime().Equal(m.keyMod)
+	m.mu.Unlock()
+	if !changed {
+		return nil
+	}
+
+	cert, err := loadCertPair(m.cfg.CertFile, m.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	if m.cert != nil {
+		log.Printf("🔐 TLS: сертификат %s перечитан", m.cfg.CertFile)
+	}
+	m.cert = cert
+	m.certMod = certInfo.ModTime()
+	m.keyMod = keyInfo.ModTime()
+	m.mu.Unlock()
+	return nil
+}
+
+// HTTPHandler обрабатывает HTTP-01 проверки и перенаправляет остальное на HTTPS
+func (m *Manager) HTTPHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.acme != nil && strings.HasPrefix(r.URL.Path, "/.well-known/acme-challenge/") {
+			token := strings.TrimPrefix(r.URL.Path, "/.well-known/acme-challenge/")
+			if keyAuth, ok := m.acme.httpKeyAuth(token); ok {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte(keyAuth))
+				return
+			}
+			http.NotFound(w, r)
+			return
+		}
+
+		host := r.Host
+		if i := strings.LastIndex(host, ":"); i != -1 && !strings.HasSuffix(host, "]") {
+			host = host[:i]
+		}
+		if httpsPort != "443" {
+			host = host + ":" + httpsPort
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// HTTPPort возвращает порт для HTTP-01 и редиректа
+func (m *Manager) HTTPPort() string {
+	if m.cfg.HTTPPort != "" {
+		return m.cfg.HTTPPort
+	}
+	return "80"
+}
+
+// NeedsHTTPListener нужен ли обычный HTTP слушатель (для HTTP-01)
+func (m *Manager) NeedsHTTPListener() bool {
+	return m.acme != nil && m.challengeType() == challengeHTTP
+}
+
+// Start запускает фоновый выпуск и обновление ACME сертификата
+func (m *Manager) Start() {
+	if m.acme == nil {
+		return
+	}
+	go m.renewLoop()
+}
+
+// renewLoop выпускает сертификат и обновляет его перед истечением
+func (m *Manager) renewLoop() {
+	for {
+		wait := renewCheckPeriod
+		if m.needsRenewal() {
+			log.Printf("🔐 ACME: выпуск сертификата для %s...", strings.Join(m.cfg.Domains, ", "))
+			cert, err := m.acme.obtain(m.cfg.Domains)
+			if err != nil {
+				log.Printf("❌ ACME: ошибка выпуска сертификата: %v", err)
+				wait = acmeRetryPeriod
+			} else {
+				m.mu.Lock()
+				m.cert = cert
+				m.mu.Unlock()
+				log.Printf("✅ ACME: сертификат выпущен, действует до %s", cert.Leaf.NotAfter.Format("2006-01-02"))
+			}
+		}
+		time.Sleep(wait)
+	}
+}
+
+// needsRenewal проверяет что ACME сертификат отсутствует или скоро истечет
+func (m *Manager) needsRenewal() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.cert == nil || m.cert.Leaf == nil {
+		return true
+	}
+	// Самоподписанная заглушка - выпускаем настоящий
+	if m.cert.Leaf.Issuer.String() == m.cert.Leaf.Subject.String() {
+		return true
+	}
+	return time.Until(m.cert.Leaf.NotAfter) < renewBefore
+}
+
+// challengeType возвращает тип ACME проверки
+func (m *Manager) challengeType() string {
+	if m.cfg.ACMEChallenge == challengeTLSALPN {
+		return challengeTLSALPN
+	}
+	return challengeHTTP
+}
@@ -0,0 +1,115 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const selfSignedValidity = 10 * 365 * 24 * time.Hour
+
+func selfSignedCertPath() string { return filepath.Join(tlsDir, "selfsigned.crt") }
+func selfSignedKeyPath() string  { return filepath.Join(tlsDir, "selfsigned.key") }
+
+// loadOrCreateSelfSigned загружает самоподписанный сертификат или создает новый
+func loadOrCreateSelfSigned(domains []string) (*tls.Certificate, error) {
+	if cert, err := loadCertPair(selfSignedCertPath(), selfSignedKeyPath()); err == nil {
+		if time.Until(cert.Leaf.NotAfter) > 24*time.Hour {
+			return cert, nil
+		}
+		log.Println("🔐 TLS: самоподписанный сертификат истекает, создаю новый...")
+	}
+
+	log.Println("🔐 TLS: создаю самоподписанный сертификат...")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "wg_serf self-signed"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              append([]string{"localhost"}, domains...),
+		IPAddresses:           localIPs(),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeCertPair(selfSignedCertPath(), selfSignedKeyPath(), [][]byte{der}, key); err != nil {
+		return nil, err
+	}
+
+	return loadCertPair(selfSignedCertPath(), selfSignedKeyPath())
+}
+
+// localIPs возвращает IP адреса сервера для SAN
+func localIPs() []net.IP {
+	ips := []net.IP{net.IPv4(127, 0, 0, 1)}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	return ips
+}
+
+// loadCertPair загружает сертификат и ключ из PEM файлов
+func loadCertPair(certPath, keyPath string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+		cert.Leaf = leaf
+	}
+	return &cert, nil
+}
+
+// writeCertPair сохраняет цепочку сертификатов и ключ в PEM файлы
+func writeCertPair(certPath, keyPath string, chain [][]byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	return os.WriteFile(certPath, certPEM, 0644)
+}
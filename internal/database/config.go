@@ -5,17 +5,19 @@ import (
 	"os"
 )
 
-const configFile = "/opt/wg_serf/config.json"
+// ConfigFile путь к config.json (переменная - чтобы тесты работали с временным конфигом)
+var ConfigFile = "/opt/wg_serf/config.json"
 
 // LoadConfig загружает конфигурацию из config.json
 func LoadConfig() (*Config, error) {
-	if _, err := os.Stat(configFile); os.IsNotExist(err) {
+	if _, err := os.Stat(ConfigFile); os.IsNotExist(err) {
 		// Создаем дефолтную конфигурацию
 		config := Config{
 			Port:     "8080",
 			Address:  "0.0.0.0",
 			Username: "admin",
 			Password: "admin",
+			TLS:      TLSConfig{Mode: TLSModeOff},
 		}
 		if err := SaveConfig(&config); err != nil {
 			return nil, err
@@ -23,21 +25,37 @@ func LoadConfig() (*Config, error) {
 		return &config, nil
 	}
 
-	data, err := os.ReadFile(configFile)
+	data, err := os.ReadFile(ConfigFile)
 	if err != nil {
 		return nil, err
 	}
+	// config.json старых версий создавался с 0644
+	if err := os.Chmod(ConfigFile, 0600); err != nil {
+		return nil, err
+	}
 
 	var config Config
 	err = json.Unmarshal(data, &config)
 	return &config, err
 }
 
-// SaveConfig сохраняет конфигурацию в config.json
+// SaveConfig сохраняет конфигурацию в config.json. В файле секреты TOTP и хеши
+// паролей и токенов, поэтому он доступен только root (права старых файлов тоже правим)
 func SaveConfig(config *Config) error {
 	data, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(configFile, data, 0644)
+	if err := os.WriteFile(ConfigFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(ConfigFile, 0600)
+}
+
+// PanelScheme возвращает схему веб-панели (http или https)
+func PanelScheme(config *Config) string {
+	if config.TLS.Mode != "" && config.TLS.Mode != TLSModeOff {
+		return "https"
+	}
+	return "http"
 }
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// config.json с секретами 2FA доступен только владельцу, в том числе созданный старой версией
+func TestConfigFileMode(t *testing.T) {
+	ConfigFile = filepath.Join(t.TempDir(), "config.json")
+
+	if _, err := LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, 0600)
+
+	if err := os.Chmod(ConfigFile, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, 0600)
+
+	os.Chmod(ConfigFile, 0644)
+	if err := SaveConfig(&Config{Username: "admin"}); err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, 0600)
+}
+
+func assertMode(t *testing.T, want os.FileMode) {
+	t.Helper()
+	info, err := os.Stat(ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != want {
+		t.Errorf("config.json mode = %o, want %o", info.Mode().Perm(), want)
+	}
+}
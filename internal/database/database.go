@@ -3,14 +3,20 @@ package database
 import (
 	"encoding/json"
 	"os"
+	"sync"
 )
 
-const dbFile = "/opt/wg_serf/db.json"
+// DBFile путь к db.json (переменная - чтобы тесты работали с временной БД)
+var DBFile = "/opt/wg_serf/db.json"
+
+// Mu защищает загруженную БД: ее меняют запросы API и фоновые циклы
+// (статистика, истечение клиентов, проверка расхождений)
+var Mu sync.RWMutex
 
 // LoadDatabase загружает базу данных из db.json
 func LoadDatabase() (*Database, error) {
 	var db Database
-	data, err := os.ReadFile(dbFile)
+	data, err := os.ReadFile(DBFile)
 	if err != nil {
 		return nil, err
 	}
@@ -24,5 +30,32 @@ func SaveDatabase(db *Database) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(dbFile, data, 0644)
+	return os.WriteFile(DBFile, data, 0644)
+}
+
+// RegisterInterface отмечает интерфейс как созданный или перенесенный панелью
+func RegisterInterface(db *Database, iface string) {
+	if !IsManagedInterface(db, iface) {
+		db.ManagedInterfaces = append(db.ManagedInterfaces, iface)
+	}
+}
+
+// UnregisterInterface убирает интерфейс из реестра
+func UnregisterInterface(db *Database, iface string) {
+	for i, name := range db.ManagedInterfaces {
+		if name == iface {
+			db.ManagedInterfaces = append(db.ManagedInterfaces[:i], db.ManagedInterfaces[i+1:]...)
+			return
+		}
+	}
+}
+
+// IsManagedInterface проверяет, принадлежит ли интерфейс панели
+func IsManagedInterface(db *Database, iface string) bool {
+	for _, name := range db.ManagedInterfaces {
+		if name == iface {
+			return true
+		}
+	}
+	return false
 }
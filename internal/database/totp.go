@@ -0,0 +1,121 @@
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod        = 30 // Шаг времени в секундах (RFC 6238)
+	totpDigits        = 6
+	totpSkew          = 1 // Допустимое отклонение в шагах
+	recoveryCodeCount = 10
+)
+
+// GenerateTOTPSecret генерирует случайный секрет в base32
+func GenerateTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf), nil
+}
+
+// TOTPURI формирует otpauth:// ссылку для приложения-аутентификатора
+func TOTPURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", totpDigits))
+	params.Set("period", fmt.Sprintf("%d", totpPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// totpCode вычисляет код для шага времени (RFC 4226 HOTP)
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000), nil
+}
+
+// VerifyTOTP проверяет код и защищает от повторного использования
+func VerifyTOTP(tf *TwoFactor, code string, now time.Time) bool {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= tf.LastStep {
+			continue
+		}
+		expected, err := totpCode(tf.Secret, step)
+		if err != nil {
+			return false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			tf.LastStep = step
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateRecoveryCodes генерирует коды восстановления, возвращает коды и их хеши
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	var codes, hashes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(buf)
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// UseRecoveryCode проверяет код восстановления и удаляет его (одноразовый)
+func UseRecoveryCode(tf *TwoFactor, code string) bool {
+	hash := hashRecoveryCode(code)
+	for i, h := range tf.RecoveryCodes {
+		if hmac.Equal([]byte(h), []byte(hash)) {
+			tf.RecoveryCodes = append(tf.RecoveryCodes[:i], tf.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// hashRecoveryCode хеширует код восстановления
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
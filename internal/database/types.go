@@ -6,8 +6,118 @@ import "time"
 type Config struct {
 	Port     string `json:"port"`
 	Address  string `json:"address"`
-	Username string `json:"username"`
+	Username string `json:"username"` // Основной администратор (всегда owner)
 	Password string `json:"password"`
+	Users    []User `json:"users"` // Дополнительные пользователи панели
+
+	OwnerTwoFactor *TwoFactor `json:"owner_two_factor,omitempty"` // 2FA основного администратора
+
+	TrustedProxies []string `json:"trusted_proxies"` // IP/CIDR прокси, которым доверяем X-Forwarded-For
+
+	TLS TLSConfig `json:"tls"`
+
+	FirewallMode    string `json:"firewall_mode"`    // managed (по умолчанию) или exclusive
+	FirewallBackend string `json:"firewall_backend"` // auto (по умолчанию), iptables или nftables
+
+	DriftCheck DriftConfig `json:"drift_check"`
+
+	DNS DNSConfig `json:"dns"`
+
+	ForwardDenylist []string `json:"forward_denylist"` // Порты, которые нельзя пробрасывать: 25, 135-139/tcp, 53/udp
+
+	APITokens []APIToken `json:"api_tokens"`
+}
+
+// APIToken долгоживущий токен для программного доступа к API
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Username   string     `json:"username"` // Владелец токена
+	Role       string     `json:"role"`     // Не выше роли владельца
+	Servers    []string   `json:"servers"`  // Ограничение по серверам (пусто - как у владельца)
+	TokenHash  string     `json:"token_hash"`
+	Prefix     string     `json:"prefix"` // Начало токена для отображения
+	AllowedIPs []string   `json:"allowed_ips"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Режимы TLS для веб-панели
+const (
+	TLSModeOff        = "off"         // Обычный HTTP
+	TLSModeSelfSigned = "self-signed" // Самоподписанный сертификат в /opt/wg_serf/tls
+	TLSModeFile       = "file"        // Свой сертификат (перечитывается при изменении файлов)
+	TLSModeACME       = "acme"        // Выпуск через ACME (Let's Encrypt и др.)
+)
+
+// TLSConfig настройки HTTPS для веб-панели
+type TLSConfig struct {
+	Mode          string   `json:"mode"`
+	CertFile      string   `json:"cert_file"`      // Для режима file
+	KeyFile       string   `json:"key_file"`       // Для режима file
+	Domains       []string `json:"domains"`        // Домены для ACME и SAN самоподписанного сертификата
+	ACMEDirectory string   `json:"acme_directory"` // URL каталога ACME (по умолчанию Let's Encrypt)
+	ACMEEmail     string   `json:"acme_email"`
+	ACMEChallenge string   `json:"acme_challenge"` // http-01 или tls-alpn-01
+	ACMECACert    string   `json:"acme_ca_cert"`   // PEM корневого сертификата ACME сервера (для тестового pebble)
+	HTTPPort      string   `json:"http_port"`      // Порт для HTTP-01 и редиректа на HTTPS (по умолчанию 80)
+}
+
+// DriftConfig фоновая проверка расхождений системы с БД
+type DriftConfig struct {
+	Interval   int  `json:"interval"`    // Секунды между проверками (0 - 60 секунд, меньше 0 - отключена)
+	AutoRepair bool `json:"auto_repair"` // Сразу исправлять найденные расхождения
+}
+
+// DNSConfig встроенный DNS сервер на адресах серверов в туннеле
+type DNSConfig struct {
+	Enabled   bool     `json:"enabled"`
+	Domain    string   `json:"domain"`    // Зона имен клиентов: <клиент>.<сервер>.<зона> (по умолчанию vpn)
+	Upstreams []string `json:"upstreams"` // Куда пересылать остальные запросы (пусто - DNS из настроек сервера)
+	Blocklist []string `json:"blocklist"` // Домены (вместе с поддоменами), на которые отвечаем NXDOMAIN
+
+	Blocklists   []string `json:"blocklists"`    // Списки блокировки для серверов с dns_filter: URL или путь к файлу
+	Allowlist    []string `json:"allowlist"`     // Домены, которые не блокируются, даже если есть в списках
+	RefreshHours int      `json:"refresh_hours"` // Как часто скачивать списки по URL (по умолчанию 24)
+}
+
+// Режимы работы с iptables
+const (
+	FirewallModeManaged   = "managed"   // Только свои цепочки WGSERF-*, чужие правила не трогаем
+	FirewallModeExclusive = "exclusive" // Сервер только для WireGuard: iptables очищается полностью
+)
+
+// Механизмы управления правилами
+const (
+	FirewallBackendAuto     = "auto"     // nftables, если хост использует его, иначе iptables
+	FirewallBackendIPTables = "iptables" // Цепочки WGSERF-* в таблицах filter и nat
+	FirewallBackendNFTables = "nftables" // Своя таблица ip wg_serf, загружается одной транзакцией
+)
+
+// Роли пользователей панели
+const (
+	RoleOwner    = "owner"    // Полный доступ, включая серверы и пользователей
+	RoleOperator = "operator" // Управление клиентами
+	RoleViewer   = "viewer"   // Только просмотр
+)
+
+// User структура для пользователя панели
+type User struct {
+	Username     string     `json:"username"`
+	PasswordHash string     `json:"password_hash"`
+	Role         string     `json:"role"`
+	Servers      []string   `json:"servers"` // ID доступных серверов (пусто - все)
+	CreatedAt    time.Time  `json:"created_at"`
+	TwoFactor    *TwoFactor `json:"two_factor,omitempty"`
+}
+
+// TwoFactor настройки двухфакторной аутентификации (TOTP, RFC 6238)
+type TwoFactor struct {
+	Secret        string   `json:"secret"`
+	Enabled       bool     `json:"enabled"`        // false - секрет выдан, но не подтвержден
+	LastStep      int64    `json:"last_step"`      // Последний использованный шаг (защита от повтора)
+	RecoveryCodes []string `json:"recovery_codes"` // SHA-256 хеши кодов восстановления
 }
 
 // Server структура для WireGuard сервера
@@ -25,13 +135,29 @@ type Server struct {
 	PostUp       string    `json:"post_up"`
 	PostDown     string    `json:"post_down"`
 	NextClientIP int       `json:"next_client_ip"`
+	Isolation    bool      `json:"isolation"`      // Клиенты не видят друг друга, кроме разрешенного в ACLs
+	ACLs         []ACLRule `json:"acls,omitempty"` // Разрешения между группами клиентов при изоляции
+	DNSFilter    bool      `json:"dns_filter"`     // Встроенный DNS блокирует домены из списков
+}
+
+// ACLRule разрешает клиентам одной группы обращаться к клиентам другой
+type ACLRule struct {
+	From        string `json:"from"`                  // Группа источника
+	To          string `json:"to"`                    // Группа назначения
+	Protocol    string `json:"protocol,omitempty"`    // tcp, udp или both (пусто - любой)
+	Ports       string `json:"ports,omitempty"`       // 22 или 8000-8100 (пусто - все, нужен протокол)
+	Description string `json:"description,omitempty"` // Описание
 }
 
 // PortForward структура для проброса порта
 type PortForward struct {
-	Port        int    `json:"port"`        // Порт (одинаковый внешний и внутренний)
-	Protocol    string `json:"protocol"`    // tcp, udp или both
-	Description string `json:"description"` // Описание
+	Port          int      `json:"port"`                     // Внешний порт (начало диапазона)
+	PortEnd       int      `json:"port_end,omitempty"`       // Конец внешнего диапазона (0 - один порт)
+	InternalPort  int      `json:"internal_port,omitempty"`  // Порт на клиенте (0 - как внешний)
+	Protocol      string   `json:"protocol"`                 // tcp, udp или both
+	SourceCIDRs   []string `json:"source_cidrs,omitempty"`   // С каких адресов разрешено (пусто - с любых)
+	AllInterfaces bool     `json:"all_interfaces,omitempty"` // Принимать на всех интерфейсах, а не только на внешнем
+	Description   string   `json:"description"`              // Описание
 }
 
 // Client структура для клиента WireGuard
@@ -40,7 +166,8 @@ type Client struct {
 	ServerID      string        `json:"server_id"`
 	Name          string        `json:"name"`
 	PublicKey     string        `json:"public_key"`
-	PrivateKey    string        `json:"private_key"`
+	PrivateKey    string        `json:"private_key"` // Пусто у клиентов, перенесенных из существующего конфига
+	PresharedKey  string        `json:"preshared_key,omitempty"`
 	Address       string        `json:"address"`
 	Enabled       bool          `json:"enabled"`
 	Comment       string        `json:"comment"`
@@ -50,10 +177,33 @@ type Client struct {
 	LastHandshake time.Time     `json:"last_handshake"`
 	Endpoint      string        `json:"endpoint"` // IP:Port клиента
 	PortForwards  []PortForward `json:"port_forwards"`
+	ExpiresAt     *time.Time    `json:"expires_at,omitempty"` // После этого времени клиент отключается (nil - бессрочно)
+	Groups        []string      `json:"groups,omitempty"`     // Группы для ACL изоляции сервера
+	Egress        *EgressPolicy `json:"egress,omitempty"`     // Ограничения исходящего трафика (nil - без ограничений)
+}
+
+// EgressPolicy куда клиенту можно ходить через сервер. Запреты проверяются первыми;
+// если список разрешений не пуст, все остальное запрещено, иначе BlockInternet
+// оставляет только частные сети (10/8, 172.16/12, 192.168/16, 100.64/10)
+type EgressPolicy struct {
+	BlockInternet bool         `json:"block_internet"`
+	Allow         []EgressRule `json:"allow,omitempty"`
+	Deny          []EgressRule `json:"deny,omitempty"`
+}
+
+// EgressRule назначение исходящего трафика
+type EgressRule struct {
+	CIDR     string `json:"cidr"`               // Сеть назначения (0.0.0.0/0 - любая)
+	Protocol string `json:"protocol,omitempty"` // tcp, udp или both (пусто - любой)
+	Ports    string `json:"ports,omitempty"`    // 443 или 8000-8100 (пусто - все, нужен протокол)
 }
 
 // Database структура для хранения данных
 type Database struct {
 	Servers []Server `json:"servers"`
 	Clients []Client `json:"clients"`
+
+	// Интерфейсы, созданные или перенесенные панелью. Интерфейс из реестра без
+	// сервера в БД - осиротевший и удаляется при синхронизации, чужие не трогаются
+	ManagedInterfaces []string `json:"managed_interfaces"`
 }
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const passwordIterations = 100000
+
+// IsValidRole проверяет что роль существует
+func IsValidRole(role string) bool {
+	return role == RoleOwner || role == RoleOperator || role == RoleViewer
+}
+
+// FindUser ищет пользователя по имени
+func FindUser(config *Config, username string) *User {
+	for i := range config.Users {
+		if config.Users[i].Username == username {
+			return &config.Users[i]
+		}
+	}
+	return nil
+}
+
+// OwnerUser возвращает основного администратора из полей Username/Password
+func OwnerUser(config *Config) User {
+	return User{
+		Username:  config.Username,
+		Role:      RoleOwner,
+		TwoFactor: config.OwnerTwoFactor,
+	}
+}
+
+// SetTwoFactor сохраняет настройки 2FA пользователя (nil - отключить)
+func SetTwoFactor(config *Config, username string, tf *TwoFactor) bool {
+	if username == config.Username {
+		config.OwnerTwoFactor = tf
+		return true
+	}
+	user := FindUser(config, username)
+	if user == nil {
+		return false
+	}
+	user.TwoFactor = tf
+	return true
+}
+
+// AuthenticateUser проверяет логин и пароль, возвращает пользователя
+func AuthenticateUser(config *Config, username, password string) (*User, bool) {
+	// Основной администратор хранится в открытом виде (показывается в CLI)
+	if username == config.Username {
+		if subtle.ConstantTimeCompare([]byte(password), []byte(config.Password)) == 1 {
+			owner := OwnerUser(config)
+			return &owner, true
+		}
+		return nil, false
+	}
+
+	user := FindUser(config, username)
+	if user == nil || !CheckPassword(user.PasswordHash, password) {
+		return nil, false
+	}
+	return user, true
+}
+
+// ResolveUser возвращает актуальные данные пользователя по имени
+func ResolveUser(config *Config, username string) *User {
+	if username == config.Username {
+		owner := OwnerUser(config)
+		return &owner
+	}
+	return FindUser(config, username)
+}
+
+// HashPassword хеширует пароль (PBKDF2-SHA256 со случайной солью)
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2SHA256([]byte(password), salt, passwordIterations, 32)
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// CheckPassword сравнивает пароль с хешем
+func CheckPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	key := pbkdf2SHA256([]byte(password), salt, iterations, len(expected))
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+// pbkdf2SHA256 реализует PBKDF2 (RFC 8018) с HMAC-SHA256
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+
+	var key []byte
+	buf := make([]byte, 4)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		buf[0], buf[1], buf[2], buf[3] = byte(block>>24), byte(block>>16), byte(block>>8), byte(block)
+		prf.Write(buf)
+		u := prf.Sum(nil)
+		t := make([]byte, len(u))
+		copy(t, u)
+
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// HashAPIToken хеширует API токен для хранения
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// FindAPIToken ищет токен по его значению
+func FindAPIToken(config *Config, token string) *APIToken {
+	hash := HashAPIToken(token)
+	for i := range config.APITokens {
+		if subtle.ConstantTimeCompare([]byte(config.APITokens[i].TokenHash), []byte(hash)) == 1 {
+			return &config.APITokens[i]
+		}
+	}
+	return nil
+}
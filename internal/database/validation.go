@@ -2,6 +2,7 @@ package database
 
 import (
 	"fmt"
+	"net"
 	"strings"
 )
 
@@ -57,12 +58,30 @@ func IsNetworkAvailable(db *Database, network string) bool {
 
 // IsPortAvailableForServer проверяет свободен ли порт для сервера
 func IsPortAvailableForServer(db *Database, port int) bool {
+	return CheckServerPort(db, "", port) == nil
+}
+
+// CheckServerPort проверяет, что UDP порт не занят другим сервером или пробросом
+// (serverID - сервер, которому меняют порт: его текущий порт не конфликт)
+func CheckServerPort(db *Database, serverID string, port int) error {
 	for _, server := range db.Servers {
-		if server.ListenPort == port {
-			return false
+		if server.ID != serverID && server.ListenPort == port {
+			return fmt.Errorf("порт %d занят сервером %s", port, server.Name)
 		}
 	}
-	return true
+	// UDP проброс перехватил бы трафик WireGuard
+	for _, client := range db.Clients {
+		for _, pf := range client.PortForwards {
+			end := pf.PortEnd
+			if end == 0 {
+				end = pf.Port
+			}
+			if pf.Protocol != "tcp" && pf.Port <= port && port <= end {
+				return fmt.Errorf("порт %d/udp проброшен на клиента %s", port, client.Name)
+			}
+		}
+	}
+	return nil
 }
 
 // ValidateServerConfig проверяет корректность конфигурации сервера
@@ -84,3 +103,52 @@ func ValidateServerConfig(db *Database, address string, port int) error {
 
 	return nil
 }
+
+// IsClientIPAvailable проверяет что IP не занят другим клиентом сервера
+func IsClientIPAvailable(db *Database, serverID, ip string) bool {
+	for _, client := range db.Clients {
+		if client.ServerID == serverID && client.Address == ip {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateClientIP проверяет статический IP клиента
+func ValidateClientIP(db *Database, server *Server, ip string) error {
+	parsed := net.ParseIP(ip).To4()
+	if parsed == nil {
+		return fmt.Errorf("неверный IP адрес: %s", ip)
+	}
+
+	serverIP, network, err := net.ParseCIDR(server.Address)
+	if err != nil {
+		return fmt.Errorf("неверная подсеть сервера: %s", server.Address)
+	}
+
+	if !network.Contains(parsed) {
+		return fmt.Errorf("IP %s вне подсети сервера %s", ip, network)
+	}
+
+	// Адрес сети и broadcast по маске сервера (в /31 и /32 их нет)
+	if ones, bits := network.Mask.Size(); bits-ones >= 2 {
+		base := network.IP.To4()
+		broadcast := make(net.IP, len(base))
+		for i := range base {
+			broadcast[i] = base[i] | ^network.Mask[i]
+		}
+		if parsed.Equal(base) || parsed.Equal(broadcast) {
+			return fmt.Errorf("IP %s зарезервирован", ip)
+		}
+	}
+
+	if parsed.Equal(serverIP) {
+		return fmt.Errorf("IP %s занят сервером", ip)
+	}
+
+	if !IsClientIPAvailable(db, server.ID, parsed.String()) {
+		return fmt.Errorf("IP %s уже занят", ip)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package database
+
+import "testing"
+
+func TestValidateClientIP(t *testing.T) {
+	db := &Database{Clients: []Client{{ID: "c1", ServerID: "s1", Address: "10.0.0.7"}}}
+
+	tests := []struct {
+		address string
+		ip      string
+		ok      bool
+	}{
+		{"10.0.0.1/24", "10.0.0.2", true},
+		{"10.0.0.1/24", "10.0.0.0", false},   // Адрес сети
+		{"10.0.0.1/24", "10.0.0.255", false}, // Broadcast
+		{"10.0.0.1/24", "10.0.0.1", false},   // Сервер
+		{"10.0.0.1/24", "10.0.0.7", false},   // Занят клиентом
+		{"10.0.0.1/24", "10.0.1.2", false},   // Вне подсети
+		{"10.0.0.1/24", "fd00::2", false},
+
+		// В /23 .255 и .0 в середине диапазона - обычные адреса
+		{"10.0.0.1/23", "10.0.0.255", true},
+		{"10.0.0.1/23", "10.0.1.0", true},
+		{"10.0.0.1/23", "10.0.1.255", false},
+		{"10.0.0.1/23", "10.0.0.0", false},
+
+		{"10.0.0.129/25", "10.0.0.128", false},
+		{"10.0.0.129/25", "10.0.0.255", false},
+		{"10.0.0.129/25", "10.0.0.200", true},
+
+		{"10.0.0.1/16", "10.0.5.0", true},
+		{"10.0.0.1/16", "10.0.255.255", false},
+
+		// В /31 нет адреса сети и broadcast
+		{"10.0.0.0/31", "10.0.0.1", true},
+	}
+
+	for _, tt := range tests {
+		server := &Server{ID: "s1", Address: tt.address}
+		err := ValidateClientIP(db, server, tt.ip)
+		if (err == nil) != tt.ok {
+			t.Errorf("ValidateClientIP(%s, %s) = %v, want ok=%v", tt.address, tt.ip, err, tt.ok)
+		}
+	}
+}
+
+func TestCheckServerPort(t *testing.T) {
+	db := &Database{
+		Servers: []Server{{ID: "s1", Name: "office", ListenPort: 51820}, {ID: "s2", Name: "lab", ListenPort: 51821}},
+		Clients: []Client{{Name: "nas", PortForwards: []PortForward{
+			{Port: 6000, PortEnd: 6010, Protocol: "udp"},
+			{Port: 7000, Protocol: "tcp"},
+			{Port: 8000, Protocol: "both"},
+		}}},
+	}
+
+	tests := []struct {
+		serverID string
+		port     int
+		ok       bool
+	}{
+		{"s1", 51820, true},  // Свой порт
+		{"s1", 51821, false}, // Порт другого сервера
+		{"", 51820, false},   // Новый сервер
+		{"s1", 6005, false},  // UDP проброс
+		{"s1", 7000, true},   // TCP проброс WireGuard не мешает
+		{"s1", 8000, false},
+		{"s1", 51830, true},
+	}
+	for _, tt := range tests {
+		if err := CheckServerPort(db, tt.serverID, tt.port); (err == nil) != tt.ok {
+			t.Errorf("CheckServerPort(%q, %d) = %v, want ok=%v", tt.serverID, tt.port, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package dns
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Фильтрация: списки блокировки (hosts и adblock) из URL, локальных файлов и
+// загруженные через API. Списки действуют только на серверах с включенным
+// dns_filter, ручной blocklist - на всех, allowlist важнее и того и другого
+
+// listsDir каталог списков DNS (переменная, чтобы тесты могли подменить)
+var listsDir = "/opt/wg_serf/dns"
+
+func uploadsDir() string { return filepath.Join(listsDir, "uploads") } // Загруженные через API списки
+func cacheDir() string   { return filepath.Join(listsDir, "cache") }   // Последние скачанные копии списков по URL
+
+const (
+	defaultListRefresh = 24 // Часы между обновлениями списков по URL
+	downloadTimeout    = 20 * time.Second
+	maxListSize        = 64 << 20
+)
+
+// Виды источников списков
+const (
+	ListURL    = "url"
+	ListFile   = "file"
+	ListUpload = "upload"
+)
+
+// ListStatus состояние одного списка блокировки
+type ListStatus struct {
+	Source    string     `json:"source"` // URL, путь к файлу или имя загруженного списка
+	Kind      string     `json:"kind"`   // url, file или upload
+	Domains   int        `json:"domains"`
+	Allowed   int        `json:"allowed"` // Исключения @@|| из списков adblock
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// Status состояние встроенного DNS и фильтрации
+type Status struct {
+	Enabled        bool         `json:"enabled"`
+	Domain         string       `json:"domain"`
+	RefreshHours   int          `json:"refresh_hours"`
+	Lists          []ListStatus `json:"lists"`
+	BlockedDomains int          `json:"blocked_domains"` // Всего доменов во всех списках
+	Blocklist      []string     `json:"blocklist"`
+	Allowlist      []string     `json:"allowlist"`
+}
+
+// filter объединенные списки
+type filter struct {
+	blocked map[string]bool
+	allowed map[string]bool
+}
+
+var (
+	filterMu     sync.RWMutex
+	activeFilter = &filter{blocked: map[string]bool{}, allowed: map[string]bool{}}
+	lists        = []ListStatus{}
+	listsLoaded  bool
+)
+
+// ListRefreshLoop загружает списки при старте (из кэша, если он есть) и
+// скачивает списки по URL заново раз в refresh_hours (настройка читается каждый час)
+func ListRefreshLoop() {
+	ReloadLists(false)
+	downloaded := time.Now()
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		mu.RLock()
+		hours := config.RefreshHours
+		mu.RUnlock()
+		if hours <= 0 {
+			hours = defaultListRefresh
+		}
+		if time.Since(downloaded) >= time.Duration(hours)*time.Hour-time.Minute {
+			ReloadLists(true)
+			downloaded = time.Now()
+		}
+	}
+}
+
+// ReloadLists перечитывает все списки и собирает фильтр заново
+// (download - скачать списки по URL, даже если есть копия в кэше)
+func ReloadLists(download bool) Status {
+	mu.RLock()
+	cfg := config
+	mu.RUnlock()
+
+	next := &filter{blocked: map[string]bool{}, allowed: map[string]bool{}}
+	var statuses []ListStatus
+
+	load := func(status ListStatus, path string) {
+		if status.Error == "" {
+			blocked, allowed, err := parseListFile(path)
+			if err != nil {
+				status.Error = err.Error()
+			}
+			for _, domain := range blocked {
+				next.blocked[domain] = true
+			}
+			for _, domain := range allowed {
+				next.allowed[domain] = true
+			}
+			status.Domains, status.Allowed = len(blocked), len(allowed)
+			if info, err := os.Stat(path); err == nil && status.UpdatedAt == nil {
+				modified := info.ModTime()
+				status.UpdatedAt = &modified
+			}
+		}
+		if status.Error != "" {
+			log.Printf("⚠️  Список DNS %s: %s", status.Source, status.Error)
+		}
+		statuses = append(statuses, status)
+	}
+
+	for _, source := range cfg.Blocklists {
+		source = strings.TrimSpace(source)
+		switch {
+		case source == "":
+		case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+			status := ListStatus{Source: source, Kind: ListURL}
+			path := cachePath(source)
+			if _, err := os.Stat(path); download || err != nil {
+				if err := downloadList(source, path); err != nil {
+					// Старая копия лучше, чем никакой
+					status.Error = err.Error()
+					if _, statErr := os.Stat(path); statErr == nil {
+						log.Printf("⚠️  Список DNS %s: %v, используется прежняя копия", source, err)
+						status.Error = ""
+					}
+				}
+			}
+			load(status, path)
+		default:
+			path := strings.TrimPrefix(source, "file://")
+			load(ListStatus{Source: source, Kind: ListFile}, path)
+		}
+	}
+
+	for _, name := range uploadedLists() {
+		load(ListStatus{Source: name, Kind: ListUpload}, filepath.Join(uploadsDir(), name))
+	}
+
+	for _, domain := range cfg.Allowlist {
+		if domain = NormalizeDomain(domain); domain != "" {
+			next.allowed[domain] = true
+		}
+	}
+
+	filterMu.Lock()
+	activeFilter = next
+	lists = statuses
+	listsLoaded = true
+	filterMu.Unlock()
+
+	if len(statuses) > 0 {
+		log.Printf("🛡️  Фильтр DNS: %d доменов из %d списков, исключений %d", len(next.blocked), len(statuses), len(next.allowed))
+	}
+	return CurrentStatus()
+}
+
+// CurrentStatus состояние DNS и списков (при первом обращении списки читаются с диска)
+func CurrentStatus() Status {
+	filterMu.RLock()
+	loaded := listsLoaded
+	filterMu.RUnlock()
+	if !loaded {
+		return ReloadLists(false)
+	}
+
+	mu.RLock()
+	cfg := config
+	mu.RUnlock()
+
+	filterMu.RLock()
+	defer filterMu.RUnlock()
+
+	status := Status{
+		Enabled:        cfg.Enabled,
+		Domain:         domainOf(cfg),
+		RefreshHours:   cfg.RefreshHours,
+		Lists:          append([]ListStatus{}, lists...),
+		BlockedDomains: len(activeFilter.blocked),
+		Blocklist:      append([]string{}, cfg.Blocklist...),
+		Allowlist:      append([]string{}, cfg.Allowlist...),
+	}
+	if status.RefreshHours <= 0 {
+		status.RefreshHours = defaultListRefresh
+	}
+	return status
+}
+
+// blockedByFilter заблокирован ли домен: исключения важнее блокировки, и то и
+// другое действует на поддомены (lists - проверять ли списки, а не только ручной blocklist)
+func blockedByFilter(name string, manual map[string]bool, lists bool) bool {
+	filterMu.RLock()
+	f := activeFilter
+	filterMu.RUnlock()
+
+	if matchesParent(f.allowed, name) {
+		return false
+	}
+	return matchesParent(manual, name) || lists && matchesParent(f.blocked, name)
+}
+
+// matchesParent есть ли в наборе домен или любой из его родительских доменов
+func matchesParent(set map[string]bool, name string) bool {
+	for name != "" {
+		if set[name] {
+			return true
+		}
+		_, parent, found := strings.Cut(name, ".")
+		if !found {
+			break
+		}
+		name = parent
+	}
+	return false
+}
+
+var (
+	listNameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+	domainRe   = regexp.MustCompile(`^[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?(\.[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?)+$`)
+)
+
+// NormalizeDomain домен в нижнем регистре без точек по краям (пусто - не домен)
+func NormalizeDomain(domain string) string {
+	domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if len(domain) > 253 || !domainRe.MatchString(domain) {
+		return ""
+	}
+	return domain
+}
+
+// SaveUpload сохраняет загруженный список (проверяет, что в нем есть домены)
+func SaveUpload(name string, r io.Reader) (ListStatus, error) {
+	status := ListStatus{Source: name, Kind: ListUpload}
+	if !listNameRe.MatchString(name) {
+		return status, fmt.Errorf("Invalid list name %q", name)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, maxListSize+1))
+	if err != nil {
+		return status, err
+	}
+	if len(data) > maxListSize {
+		return status, fmt.Errorf("List is larger than %d MB", maxListSize>>20)
+	}
+	blocked, allowed, err := parseList(strings.NewReader(string(data)))
+	if err != nil {
+		return status, err
+	}
+	if len(blocked) == 0 && len(allowed) == 0 {
+		return status, fmt.Errorf("No domains found (expected hosts, adblock or one domain per line)")
+	}
+
+	if err := os.MkdirAll(uploadsDir(), 0700); err != nil {
+		return status, err
+	}
+	if err := os.WriteFile(filepath.Join(uploadsDir(), name), data, 0600); err != nil {
+		return status, err
+	}
+	status.Domains, status.Allowed = len(blocked), len(allowed)
+	return status, nil
+}
+
+// DeleteUpload удаляет загруженный список
+func DeleteUpload(name string) error {
+	if !listNameRe.MatchString(name) {
+		return fmt.Errorf("Invalid list name %q", name)
+	}
+	return os.Remove(filepath.Join(uploadsDir(), name))
+}
+
+// uploadedLists имена загруженных списков
+func uploadedLists() []string {
+	entries, err := os.ReadDir(uploadsDir())
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && listNameRe.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// cachePath файл кэша списка по URL
+func cachePath(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return filepath.Join(cacheDir(), hex.EncodeToString(sum[:8])+".txt")
+}
+
+// downloadList скачивает список во временный файл и заменяет им кэш
+func downloadList(source, path string) error {
+	client := &http.Client{Timeout: downloadTimeout}
+	resp, err := client.Get(source)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	if err := os.MkdirAll(cacheDir(), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(file, io.LimitReader(resp.Body, maxListSize+1))
+	file.Close()
+	if err == nil && n > maxListSize {
+		err = fmt.Errorf("список больше %d МБ", maxListSize>>20)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// parseListFile читает список с диска
+func parseListFile(path string) (blocked, allowed []string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	return parseList(file)
+}
+
+// Имена из hosts файлов, которые не являются рекламой
+var hostsSkip = map[string]bool{
+	"localhost": true, "localhost.localdomain": true, "local": true, "broadcasthost": true,
+	"ip6-localhost": true, "ip6-loopback": true, "ip6-localnet": true, "ip6-mcastprefix": true,
+	"ip6-allnodes": true, "ip6-allrouters": true, "ip6-allhosts": true, "0.0.0.0": true,
+}
+
+// parseList разбирает список: hosts (0.0.0.0 домен), adblock (||домен^, исключения
+// @@||домен^) или один домен на строку. Правила adblock с опциями ($third-party и т.п.)
+// и масками зависят от контекста страницы и для DNS пропускаются
+func parseList(r io.Reader) (blocked, allowed []string, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+			continue
+		}
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+
+		switch {
+		case strings.HasPrefix(line, "@@||"):
+			if domain := adblockDomain(line[4:]); domain != "" {
+				allowed = append(allowed, domain)
+			}
+		case strings.HasPrefix(line, "||"):
+			if domain := adblockDomain(line[2:]); domain != "" {
+				blocked = append(blocked, domain)
+			}
+		default:
+			fields := strings.Fields(line)
+			if len(fields) > 1 && net.ParseIP(fields[0]) != nil {
+				for _, name := range fields[1:] {
+					if domain := NormalizeDomain(name); domain != "" && !hostsSkip[domain] {
+						blocked = append(blocked, domain)
+					}
+				}
+			} else if len(fields) == 1 {
+				if domain := NormalizeDomain(fields[0]); domain != "" {
+					blocked = append(blocked, domain)
+				}
+			}
+		}
+	}
+	return blocked, allowed, scanner.Err()
+}
+
+// adblockDomain домен из правила ||домен^ (пусто - правило не для DNS)
+func adblockDomain(rule string) string {
+	domain, rest, _ := strings.Cut(rule, "^")
+	if rest != "" && rest != "|" {
+		return ""
+	}
+	return NormalizeDomain(domain)
+}
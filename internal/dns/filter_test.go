@@ -0,0 +1,271 @@
+package dns
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wg-panel/internal/database"
+)
+
+func TestParseList(t *testing.T) {
+	tests := []struct {
+		file    string
+		blocked []string
+		allowed []string
+	}{
+		{
+			file:    "hosts.txt",
+			blocked: []string{"ads.example.com", "tracker.example.net", "metrics.example.net", "banner.example.org"},
+		},
+		{
+			file:    "plain.txt",
+			blocked: []string{"telemetry.example.io", "trailing-dots.example.io"},
+		},
+		{
+			file:    "adblock.txt",
+			blocked: []string{"doubleclick.example", "pixel.example.com", "tracker.example.net"},
+			allowed: []string{"safe.tracker.example.net"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			blocked, allowed, err := parseListFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(blocked, ",") != strings.Join(tt.blocked, ",") {
+				t.Errorf("blocked = %v, want %v", blocked, tt.blocked)
+			}
+			if strings.Join(allowed, ",") != strings.Join(tt.allowed, ",") {
+				t.Errorf("allowed = %v, want %v", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := map[string]string{
+		"Example.COM":                     "example.com",
+		" .ads.example.com.":              "ads.example.com",
+		"_dmarc.example.com":              "_dmarc.example.com",
+		"localhost":                       "",
+		"*.example.com":                   "",
+		"-bad.example.com":                "",
+		"exa mple.com":                    "",
+		strings.Repeat("a.", 127) + "com": "",
+	}
+	for input, want := range tests {
+		if got := NormalizeDomain(input); got != want {
+			t.Errorf("NormalizeDomain(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+// useTempLists подменяет каталог списков временным и восстанавливает настройки после теста
+func useTempLists(t *testing.T) {
+	t.Helper()
+	previousDir, previous := listsDir, config
+	listsDir = t.TempDir()
+	t.Cleanup(func() {
+		Configure(previous)
+		ReloadLists(false)
+		listsDir = previousDir
+	})
+}
+
+// loadFixtures собирает фильтр из трех списков testdata и allowlist
+func loadFixtures(t *testing.T) Status {
+	t.Helper()
+	dir, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	useTempLists(t)
+	Configure(database.DNSConfig{
+		Blocklists: []string{
+			filepath.Join(dir, "hosts.txt"),
+			"file://" + filepath.Join(dir, "plain.txt"),
+			filepath.Join(dir, "adblock.txt"),
+			filepath.Join(dir, "missing.txt"),
+		},
+		Allowlist: []string{"Banner.Example.org"},
+	})
+	return ReloadLists(false)
+}
+
+func TestReloadListsStatus(t *testing.T) {
+	status := loadFixtures(t)
+
+	if len(status.Lists) != 4 {
+		t.Fatalf("lists = %+v", status.Lists)
+	}
+	want := []struct {
+		kind             string
+		domains, allowed int
+		failed           bool
+	}{
+		{ListFile, 4, 0, false},
+		{ListFile, 2, 0, false},
+		{ListFile, 3, 1, false},
+		{ListFile, 0, 0, true},
+	}
+	for i, w := range want {
+		list := status.Lists[i]
+		if list.Kind != w.kind || list.Domains != w.domains || list.Allowed != w.allowed || (list.Error != "") != w.failed {
+			t.Errorf("list %d = %+v, want %+v", i, list, w)
+		}
+	}
+	// tracker.example.net есть в двух списках
+	if status.BlockedDomains != 8 {
+		t.Errorf("blocked domains = %d, want 8", status.BlockedDomains)
+	}
+}
+
+func TestBlockedByFilter(t *testing.T) {
+	loadFixtures(t)
+
+	manual := map[string]bool{"manual.example": true, "telemetry.example.io": true, "banner.example.org": true}
+
+	tests := []struct {
+		name  string
+		lists bool // На сервере включен dns_filter
+		want  bool
+	}{
+		{"ads.example.com", true, true},
+		{"sub.ads.example.com", true, true},
+		{"deep.sub.ads.example.com", true, true},
+		{"example.com", true, false},
+		{"badads.example.com", true, false},
+		{"pixel.example.com", true, true},
+		{"cdn.example.com", true, false},
+
+		// Исключение @@|| из списка важнее блокировки родительского домена в другом списке
+		{"tracker.example.net", true, true},
+		{"other.tracker.example.net", true, true},
+		{"safe.tracker.example.net", true, false},
+		{"deep.safe.tracker.example.net", true, false},
+
+		// allowlist из config.json важнее и списков, и ручного blocklist
+		{"banner.example.org", true, false},
+		{"x.banner.example.org", false, false},
+
+		// Ручной blocklist действует и без списков, списки - только с dns_filter
+		{"manual.example", false, true},
+		{"www.manual.example", false, true},
+		{"telemetry.example.io", false, true},
+		{"ads.example.com", false, false},
+		{"doubleclick.example", false, false},
+	}
+
+	for _, tt := range tests {
+		if got := blockedByFilter(tt.name, manual, tt.lists); got != tt.want {
+			t.Errorf("blockedByFilter(%q, lists=%v) = %v, want %v", tt.name, tt.lists, got, tt.want)
+		}
+	}
+}
+
+func TestUploads(t *testing.T) {
+	loadFixtures(t)
+
+	list, err := SaveUpload("office.txt", strings.NewReader("0.0.0.0 upload.example.com\n@@||ok.upload.example.com^\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Kind != ListUpload || list.Domains != 1 || list.Allowed != 1 {
+		t.Errorf("saved list = %+v", list)
+	}
+	if info, err := os.Stat(filepath.Join(listsDir, "uploads", "office.txt")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("uploaded file: %v %v", info, err)
+	}
+
+	status := ReloadLists(false)
+	last := status.Lists[len(status.Lists)-1]
+	if last.Source != "office.txt" || last.Kind != ListUpload || last.Domains != 1 {
+		t.Errorf("upload status = %+v", last)
+	}
+	if status.BlockedDomains != 9 {
+		t.Errorf("blocked domains = %d, want 9", status.BlockedDomains)
+	}
+	if !blockedByFilter("upload.example.com", nil, true) || blockedByFilter("ok.upload.example.com", nil, true) {
+		t.Error("uploaded list is not applied")
+	}
+
+	if err := DeleteUpload("office.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if status := ReloadLists(false); len(status.Lists) != 4 || status.BlockedDomains != 8 {
+		t.Errorf("after delete: %d lists, %d domains", len(status.Lists), status.BlockedDomains)
+	}
+	if err := DeleteUpload("office.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("second delete: err = %v, want ErrNotExist", err)
+	}
+}
+
+func TestUploadRejected(t *testing.T) {
+	useTempLists(t)
+
+	for _, name := range []string{"", "../escape.txt", ".hidden", "a/b", "name with space", strings.Repeat("a", 65)} {
+		if _, err := SaveUpload(name, strings.NewReader("ads.example.com\n")); err == nil {
+			t.Errorf("SaveUpload(%q) accepted an invalid name", name)
+		}
+		if err := DeleteUpload(name); err == nil || errors.Is(err, os.ErrNotExist) {
+			t.Errorf("DeleteUpload(%q) = %v, want invalid name error", name, err)
+		}
+	}
+
+	if _, err := SaveUpload("empty.txt", strings.NewReader("# только комментарий\nlocalhost\n")); err == nil {
+		t.Error("list without domains is accepted")
+	}
+	if entries, _ := os.ReadDir(filepath.Join(listsDir, "uploads")); len(entries) != 0 {
+		t.Errorf("rejected uploads are written: %v", entries)
+	}
+}
+
+// Список по URL скачивается в кэш, при ошибке скачивания используется прежняя копия
+func TestURLListCache(t *testing.T) {
+	useTempLists(t)
+
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("||remote.example.com^\n||cdn.remote.example.com^\n"))
+	}))
+	defer srv.Close()
+
+	source := srv.URL + "/list.txt"
+	Configure(database.DNSConfig{Blocklists: []string{source}})
+
+	status := ReloadLists(false)
+	if len(status.Lists) != 1 || status.Lists[0].Kind != ListURL || status.Lists[0].Domains != 2 || status.Lists[0].Error != "" {
+		t.Fatalf("lists = %+v", status.Lists)
+	}
+	if _, err := os.Stat(cachePath(source)); err != nil {
+		t.Fatalf("cache: %v", err)
+	}
+	if filepath.Dir(cachePath(source)) != filepath.Join(listsDir, "cache") {
+		t.Errorf("cache path %s is outside %s", cachePath(source), listsDir)
+	}
+
+	fail = true
+	status = ReloadLists(true)
+	if status.Lists[0].Error != "" || status.Lists[0].Domains != 2 || !blockedByFilter("remote.example.com", nil, true) {
+		t.Errorf("stale copy is not used: %+v", status.Lists[0])
+	}
+
+	// Без копии ошибка видна в статусе
+	os.Remove(cachePath(source))
+	status = ReloadLists(true)
+	if status.Lists[0].Error == "" || status.BlockedDomains != 0 {
+		t.Errorf("missing list = %+v", status.Lists[0])
+	}
+}
@@ -0,0 +1,159 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Минимальный разбор и сборка сообщений DNS (RFC 1035): панели нужно прочитать
+// вопрос и ответить записями A, все остальное пересылается вышестоящему серверу как есть
+
+// Типы записей и классы
+const (
+	typeA    = 1
+	typePTR  = 12
+	typeAAAA = 28
+	classIN  = 1
+)
+
+// Коды ответа
+const (
+	rcodeSuccess  = 0
+	rcodeFormErr  = 1
+	rcodeServFail = 2
+	rcodeNXDomain = 3
+	rcodeRefused  = 5
+)
+
+const headerLen = 12
+
+var errMalformed = errors.New("некорректное DNS сообщение")
+
+// question первый вопрос запроса
+type question struct {
+	name  string // В нижнем регистре, без точки в конце
+	qtype uint16
+	class uint16
+	end   int // Смещение конца вопроса в сообщении
+}
+
+// parseQuery читает заголовок и первый вопрос
+func parseQuery(msg []byte) (question, error) {
+	var q question
+	if len(msg) < headerLen {
+		return q, errMalformed
+	}
+	// Ответы и запросы без вопроса не обрабатываем
+	if msg[2]&0x80 != 0 || binary.BigEndian.Uint16(msg[4:6]) == 0 {
+		return q, errMalformed
+	}
+
+	name, offset, err := readName(msg, headerLen)
+	if err != nil || offset+4 > len(msg) {
+		return q, errMalformed
+	}
+	q.name = strings.ToLower(name)
+	q.qtype = binary.BigEndian.Uint16(msg[offset:])
+	q.class = binary.BigEndian.Uint16(msg[offset+2:])
+	q.end = offset + 4
+	return q, nil
+}
+
+// readName читает имя (с поддержкой сжатия) и возвращает смещение после него
+func readName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errMalformed
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case length&0xC0 == 0xC0:
+			if offset+1 >= len(msg) || jumps > 10 {
+				return "", 0, errMalformed
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3FFF)
+			jumps++
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+// answer запись ответа
+type answer struct {
+	qtype uint16
+	ttl   uint32
+	data  []byte
+}
+
+// buildResponse ответ на запрос: заголовок, вопрос из запроса и записи. Все записи
+// относятся к имени из вопроса, поэтому имя в них - ссылка на вопрос
+func buildResponse(query []byte, q question, rcode int, authoritative bool, answers []answer) []byte {
+	resp := make([]byte, headerLen, q.end+len(answers)*16)
+	copy(resp[0:2], query[0:2]) // ID
+
+	// QR, opcode и RD из запроса, RA - рекурсия доступна
+	flags := uint16(0x8000) | binary.BigEndian.Uint16(query[2:4])&0x7900 | 0x0080 | uint16(rcode)
+	if authoritative {
+		flags |= 0x0400
+	}
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(answers)))
+
+	resp = append(resp, query[headerLen:q.end]...)
+	for _, a := range answers {
+		resp = append(resp, 0xC0, headerLen) // Имя - ссылка на вопрос
+		resp = binary.BigEndian.AppendUint16(resp, a.qtype)
+		resp = binary.BigEndian.AppendUint16(resp, classIN)
+		resp = binary.BigEndian.AppendUint32(resp, a.ttl)
+		resp = binary.BigEndian.AppendUint16(resp, uint16(len(a.data)))
+		resp = append(resp, a.data...)
+	}
+	return resp
+}
+
+// errorResponse ответ с кодом ошибки на запрос, который не удалось разобрать
+func errorResponse(query []byte, rcode int) []byte {
+	if len(query) < headerLen {
+		return nil
+	}
+	resp := make([]byte, headerLen)
+	copy(resp[0:2], query[0:2])
+	flags := uint16(0x8000) | binary.BigEndian.Uint16(query[2:4])&0x7900 | 0x0080 | uint16(rcode)
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	return resp
+}
+
+// encodeName имя в формате DNS (для PTR)
+func encodeName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// reverseName имя in-addr.arpa для адреса
+func reverseName(ip net.IP) string {
+	ip = ip.To4()
+	return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip[3], ip[2], ip[1], ip[0])
+}
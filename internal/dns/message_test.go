@@ -0,0 +1,105 @@
+package dns
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// newQuery запрос с одним вопросом и флагом RD
+func newQuery(id uint16, name string, qtype uint16) []byte {
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = append(msg, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0)
+	msg = append(msg, encodeName(name)...)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	return binary.BigEndian.AppendUint16(msg, classIN)
+}
+
+// withHeader заголовок запроса с одним вопросом и произвольное тело
+func withHeader(body ...byte) []byte {
+	return append([]byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}, body...)
+}
+
+func TestParseQuery(t *testing.T) {
+	valid := newQuery(0xBEEF, "WWW.Example.COM", typeAAAA)
+	response := append([]byte{}, valid...)
+	response[2] |= 0x80
+	noQuestion := append([]byte{}, valid...)
+	noQuestion[5] = 0
+
+	tests := []struct {
+		name  string
+		msg   []byte
+		want  string
+		qtype uint16
+		end   int
+		err   bool
+	}{
+		{name: "valid", msg: valid, want: "www.example.com", qtype: typeAAAA, end: len(valid)},
+		{name: "root", msg: withHeader(0, 0, 2, 0, 1), want: "", qtype: 2, end: 17}, // NS корня
+		// Сжатое имя вопроса ссылается вперед на полное имя после него
+		{name: "pointer", msg: withHeader(0xC0, 18, 0, 1, 0, 1, 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0), want: "example", qtype: typeA, end: 18},
+
+		{name: "empty", msg: nil, err: true},
+		{name: "short header", msg: valid[:headerLen-1], err: true},
+		{name: "header only", msg: valid[:headerLen], err: true},
+		{name: "response", msg: response, err: true},
+		{name: "no question", msg: noQuestion, err: true},
+		{name: "truncated label", msg: withHeader(5, 'a', 'b'), err: true},
+		{name: "no terminator", msg: withHeader(1, 'a'), err: true},
+		{name: "truncated type", msg: withHeader(1, 'a', 0, 0, 1), err: true},
+		{name: "truncated class", msg: withHeader(1, 'a', 0, 0, 1, 0), err: true},
+		{name: "truncated pointer", msg: withHeader(0xC0), err: true},
+		{name: "pointer to itself", msg: withHeader(0xC0, 12, 0, 1, 0, 1), err: true},
+		{name: "pointer loop", msg: withHeader(0xC0, 14, 0xC0, 12, 0, 1, 0, 1), err: true},
+		{name: "pointer loop after label", msg: withHeader(1, 'a', 0xC0, 12, 0, 1, 0, 1), err: true},
+		{name: "pointer past end", msg: withHeader(0xC0, 0xFF, 0, 1, 0, 1), err: true},
+		{name: "pointer to end", msg: withHeader(0xC0, 18, 0, 1, 0, 1), err: true},
+		{name: "far pointer", msg: withHeader(0xFF, 0xFF, 0, 1, 0, 1), err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := parseQuery(tt.msg)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("parsed %+v, want error", q)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.name != tt.want || q.qtype != tt.qtype || q.class != classIN || q.end != tt.end {
+				t.Errorf("question = %+v, want %q type %d end %d", q, tt.want, tt.qtype, tt.end)
+			}
+		})
+	}
+}
+
+// Ответ на сообщение, которое не удалось разобрать: только заголовок с тем же ID
+func TestErrorResponse(t *testing.T) {
+	if resp := errorResponse([]byte{1, 2, 3}, rcodeFormErr); resp != nil {
+		t.Errorf("response to a short message = %x, want nil", resp)
+	}
+
+	resp := errorResponse(withHeader(0xC0), rcodeFormErr)
+	if len(resp) != headerLen || resp[0] != 0x12 || resp[1] != 0x34 {
+		t.Fatalf("response = %x", resp)
+	}
+	flags := binary.BigEndian.Uint16(resp[2:])
+	if flags&0x8000 == 0 || flags&0x0100 == 0 || flags&0x0080 == 0 || int(flags&0xF) != rcodeFormErr {
+		t.Errorf("flags = %016b", flags)
+	}
+	if binary.BigEndian.Uint16(resp[4:]) != 0 || binary.BigEndian.Uint16(resp[6:]) != 0 {
+		t.Errorf("counts in %x, want zero", resp)
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	if got := reverseName([]byte{10, 9, 0, 2}); got != "2.0.9.10.in-addr.arpa" {
+		t.Errorf("reverseName = %s", got)
+	}
+	if got := string(encodeName("a.bc.")); got != "\x01a\x02bc\x00" {
+		t.Errorf("encodeName = %q", got)
+	}
+}
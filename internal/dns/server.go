@@ -0,0 +1,486 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"wg-panel/internal/database"
+)
+
+// Встроенный DNS: на адресе каждого включенного сервера в туннеле (10.0.0.1:53)
+// отвечаем на имена клиентов из БД (<клиент>.<сервер>.vpn), остальные запросы
+// пересылаем вышестоящим серверам (или блокируем, см. filter.go). Набор адресов
+// и имен обновляется по таймеру
+
+const (
+	defaultDomain   = "vpn"
+	defaultUpstream = "1.1.1.1"
+	recordTTL       = 60
+	upstreamTimeout = 3 * time.Second
+	tcpIdleTimeout  = 10 * time.Second
+	refreshInterval = 5 * time.Second
+)
+
+// zone снимок имен и настроек, по которому отвечают слушатели
+type zone struct {
+	domain    string
+	records   map[string]net.IP    // Имя -> адрес
+	reverse   map[string]string    // Имя in-addr.arpa -> имя клиента
+	networks  []*net.IPNet         // Подсети серверов: отвечаем только клиентам VPN
+	upstreams map[string][]string  // Адрес слушателя -> вышестоящие серверы
+	blocked   map[string]bool      // Ручной blocklist из config.json
+	filtered  map[string]bool      // Адрес слушателя -> на сервере включены списки блокировки
+	clients   map[string]clientRef // Адрес клиента в туннеле -> клиент (для статистики)
+}
+
+// listener сокеты UDP и TCP на адресе сервера
+type listener struct {
+	udp *net.UDPConn
+	tcp net.Listener
+}
+
+var (
+	mu        sync.RWMutex
+	config    database.DNSConfig
+	current   = &zone{}
+	listeners = make(map[string]*listener)
+	failures  = make(map[string]string) // Последняя ошибка запуска по адресу (чтобы не повторять в логе)
+)
+
+// Configure задает настройки из config.json (до запуска WireGuard и ServeLoop)
+func Configure(cfg database.DNSConfig) {
+	mu.Lock()
+	defer mu.Unlock()
+	config = cfg
+}
+
+// Enabled включен ли встроенный DNS
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return config.Enabled
+}
+
+// ClientResolver строка DNS для конфига клиента: адрес сервера в туннеле и домен
+// поиска, чтобы клиенты находили друг друга по короткому имени. Пусто - DNS отключен
+func ClientResolver(server *database.Server) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if !config.Enabled {
+		return ""
+	}
+	return tunnelIP(server) + ", " + serverLabel(server) + "." + domainOf(config)
+}
+
+// ServeLoop поднимает слушатели на адресах включенных серверов и обновляет имена
+func ServeLoop(db *database.Database) {
+	mu.RLock()
+	cfg := config
+	mu.RUnlock()
+	if !cfg.Enabled {
+		return
+	}
+	log.Printf("🌐 Встроенный DNS: имена <клиент>.<сервер>.%s на адресах серверов в туннеле", domainOf(cfg))
+	go ListRefreshLoop()
+
+	refresh(db)
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		refresh(db)
+	}
+}
+
+// refresh пересобирает имена и приводит слушатели к списку включенных серверов
+func refresh(db *database.Database) {
+	mu.RLock()
+	cfg := config
+	mu.RUnlock()
+
+	// БД читается под ее блокировкой, до блокировки DNS: запросы API держат
+	// блокировку БД и обращаются к DNS
+	database.Mu.RLock()
+	zone := buildZone(db, cfg)
+	wanted := make(map[string]bool)
+	for _, server := range db.Servers {
+		if server.Enabled {
+			wanted[tunnelIP(&server)] = true
+		}
+	}
+	database.Mu.RUnlock()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	current = zone
+
+	for ip, l := range listeners {
+		if !wanted[ip] {
+			l.udp.Close()
+			l.tcp.Close()
+			delete(listeners, ip)
+			log.Printf("🌐 DNS на %s остановлен", ip)
+		}
+	}
+
+	for ip := range wanted {
+		if listeners[ip] != nil {
+			continue
+		}
+		// Интерфейс может еще не подняться - попробуем при следующем обновлении
+		l, err := listen(ip)
+		if err != nil {
+			if failures[ip] != err.Error() {
+				failures[ip] = err.Error()
+				log.Printf("⚠️  DNS на %s: %v", ip, err)
+			}
+			continue
+		}
+		delete(failures, ip)
+		listeners[ip] = l
+		log.Printf("🌐 DNS слушает %s:53", ip)
+	}
+}
+
+// buildZone имена серверов и активных клиентов
+func buildZone(db *database.Database, cfg database.DNSConfig) *zone {
+	z := &zone{
+		domain:    domainOf(cfg),
+		records:   make(map[string]net.IP),
+		reverse:   make(map[string]string),
+		upstreams: make(map[string][]string),
+		blocked:   make(map[string]bool),
+		filtered:  make(map[string]bool),
+		clients:   make(map[string]clientRef),
+	}
+	for _, domain := range cfg.Blocklist {
+		if domain = NormalizeDomain(domain); domain != "" {
+			z.blocked[domain] = true
+		}
+	}
+
+	for i := range db.Servers {
+		server := &db.Servers[i]
+		if !server.Enabled {
+			continue
+		}
+		ip := tunnelIP(server)
+		if _, network, err := net.ParseCIDR(server.Address); err == nil {
+			z.networks = append(z.networks, network)
+		}
+
+		serverName := serverLabel(server) + "." + z.domain
+		z.add(serverName, net.ParseIP(ip))
+		z.upstreams[ip] = upstreamsFor(cfg, server, ip)
+		z.filtered[ip] = server.DNSFilter
+
+		for _, client := range db.Clients {
+			if client.ServerID != server.ID || !client.Enabled {
+				continue
+			}
+			address := net.ParseIP(client.Address)
+			if address == nil {
+				continue
+			}
+			z.clients[address.String()] = clientRef{id: client.ID, serverID: server.ID, name: client.Name}
+			label := Label(client.Name)
+			if label == "" {
+				label = "host-" + strings.ReplaceAll(client.Address, ".", "-")
+			}
+			name := label + "." + serverName
+			if z.add(name, address) {
+				z.reverse[reverseName(address)] = name
+			}
+		}
+	}
+	return z
+}
+
+// add добавляет имя, если оно еще не занято (при совпадении имен побеждает первый)
+func (z *zone) add(name string, ip net.IP) bool {
+	if _, exists := z.records[name]; exists || ip == nil {
+		return false
+	}
+	z.records[name] = ip
+	return true
+}
+
+// upstreamsFor вышестоящие серверы: из config.json или DNS сервера (кроме себя самого)
+func upstreamsFor(cfg database.DNSConfig, server *database.Server, self string) []string {
+	candidates := cfg.Upstreams
+	if len(candidates) == 0 {
+		candidates = strings.Split(server.DNS, ",")
+	}
+
+	var upstreams []string
+	for _, upstream := range candidates {
+		upstream = strings.TrimSpace(upstream)
+		if upstream == "" || upstream == self {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(upstream); err != nil {
+			upstream = net.JoinHostPort(upstream, "53")
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	if len(upstreams) == 0 {
+		upstreams = []string{net.JoinHostPort(defaultUpstream, "53")}
+	}
+	return upstreams
+}
+
+// listen открывает UDP и TCP сокеты на адресе
+func listen(ip string) (*listener, error) {
+	addr := net.JoinHostPort(ip, "53")
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	udp, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+
+	go serveUDP(udp, ip)
+	go serveTCP(tcp, ip)
+	return &listener{udp: udp, tcp: tcp}, nil
+}
+
+// serveUDP отвечает на запросы по UDP (до закрытия сокета)
+func serveUDP(conn *net.UDPConn, local string) {
+	buf := make([]byte, 4096)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			if resp := handle(query, src.IP, local, "udp"); resp != nil {
+				conn.WriteToUDP(resp, src)
+			}
+		}()
+	}
+}
+
+// serveTCP отвечает на запросы по TCP (сообщения с двухбайтовой длиной)
+func serveTCP(ln net.Listener, local string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			src := conn.RemoteAddr().(*net.TCPAddr).IP
+			for {
+				conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+				query, err := readTCPMessage(conn)
+				if err != nil {
+					return
+				}
+				resp := handle(query, src, local, "tcp")
+				if resp == nil || writeTCPMessage(conn, resp) != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	_, err := w.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...))
+	return err
+}
+
+// handle ответ на запрос: имена VPN из БД, блокировка или пересылка
+func handle(query []byte, src net.IP, local, network string) []byte {
+	mu.RLock()
+	z := current
+	mu.RUnlock()
+
+	// Открытый резолвер никому не нужен: отвечаем только адресам VPN
+	if !z.allowed(src) {
+		return errorResponse(query, rcodeRefused)
+	}
+
+	q, err := parseQuery(query)
+	if err != nil {
+		return errorResponse(query, rcodeFormErr)
+	}
+
+	ref, known := z.clients[src.String()]
+	if q.class == classIN {
+		if resp, ok := z.answerLocal(query, q); ok {
+			if known {
+				record(ref, src.String(), q.name, false)
+			}
+			return resp
+		}
+		if blockedByFilter(q.name, z.blocked, z.filtered[local]) {
+			if known {
+				record(ref, src.String(), q.name, true)
+			}
+			return buildResponse(query, q, rcodeNXDomain, false, nil)
+		}
+	}
+	if known {
+		record(ref, src.String(), q.name, false)
+	}
+
+	resp, err := forward(query, z.upstreams[local], network)
+	if err != nil {
+		return buildResponse(query, q, rcodeServFail, false, nil)
+	}
+	return resp
+}
+
+// allowed адрес из подсети одного из серверов
+func (z *zone) allowed(ip net.IP) bool {
+	for _, network := range z.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// answerLocal ответ из зоны VPN и обратной зоны клиентов (false - имя не наше)
+func (z *zone) answerLocal(query []byte, q question) ([]byte, bool) {
+	if name, ok := z.reverse[q.name]; ok && q.qtype == typePTR {
+		return buildResponse(query, q, rcodeSuccess, true, []answer{{typePTR, recordTTL, encodeName(name)}}), true
+	}
+
+	if q.name != z.domain && !strings.HasSuffix(q.name, "."+z.domain) {
+		return nil, false
+	}
+	ip, ok := z.records[q.name]
+	if !ok {
+		return buildResponse(query, q, rcodeNXDomain, true, nil), true
+	}
+	// Имя есть, но записей другого типа (AAAA и т.д.) нет
+	if q.qtype != typeA {
+		return buildResponse(query, q, rcodeSuccess, true, nil), true
+	}
+	return buildResponse(query, q, rcodeSuccess, true, []answer{{typeA, recordTTL, ip.To4()}}), true
+}
+
+// forward пересылает запрос как есть первому ответившему вышестоящему серверу
+func forward(query []byte, upstreams []string, network string) ([]byte, error) {
+	var lastErr error
+	for _, upstream := range upstreams {
+		resp, err := exchange(query, upstream, network)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("вышестоящие DNS не ответили: %v", lastErr)
+}
+
+// exchange один запрос к вышестоящему серверу тем же транспортом, что и у клиента
+func exchange(query []byte, upstream, network string) ([]byte, error) {
+	conn, err := net.DialTimeout(network, upstream, upstreamTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(upstreamTimeout))
+
+	if network == "tcp" {
+		if err := writeTCPMessage(conn, query); err != nil {
+			return nil, err
+		}
+		return readTCPMessage(conn)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Ответ с чужим ID - не на наш запрос
+		if n >= headerLen && buf[0] == query[0] && buf[1] == query[1] {
+			return buf[:n], nil
+		}
+	}
+}
+
+// translit латинские замены кириллицы для меток DNS
+var translit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u",
+}
+
+// Label имя в виде метки DNS: латиница, цифры и дефисы (кириллица транслитерируется)
+func Label(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if latin, ok := translit[r]; ok {
+			b.WriteString(latin)
+			continue
+		}
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "-"):
+			b.WriteByte('-')
+		}
+	}
+	label := strings.TrimSuffix(b.String(), "-")
+	if len(label) > 63 {
+		label = strings.TrimSuffix(label[:63], "-")
+	}
+	return label
+}
+
+// serverLabel метка сервера (имя без букв и цифр - по интерфейсу)
+func serverLabel(server *database.Server) string {
+	if label := Label(server.Name); label != "" {
+		return label
+	}
+	return Label(server.Interface)
+}
+
+// tunnelIP адрес сервера в туннеле
+func tunnelIP(server *database.Server) string {
+	ip, _, _ := strings.Cut(server.Address, "/")
+	return ip
+}
+
+func domainOf(cfg database.DNSConfig) string {
+	if domain := strings.Trim(strings.ToLower(cfg.Domain), "."); domain != "" {
+		return domain
+	}
+	return defaultDomain
+}
@@ -0,0 +1,252 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+
+	"wg-panel/internal/database"
+)
+
+// dnsAnswer запись из ответа
+type dnsAnswer struct {
+	name  string
+	qtype uint16
+	ttl   uint32
+	data  []byte
+}
+
+// parseResponse разбирает ответ: флаги, вопрос и записи
+func parseResponse(t *testing.T, resp []byte) (flags uint16, q question, answers []dnsAnswer) {
+	t.Helper()
+	if len(resp) < headerLen {
+		t.Fatalf("response %x is shorter than a header", resp)
+	}
+	flags = binary.BigEndian.Uint16(resp[2:])
+	if binary.BigEndian.Uint16(resp[4:]) == 0 {
+		return flags, q, nil
+	}
+
+	// Вопрос читаем как запрос
+	query := append([]byte{}, resp...)
+	query[2] &^= 0x80
+	q, err := parseQuery(query)
+	if err != nil {
+		t.Fatalf("question in %x: %v", resp, err)
+	}
+
+	offset := q.end
+	for i := 0; i < int(binary.BigEndian.Uint16(resp[6:])); i++ {
+		name, next, err := readName(resp, offset)
+		if err != nil || next+10 > len(resp) {
+			t.Fatalf("answer %d in %x: %v", i, resp, err)
+		}
+		a := dnsAnswer{
+			name:  name,
+			qtype: binary.BigEndian.Uint16(resp[next:]),
+			ttl:   binary.BigEndian.Uint32(resp[next+4:]),
+		}
+		if class := binary.BigEndian.Uint16(resp[next+2:]); class != classIN {
+			t.Fatalf("answer class %d", class)
+		}
+		length := int(binary.BigEndian.Uint16(resp[next+8:]))
+		if next+10+length > len(resp) {
+			t.Fatalf("answer %d data is truncated", i)
+		}
+		a.data = resp[next+10 : next+10+length]
+		answers = append(answers, a)
+		offset = next + 10 + length
+	}
+	if offset != len(resp) {
+		t.Fatalf("%d trailing bytes in response", len(resp)-offset)
+	}
+	return flags, q, answers
+}
+
+// useZone подменяет зону на собранную из тестовой БД
+func useZone(t *testing.T, cfg database.DNSConfig) {
+	t.Helper()
+	db := &database.Database{
+		Servers: []database.Server{
+			{ID: "s1", Name: "Офис", Interface: "wg0", Address: "10.9.0.1/24", Enabled: true, DNSFilter: true},
+			{ID: "s2", Name: "Склад", Interface: "wg1", Address: "10.10.0.1/24", Enabled: false},
+		},
+		Clients: []database.Client{
+			{ID: "c1", ServerID: "s1", Name: "Ноутбук Маши", Address: "10.9.0.2", Enabled: true},
+			{ID: "c2", ServerID: "s1", Name: "Старый телефон", Address: "10.9.0.3", Enabled: false},
+			{ID: "c3", ServerID: "s1", Name: "!!!", Address: "10.9.0.4", Enabled: true},
+			{ID: "c4", ServerID: "s2", Name: "Касса", Address: "10.10.0.2", Enabled: true},
+		},
+	}
+
+	mu.Lock()
+	previous := current
+	current = buildZone(db, cfg)
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		current = previous
+		mu.Unlock()
+	})
+}
+
+func TestAnswerLocal(t *testing.T) {
+	useTempLists(t)
+	useZone(t, database.DNSConfig{Blocklist: []string{"ads.example.com"}})
+
+	vpnClient := net.ParseIP("10.9.0.2")
+
+	tests := []struct {
+		name   string
+		qname  string
+		qtype  uint16
+		src    net.IP
+		rcode  int
+		aa     bool
+		answer []byte // nil - без записей
+		atype  uint16
+	}{
+		{"A client", "noutbuk-mashi.ofis.vpn", typeA, vpnClient, rcodeSuccess, true, []byte{10, 9, 0, 2}, typeA},
+		{"A case insensitive", "Noutbuk-Mashi.OFIS.vpn", typeA, vpnClient, rcodeSuccess, true, []byte{10, 9, 0, 2}, typeA},
+		{"A server", "ofis.vpn", typeA, vpnClient, rcodeSuccess, true, []byte{10, 9, 0, 1}, typeA},
+		{"A unnamed client", "host-10-9-0-4.ofis.vpn", typeA, vpnClient, rcodeSuccess, true, []byte{10, 9, 0, 4}, typeA},
+		{"AAAA client", "noutbuk-mashi.ofis.vpn", typeAAAA, vpnClient, rcodeSuccess, true, nil, 0},
+		{"PTR client", "2.0.9.10.in-addr.arpa", typePTR, vpnClient, rcodeSuccess, true, encodeName("noutbuk-mashi.ofis.vpn"), typePTR},
+		{"NXDOMAIN in zone", "missing.ofis.vpn", typeA, vpnClient, rcodeNXDomain, true, nil, 0},
+		{"NXDOMAIN disabled client", "staryy-telefon.ofis.vpn", typeA, vpnClient, rcodeNXDomain, true, nil, 0},
+		{"NXDOMAIN disabled server", "kassa.sklad.vpn", typeA, vpnClient, rcodeNXDomain, true, nil, 0},
+		{"NXDOMAIN zone apex", "vpn", typeA, vpnClient, rcodeNXDomain, true, nil, 0},
+		{"blocked", "www.ads.example.com", typeA, vpnClient, rcodeNXDomain, false, nil, 0},
+		{"REFUSED outside VPN", "noutbuk-mashi.ofis.vpn", typeA, net.ParseIP("192.0.2.10"), rcodeRefused, false, nil, 0},
+		{"REFUSED disabled server network", "noutbuk-mashi.ofis.vpn", typeA, net.ParseIP("10.10.0.2"), rcodeRefused, false, nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := newQuery(0xA1B2, tt.qname, tt.qtype)
+			resp := handle(query, tt.src, "10.9.0.1", "udp")
+			if resp[0] != 0xA1 || resp[1] != 0xB2 {
+				t.Fatalf("response ID %x", resp[:2])
+			}
+
+			flags, q, answers := parseResponse(t, resp)
+			if flags&0x8000 == 0 || flags&0x0100 == 0 {
+				t.Errorf("flags %016b: QR and RD must be set", flags)
+			}
+			if int(flags&0xF) != tt.rcode {
+				t.Errorf("rcode = %d, want %d", flags&0xF, tt.rcode)
+			}
+			if aa := flags&0x0400 != 0; aa != tt.aa {
+				t.Errorf("AA = %v, want %v", aa, tt.aa)
+			}
+			if tt.rcode == rcodeRefused {
+				if len(resp) != headerLen {
+					t.Errorf("REFUSED response has a body: %x", resp)
+				}
+				return
+			}
+			if q.name != strings.ToLower(tt.qname) || q.qtype != tt.qtype {
+				t.Errorf("question = %+v", q)
+			}
+
+			if tt.answer == nil {
+				if len(answers) != 0 {
+					t.Errorf("answers = %+v, want none", answers)
+				}
+				return
+			}
+			if len(answers) != 1 {
+				t.Fatalf("answers = %+v, want one", answers)
+			}
+			a := answers[0]
+			if strings.ToLower(a.name) != q.name || a.qtype != tt.atype || a.ttl != recordTTL || string(a.data) != string(tt.answer) {
+				t.Errorf("answer = %+v, want %s type %d data %x", a, q.name, tt.atype, tt.answer)
+			}
+		})
+	}
+}
+
+// Непонятный запрос от клиента VPN - FORMERR, от чужого адреса - REFUSED
+func TestHandleMalformed(t *testing.T) {
+	useZone(t, database.DNSConfig{})
+
+	for _, msg := range [][]byte{withHeader(0xC0, 12, 0, 1, 0, 1), withHeader(5, 'a'), withHeader()} {
+		resp := handle(msg, net.ParseIP("10.9.0.2"), "10.9.0.1", "udp")
+		if len(resp) != headerLen || int(resp[3]&0xF) != rcodeFormErr {
+			t.Errorf("response to %x = %x, want FORMERR", msg, resp)
+		}
+		resp = handle(msg, net.ParseIP("198.51.100.1"), "10.9.0.1", "udp")
+		if len(resp) != headerLen || int(resp[3]&0xF) != rcodeRefused {
+			t.Errorf("response to %x from outside = %x, want REFUSED", msg, resp)
+		}
+	}
+
+	if resp := handle([]byte{1, 2, 3}, net.ParseIP("10.9.0.2"), "10.9.0.1", "udp"); resp != nil {
+		t.Errorf("response to a short message = %x, want nil", resp)
+	}
+}
+
+// Имена вне зоны пересылаются вышестоящему серверу как есть
+func TestHandleForward(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := upstream.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			q, err := parseQuery(buf[:n])
+			if err != nil {
+				continue
+			}
+			upstream.WriteTo(buildResponse(buf[:n], q, rcodeSuccess, false, []answer{{typeA, 300, []byte{203, 0, 113, 7}}}), addr)
+		}
+	}()
+
+	useZone(t, database.DNSConfig{Upstreams: []string{upstream.LocalAddr().String()}})
+
+	resp := handle(newQuery(7, "example.org", typeA), net.ParseIP("10.9.0.2"), "10.9.0.1", "udp")
+	flags, _, answers := parseResponse(t, resp)
+	if flags&0x0400 != 0 || len(answers) != 1 || string(answers[0].data) != string([]byte{203, 0, 113, 7}) || answers[0].ttl != 300 {
+		t.Errorf("forwarded response: flags %016b answers %+v", flags, answers)
+	}
+}
+
+func TestLabel(t *testing.T) {
+	tests := map[string]string{
+		"Ноутбук Маши":            "noutbuk-mashi",
+		"Щука Юля":                "shchuka-yulya",
+		"Подъезд, этаж 2":         "podezd-etazh-2",
+		"ЦЕХ №3":                  "tsekh-3",
+		"Хлеб и соль":             "khleb-i-sol",
+		"Їжак Ґудзь":              "yizhak-gudz",
+		"Євген Іванів":            "yevgen-ivaniv",
+		"Ўладзь":                  "uladz",
+		"iPhone 15 Pro":           "iphone-15-pro",
+		"  --Laptop__Work--  ":    "laptop-work",
+		"Офис/Office":             "ofis-office",
+		"🙂":                       "",
+		"":                        "",
+		strings.Repeat("я", 40):   strings.Repeat("ya", 31) + "y",
+		strings.Repeat("ab-", 30): strings.Repeat("ab-", 20) + "ab",
+		strings.Repeat("щ", 20):   strings.Repeat("shch", 15) + "shc",
+		strings.Repeat("a", 63):   strings.Repeat("a", 63),
+		strings.Repeat("a", 64):   strings.Repeat("a", 63),
+	}
+
+	for name, want := range tests {
+		got := Label(name)
+		if got != want {
+			t.Errorf("Label(%q) = %q, want %q", name, got, want)
+		}
+		if len(got) > 63 || strings.HasPrefix(got, "-") || strings.HasSuffix(got, "-") {
+			t.Errorf("Label(%q) = %q is not a valid DNS label", name, got)
+		}
+	}
+}
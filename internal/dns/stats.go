@@ -0,0 +1,120 @@
+package dns
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Статистика запросов по клиентам хранится в памяти и сбрасывается при перезапуске
+
+const maxTrackedDomains = 500 // Сколько разных заблокированных доменов помнить на клиента
+
+// DomainCount домен и число запросов к нему
+type DomainCount struct {
+	Domain string `json:"domain"`
+	Count  int    `json:"count"`
+}
+
+// ClientStats статистика запросов одного клиента
+type ClientStats struct {
+	ClientID    string        `json:"client_id"`
+	ServerID    string        `json:"server_id"`
+	Name        string        `json:"name"`
+	Address     string        `json:"address"`
+	Queries     int           `json:"queries"`
+	Blocked     int           `json:"blocked"`
+	LastQueryAt *time.Time    `json:"last_query_at,omitempty"`
+	TopBlocked  []DomainCount `json:"top_blocked"`
+}
+
+// clientRef клиент по адресу в туннеле
+type clientRef struct {
+	id, serverID, name string
+}
+
+// clientCounters счетчики клиента
+type clientCounters struct {
+	ref       clientRef
+	address   string
+	queries   int
+	blocked   int
+	lastQuery time.Time
+	domains   map[string]int
+}
+
+var (
+	statsMu sync.Mutex
+	stats   = make(map[string]*clientCounters) // ID клиента -> счетчики
+)
+
+// record учитывает запрос клиента (blocked - ответили блокировкой)
+func record(ref clientRef, address, name string, blocked bool) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	c := stats[ref.id]
+	if c == nil {
+		c = &clientCounters{domains: make(map[string]int)}
+		stats[ref.id] = c
+	}
+	c.ref, c.address = ref, address
+	c.queries++
+	c.lastQuery = time.Now()
+	if blocked {
+		c.blocked++
+		if _, ok := c.domains[name]; ok || len(c.domains) < maxTrackedDomains {
+			c.domains[name]++
+		}
+	}
+}
+
+// Stats статистика по клиентам (top - сколько заблокированных доменов показывать)
+func Stats(top int) []ClientStats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	result := make([]ClientStats, 0, len(stats))
+	for id, c := range stats {
+		lastQuery := c.lastQuery
+		entry := ClientStats{
+			ClientID:    id,
+			ServerID:    c.ref.serverID,
+			Name:        c.ref.name,
+			Address:     c.address,
+			Queries:     c.queries,
+			Blocked:     c.blocked,
+			LastQueryAt: &lastQuery,
+			TopBlocked:  []DomainCount{},
+		}
+		for domain, count := range c.domains {
+			entry.TopBlocked = append(entry.TopBlocked, DomainCount{Domain: domain, Count: count})
+		}
+		sort.Slice(entry.TopBlocked, func(i, j int) bool {
+			a, b := entry.TopBlocked[i], entry.TopBlocked[j]
+			if a.Count != b.Count {
+				return a.Count > b.Count
+			}
+			return a.Domain < b.Domain
+		})
+		if top >= 0 && len(entry.TopBlocked) > top {
+			entry.TopBlocked = entry.TopBlocked[:top]
+		}
+		result = append(result, entry)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Queries != result[j].Queries {
+			return result[i].Queries > result[j].Queries
+		}
+		return result[i].ClientID < result[j].ClientID
+	})
+	return result
+}
+
+// ResetStats обнуляет статистику
+func ResetStats() {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	stats = make(map[string]*clientCounters)
+}
@@ -0,0 +1,645 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"wg-panel/internal/database"
+	"wg-panel/internal/wireguard"
+)
+
+// REST API версии 1: ресурсы /servers, /clients, JSON тела запросов и ошибок
+
+const (
+	apiV1Prefix     = "/api/v1/"
+	defaultPageSize = 50
+	maxPageSize     = 500
+	maxRequestBody  = 1 << 20
+	onlineThreshold = 30 * time.Second // Клиент онлайн если handshake был недавно
+)
+
+// apiErrorBody тело ответа с ошибкой
+type apiErrorBody struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// apiPage страница списка
+type apiPage struct {
+	Items   interface{} `json:"items"`
+	Total   int         `json:"total"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+}
+
+// serverCreateRequest тело запроса создания сервера
+type serverCreateRequest struct {
+	Name       string `json:"name"`
+	Address    string `json:"address"`
+	ListenPort int    `json:"listen_port"`
+	DNS        string `json:"dns"`
+}
+
+// clientCreateRequest тело запроса создания клиента
+type clientCreateRequest struct {
+	Name      string     `json:"name"`
+	Comment   string     `json:"comment"`
+	Address   string     `json:"address"`    // Статический IP (пусто - следующий свободный)
+	ExpiresAt *time.Time `json:"expires_at"` // Срок действия (nil - бессрочно)
+}
+
+// portForwardRequest тело запроса добавления проброса порта
+type portForwardRequest struct {
+	Port          int      `json:"port"`          // Внешний порт или начало диапазона
+	PortEnd       int      `json:"port_end"`      // Конец диапазона (0 - один порт)
+	InternalPort  int      `json:"internal_port"` // Порт на клиенте (0 - как внешний)
+	Protocol      string   `json:"protocol"`
+	SourceCIDRs   []string `json:"source_cidrs"`   // Разрешенные источники (пусто - любые)
+	AllInterfaces bool     `json:"all_interfaces"` // Принимать на всех интерфейсах
+	Description   string   `json:"description"`
+}
+
+// adoptRequest тело запроса переноса интерфейсов в панель
+type adoptRequest struct {
+	Interfaces []string `json:"interfaces"`
+}
+
+// writeJSON отправляет JSON ответ
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError отправляет структурированную ошибку
+func writeAPIError(w http.ResponseWriter, err *opError) {
+	var body apiErrorBody
+	body.Error.Code = err.Code
+	body.Error.Message = err.Message
+	writeJSON(w, err.Status, body)
+}
+
+// methodNotAllowed отвечает 405 со списком разрешенных методов
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeAPIError(w, &opError{Status: 405, Code: codeMethodNotAllowed, Message: "Method not allowed"})
+}
+
+// decodeJSON читает JSON тело запроса
+func decodeJSON(r *http.Request, v interface{}) *opError {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxRequestBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return errBadRequest("Invalid JSON body: " + err.Error())
+	}
+	return nil
+}
+
+// requirePerm проверяет право пользователя для конкретной операции
+func requirePerm(w http.ResponseWriter, r *http.Request, perm int) bool {
+	if !roleAllows(currentUser(r).Role, perm) {
+		writeAPIError(w, errForbidden())
+		return false
+	}
+	return true
+}
+
+// v1Route маршрут REST API: путь относительно /api/v1/ ({...} - параметр пути)
+// и поддерживаемые методы. По этой таблице проверяется и спецификация OpenAPI
+type v1Route struct {
+	path    string
+	methods []string
+	serve   func(w http.ResponseWriter, r *http.Request, params []string)
+}
+
+// v1Routes маршруты REST API: совпадает первый подходящий путь
+var v1Routes = []v1Route{
+	{"servers", []string{"GET", "POST"}, func(w http.ResponseWriter, r *http.Request, _ []string) { v1Servers(w, r) }},
+	{"servers/adopt", []string{"GET", "POST"}, func(w http.ResponseWriter, r *http.Request, _ []string) { v1AdoptServers(w, r) }},
+	{"servers/{id}", []string{"GET", "PATCH", "DELETE"}, func(w http.ResponseWriter, r *http.Request, p []string) { v1Server(w, r, p[0]) }},
+	{"servers/{id}/clients", []string{"GET", "POST"}, func(w http.ResponseWriter, r *http.Request, p []string) { v1ServerClients(w, r, p[0]) }},
+	{"servers/{id}/clients/{client_id}", []string{"GET", "PATCH", "DELETE"}, v1ServerClient},
+	{"servers/{id}/bundle", []string{"GET"}, v1Bundle},
+	{"clients", []string{"GET"}, func(w http.ResponseWriter, r *http.Request, _ []string) { v1Clients(w, r, "") }},
+	{"clients/import", []string{"POST"}, func(w http.ResponseWriter, r *http.Request, _ []string) { v1ImportClients(w, r) }},
+	{"clients/export", []string{"GET"}, func(w http.ResponseWriter, r *http.Request, _ []string) { v1ExportClients(w, r) }},
+	{"clients/{client_id}", []string{"GET", "PATCH", "DELETE"}, clientRoute(v1Client)},
+	{"clients/{client_id}/config", []string{"GET"}, clientRoute(v1ClientFile)},
+	{"clients/{client_id}/qr", []string{"GET"}, clientRoute(v1ClientFile)},
+	{"clients/{client_id}/port-forwards", []string{"GET", "POST"}, clientRoute(v1PortForwards)},
+	{"clients/{client_id}/port-forwards/{port}/{protocol}", []string{"DELETE"}, clientRoute(v1PortForward)},
+	{"reconcile", []string{"GET", "POST"}, func(w http.ResponseWriter, r *http.Request, _ []string) { v1Reconcile(w, r) }},
+	{"drift", []string{"GET", "POST"}, func(w http.ResponseWriter, r *http.Request, _ []string) { v1Drift(w, r) }},
+	{"stats", []string{"GET"}, v1Stats},
+	{"dns", []string{"GET", "PATCH"}, func(w http.ResponseWriter, r *http.Request, _ []string) { v1DNS(w, r) }},
+	{"dns/refresh", []string{"POST"}, func(w http.ResponseWriter, r *http.Request, _ []string) { v1DNSRefresh(w, r) }},
+	{"dns/stats", []string{"GET"}, func(w http.ResponseWriter, r *http.Request, _ []string) { v1DNSStats(w, r) }},
+	{"dns/lists/{name}", []string{"PUT", "DELETE"}, func(w http.ResponseWriter, r *http.Request, p []string) { v1DNSList(w, r, p[0]) }},
+	{"me", []string{"GET"}, v1Me},
+}
+
+// match проверяет путь запроса (без префикса, по частям) и возвращает параметры
+func (route v1Route) match(parts []string) ([]string, bool) {
+	segments := strings.Split(route.path, "/")
+	if len(segments) != len(parts) {
+		return nil, false
+	}
+	var params []string
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") {
+			params = append(params, parts[i])
+		} else if segment != parts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// HandleAPIv1 маршрутизирует запросы /api/v1/...
+func HandleAPIv1(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, apiV1Prefix), "/")
+	var parts []string
+	if path != "" {
+		parts = strings.Split(path, "/")
+	}
+
+	for _, route := range v1Routes {
+		params, ok := route.match(parts)
+		if !ok {
+			continue
+		}
+		if !containsMethod(route.methods, r.Method) {
+			methodNotAllowed(w, route.methods...)
+			return
+		}
+		route.serve(w, r, params)
+		return
+	}
+	writeAPIError(w, errNotFound("Unknown API endpoint"))
+}
+
+// containsMethod поддерживает ли маршрут метод
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// clientRoute маршрут клиента: первый параметр - ID доступного пользователю клиента
+func clientRoute(serve func(w http.ResponseWriter, r *http.Request, client *database.Client, params []string)) func(http.ResponseWriter, *http.Request, []string) {
+	return func(w http.ResponseWriter, r *http.Request, params []string) {
+		client, opErr := accessibleClient(currentUser(r), params[0])
+		if opErr != nil {
+			writeAPIError(w, opErr)
+			return
+		}
+		serve(w, r, client, params[1:])
+	}
+}
+
+// v1ServerClient GET/PATCH/DELETE /servers/{id}/clients/{client_id}
+func v1ServerClient(w http.ResponseWriter, r *http.Request, params []string) {
+	client, opErr := accessibleClient(currentUser(r), params[1])
+	if opErr == nil && client.ServerID != params[0] {
+		opErr = errNotFound("Client not found")
+	}
+	if opErr != nil {
+		writeAPIError(w, opErr)
+		return
+	}
+	v1Client(w, r, client, nil)
+}
+
+// v1Bundle GET /servers/{id}/bundle
+func v1Bundle(w http.ResponseWriter, r *http.Request, params []string) {
+	if !canAccessServer(currentUser(r), params[0]) {
+		writeAPIError(w, errForbidden())
+		return
+	}
+	server := findServer(params[0])
+	if server == nil {
+		writeAPIError(w, errNotFound("Server not found"))
+		return
+	}
+	v1ServerBundle(w, r, server)
+}
+
+// v1Stats GET /stats - обновить статистику и вернуть клиентов
+func v1Stats(w http.ResponseWriter, r *http.Request, _ []string) {
+	wireguard.UpdateStats(DB)
+	v1Clients(w, r, "")
+}
+
+// v1Me GET /me
+func v1Me(w http.ResponseWriter, r *http.Request, _ []string) {
+	writeJSON(w, 200, newUserInfo(currentUser(r)))
+}
+
+// v1Servers GET/POST /servers
+func v1Servers(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	switch r.Method {
+	case "GET":
+		servers := []database.Server{}
+		for _, server := range DB.Servers {
+			if canAccessServer(user, server.ID) {
+				servers = append(servers, server)
+			}
+		}
+		writeJSON(w, 200, newServerInfos(servers))
+
+	case "POST":
+		if !requirePerm(w, r, permServers) {
+			return
+		}
+		var req serverCreateRequest
+		if opErr := decodeJSON(r, &req); opErr != nil {
+			writeAPIError(w, opErr)
+			return
+		}
+		server, opErr := opCreateServer(req.Name, req.Address, req.ListenPort, req.DNS)
+		if opErr != nil {
+			writeAPIError(w, opErr)
+			return
+		}
+		writeJSON(w, 201, newServerInfo(server))
+
+	default:
+		methodNotAllowed(w, "GET", "POST")
+	}
+}
+
+// v1AdoptServers GET/POST /servers/adopt - предпросмотр и перенос интерфейсов вне панели
+func v1AdoptServers(w http.ResponseWriter, r *http.Request) {
+	if !requirePerm(w, r, permServers) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, 200, newAdoptCandidateInfos(currentUser(r), wireguard.PreviewAdoption(DB)))
+
+	case "POST":
+		var req adoptRequest
+		if opErr := decodeJSON(r, &req); opErr != nil {
+			writeAPIError(w, opErr)
+			return
+		}
+		candidates, opErr := opAdoptInterfaces(req.Interfaces)
+		if opErr != nil {
+			writeAPIError(w, opErr)
+			return
+		}
+		writeJSON(w, 201, newAdoptCandidateInfos(currentUser(r), candidates))
+
+	default:
+		methodNotAllowed(w, "GET", "POST")
+	}
+}
+
+// v1Reconcile GET /reconcile - отчет о расхождениях БД и системы, POST - применить их
+func v1Reconcile(w http.ResponseWriter, r *http.Request) {
+	if !requirePerm(w, r, permServers) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, 200, wireguard.Reconcile(DB, true))
+	case "POST":
+		writeJSON(w, 200, wireguard.Reconcile(DB, false))
+	default:
+		methodNotAllowed(w, "GET", "POST")
+	}
+}
+
+// v1Drift GET /drift - результат последней фоновой проверки, POST - проверить сейчас
+func v1Drift(w http.ResponseWriter, r *http.Request) {
+	if !requirePerm(w, r, permServers) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, 200, wireguard.LastDrift())
+	case "POST":
+		writeJSON(w, 200, wireguard.CheckDrift(DB))
+	default:
+		methodNotAllowed(w, "GET", "POST")
+	}
+}
+
+// v1Server GET/PATCH/DELETE /servers/{id}
+func v1Server(w http.ResponseWriter, r *http.Request, id string) {
+	if !canAccessServer(currentUser(r), id) {
+		writeAPIError(w, errForbidden())
+		return
+	}
+
+	server := findServer(id)
+	if server == nil {
+		writeAPIError(w, errNotFound("Server not found"))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, 200, newServerInfo(server))
+
+	case "PATCH":
+		if !requirePerm(w, r, permServers) {
+			return
+		}
+		var update serverUpdate
+		if opErr := decodeJSON(r, &update); opErr != nil {
+			writeAPIError(w, opErr)
+			return
+		}
+		server, opErr := opUpdateServer(id, update)
+		if opErr != nil {
+			writeAPIError(w, opErr)
+			return
+		}
+		writeJSON(w, 200, newServerInfo(server))
+
+	case "DELETE":
+		if !requirePerm(w, r, permServers) {
+			return
+		}
+		if opErr := opDeleteServer(id); opErr != nil {
+			writeAPIError(w, opErr)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		methodNotAllowed(w, "GET", "PATCH", "DELETE")
+	}
+}
+
+// v1ServerClients GET/POST /servers/{id}/clients
+func v1ServerClients(w http.ResponseWriter, r *http.Request, serverID string) {
+	if !canAccessServer(currentUser(r), serverID) {
+		writeAPIError(w, errForbidden())
+		return
+	}
+	if findServer(serverID) == nil {
+		writeAPIError(w, errNotFound("Server not found"))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		v1Clients(w, r, serverID)
+
+	case "POST":
+		if !requirePerm(w, r, permClients) {
+			return
+		}
+		var req clientCreateRequest
+		if opErr := decodeJSON(r, &req); opErr != nil {
+			writeAPIError(w, opErr)
+			return
+		}
+		client, opErr := opCreateClient(currentUser(r), serverID, req)
+		if opErr != nil {
+			writeAPIError(w, opErr)
+			return
+		}
+		writeJSON(w, 201, newClientInfo(currentUser(r), client))
+
+	default:
+		methodNotAllowed(w, "GET", "POST")
+	}
+}
+
+// v1Clients отдает список клиентов с фильтрами и пагинацией
+//
+// Параметры: server_id, enabled=true|false, online=true|false, q (поиск по имени,
+// комментарию и адресу), page (с 1), per_page (до 500)
+func v1Clients(w http.ResponseWriter, r *http.Request, serverID string) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	query := r.URL.Query()
+	if serverID == "" {
+		serverID = query.Get("server_id")
+	}
+
+	filterBool := func(name string) (*bool, *opError) {
+		value := query.Get(name)
+		if value == "" {
+			return nil, nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errBadRequest(fmt.Sprintf("Invalid %s value", name))
+		}
+		return &b, nil
+	}
+	enabled, opErr := filterBool("enabled")
+	if opErr != nil {
+		writeAPIError(w, opErr)
+		return
+	}
+	online, opErr := filterBool("online")
+	if opErr != nil {
+		writeAPIError(w, opErr)
+		return
+	}
+	search := strings.ToLower(query.Get("q"))
+
+	page, perPage := 1, defaultPageSize
+	if value := query.Get("page"); value != "" {
+		p, err := strconv.Atoi(value)
+		if err != nil || p < 1 {
+			writeAPIError(w, errBadRequest("Invalid page value"))
+			return
+		}
+		page = p
+	}
+	if value := query.Get("per_page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxPageSize {
+			writeAPIError(w, errBadRequest(fmt.Sprintf("per_page must be between 1 and %d", maxPageSize)))
+			return
+		}
+		perPage = n
+	}
+
+	user := currentUser(r)
+	filtered := []database.Client{}
+	for _, client := range DB.Clients {
+		if !canAccessServer(user, client.ServerID) {
+			continue
+		}
+		if serverID != "" && client.ServerID != serverID {
+			continue
+		}
+		if enabled != nil && client.Enabled != *enabled {
+			continue
+		}
+		if online != nil && isOnline(client) != *online {
+			continue
+		}
+		if search != "" &&
+			!strings.Contains(strings.ToLower(client.Name), search) &&
+			!strings.Contains(strings.ToLower(client.Comment), search) &&
+			!strings.Contains(client.Address, search) {
+			continue
+		}
+		filtered = append(filtered, client)
+	}
+
+	start := (page - 1) * perPage
+	if start > len(filtered) {
+		start = len(filtered)
+	}
+	end := start + perPage
+	if end > len(filtered) {
+		end = len(filtered)
+	}
+
+	writeJSON(w, 200, apiPage{
+		Items:   newClientInfos(user, filtered[start:end]),
+		Total:   len(filtered),
+		Page:    page,
+		PerPage: perPage,
+	})
+}
+
+// isOnline проверяет недавний handshake клиента
+func isOnline(client database.Client) bool {
+	return !client.LastHandshake.IsZero() && time.Since(client.LastHandshake) < onlineThreshold
+}
+
+// v1Client GET/PATCH/DELETE /clients/{client_id}
+func v1Client(w http.ResponseWriter, r *http.Request, client *database.Client, _ []string) {
+	user := currentUser(r)
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, 200, newClientInfo(user, client))
+	case "PATCH":
+		if !requirePerm(w, r, permClients) {
+			return
+		}
+		var update clientUpdate
+		if opErr := decodeJSON(r, &update); opErr != nil {
+			writeAPIError(w, opErr)
+			return
+		}
+		updated, opErr := opUpdateClient(user, client.ID, update)
+		if opErr != nil {
+			writeAPIError(w, opErr)
+			return
+		}
+		writeJSON(w, 200, newClientInfo(user, updated))
+	case "DELETE":
+		if !requirePerm(w, r, permClients) {
+			return
+		}
+		if opErr := opDeleteClient(user, client.ID); opErr != nil {
+			writeAPIError(w, opErr)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, "GET", "PATCH", "DELETE")
+	}
+}
+
+// v1ClientFile GET /clients/{client_id}/config и /qr
+func v1ClientFile(w http.ResponseWriter, r *http.Request, client *database.Client, _ []string) {
+	if !requirePerm(w, r, permClients) {
+		return
+	}
+	_, config, opErr := clientConfig(currentUser(r), client.ID)
+	if opErr != nil {
+		writeAPIError(w, opErr)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/config") {
+		w.Header().Set("Content-Type", "application/x-wireguard-profile")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.conf", database.SanitizeFilename(client.Name)))
+		w.Write([]byte(config))
+		return
+	}
+	png, err := wireguard.GenerateQRCode(config)
+	if err != nil {
+		writeAPIError(w, errInternal("Failed to generate QR code"))
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
+// v1PortForwards GET/POST /clients/{client_id}/port-forwards
+func v1PortForwards(w http.ResponseWriter, r *http.Request, client *database.Client, _ []string) {
+	switch r.Method {
+	case "GET":
+		forwards := client.PortForwards
+		if forwards == nil {
+			forwards = []database.PortForward{}
+		}
+		writeJSON(w, 200, forwards)
+	case "POST":
+		if !requirePerm(w, r, permClients) {
+			return
+		}
+		var req portForwardRequest
+		if opErr := decodeJSON(r, &req); opErr != nil {
+			writeAPIError(w, opErr)
+			return
+		}
+		user := currentUser(r)
+		updated, opErr := opAddPortForward(user, client.ID, database.PortForward{
+			Port:          req.Port,
+			PortEnd:       req.PortEnd,
+			InternalPort:  req.InternalPort,
+			Protocol:      req.Protocol,
+			SourceCIDRs:   req.SourceCIDRs,
+			AllInterfaces: req.AllInterfaces,
+			Description:   req.Description,
+		})
+		if opErr != nil {
+			writeAPIError(w, opErr)
+			return
+		}
+		writeJSON(w, 201, newClientInfo(user, updated))
+	default:
+		methodNotAllowed(w, "GET", "POST")
+	}
+}
+
+// v1PortForward DELETE /clients/{client_id}/port-forwards/{port}/{protocol}
+func v1PortForward(w http.ResponseWriter, r *http.Request, client *database.Client, params []string) {
+	if !requirePerm(w, r, permClients) {
+		return
+	}
+	port, err := strconv.Atoi(params[0])
+	if err != nil {
+		writeAPIError(w, errBadRequest("Invalid port"))
+		return
+	}
+	user := currentUser(r)
+	updated, opErr := opRemovePortForward(user, client.ID, port, params[1])
+	if opErr != nil {
+		writeAPIError(w, opErr)
+		return
+	}
+	writeJSON(w, 200, newClientInfo(user, updated))
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"wg-panel/internal/database"
+)
+
+// Права доступа к обработчикам
+const (
+	permView    = iota // Просмотр серверов и клиентов
+	permClients        // Управление клиентами
+	permServers        // Управление серверами
+	permUsers          // Управление пользователями
+)
+
+const sessionTTL = 24 * time.Hour
+
+// session сессия авторизованного пользователя
+type session struct {
+	Username  string
+	CSRFToken string // Токен для защиты изменяющих запросов
+	Expires   time.Time
+}
+
+var (
+	sessions   = make(map[string]*session)
+	sessionsMu sync.Mutex
+)
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+// randomToken генерирует случайный hex токен
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// createSession создает новую сессию и возвращает её токен
+func createSession(username string) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	csrfToken, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	// Заодно чистим истекшие сессии
+	now := time.Now()
+	for t, s := range sessions {
+		if now.After(s.Expires) {
+			delete(sessions, t)
+		}
+	}
+
+	sessions[token] = &session{Username: username, CSRFToken: csrfToken, Expires: now.Add(sessionTTL)}
+	return token, nil
+}
+
+// getSession возвращает сессию по токену
+func getSession(token string) *session {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	s, ok := sessions[token]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(s.Expires) {
+		delete(sessions, token)
+		return nil
+	}
+	return s
+}
+
+// sessionFromRequest возвращает сессию по cookie запроса
+func sessionFromRequest(r *http.Request) *session {
+	cookie, err := r.Cookie("auth")
+	if err != nil {
+		return nil
+	}
+	return getSession(cookie.Value)
+}
+
+// deleteSession удаляет сессию
+func deleteSession(token string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	delete(sessions, token)
+}
+
+// isSafeMethod проверяет что метод не изменяет состояние
+func isSafeMethod(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "OPTIONS"
+}
+
+// checkCSRF проверяет токен сессии и источник запроса
+func checkCSRF(r *http.Request, s *session) bool {
+	if !sameOrigin(r) {
+		return false
+	}
+
+	token := r.Header.Get("X-CSRF-Token")
+	if token == "" {
+		token = r.FormValue("csrf_token")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRFToken)) == 1
+}
+
+// sameOrigin проверяет заголовки Origin/Referer (если браузер их прислал)
+func sameOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	// За доверенным прокси исходный хост передается в X-Forwarded-Host
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil && isTrustedProxy(host) {
+		if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" && strings.EqualFold(u.Host, forwarded) {
+			return true
+		}
+	}
+	return false
+}
+
+// roleAllows проверяет что роль имеет право
+func roleAllows(role string, perm int) bool {
+	switch role {
+	case database.RoleOwner:
+		return true
+	case database.RoleOperator:
+		return perm == permView || perm == permClients
+	case database.RoleViewer:
+		return perm == permView
+	}
+	return false
+}
+
+// currentUser возвращает пользователя текущего запроса
+func currentUser(r *http.Request) *database.User {
+	user, _ := r.Context().Value(userContextKey).(*database.User)
+	return user
+}
+
+// withUser добавляет в контекст запроса копию пользователя: обработчики читают
+// ее без блокировки, пока другой запрос меняет Config
+func withUser(r *http.Request, user *database.User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey, copyUser(user)))
+}
+
+// copyUser копия пользователя со своими списком серверов и настройками 2FA
+func copyUser(user *database.User) *database.User {
+	copied := *user
+	copied.Servers = append([]string(nil), user.Servers...)
+	if user.TwoFactor != nil {
+		tf := *user.TwoFactor
+		tf.RecoveryCodes = append([]string(nil), user.TwoFactor.RecoveryCodes...)
+		copied.TwoFactor = &tf
+	}
+	return &copied
+}
+
+// canAccessServer проверяет доступ пользователя к серверу
+func canAccessServer(user *database.User, serverID string) bool {
+	if user == nil {
+		return false
+	}
+	if user.Role == database.RoleOwner || len(user.Servers) == 0 {
+		return true
+	}
+	for _, id := range user.Servers {
+		if id == serverID {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP определяет IP клиента с учетом доверенных прокси
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+
+	// Идем по цепочке X-Forwarded-For справа налево до первого недоверенного адреса
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// isTrustedProxy проверяет входит ли адрес в список доверенных прокси
+func isTrustedProxy(ip string) bool {
+	return Config != nil && ipAllowed(ip, Config.TrustedProxies)
+}
@@ -0,0 +1,424 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"wg-panel/internal/database"
+	"wg-panel/internal/wireguard"
+)
+
+// Пакетный импорт и экспорт клиентов, ZIP с конфигами сервера
+
+const (
+	maxImportBody = 4 << 20
+	maxImportRows = 5000
+)
+
+// exportColumns колонки CSV экспорта (первые совпадают с импортом)
+var exportColumns = []string{"name", "comment", "server", "address", "expires_at", "id", "server_id", "enabled", "public_key", "created_at"}
+
+// importRow строка импорта
+type importRow struct {
+	Name      string `json:"name"`
+	Comment   string `json:"comment"`
+	Server    string `json:"server"`     // ID, имя или интерфейс сервера (по умолчанию ?server_id)
+	Address   string `json:"address"`    // Статический IP (пусто - следующий свободный)
+	ExpiresAt string `json:"expires_at"` // ГГГГ-ММ-ДД (включительно) или RFC 3339
+}
+
+// importRowResult результат проверки или создания строки
+type importRowResult struct {
+	Row      int    `json:"row"`
+	Name     string `json:"name"`
+	ServerID string `json:"server_id,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Status   string `json:"status"` // ok, created, error
+	Error    string `json:"error,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// importReport отчет импорта
+type importReport struct {
+	DryRun  bool              `json:"dry_run"`
+	Valid   bool              `json:"valid"`
+	Created int               `json:"created"`
+	Errors  int               `json:"errors"`
+	Rows    []importRowResult `json:"rows"`
+}
+
+// exportRow клиент в экспорте (без приватного ключа)
+type exportRow struct {
+	ID           string                 `json:"id"`
+	ServerID     string                 `json:"server_id"`
+	Server       string                 `json:"server"` // Интерфейс сервера
+	Name         string                 `json:"name"`
+	Comment      string                 `json:"comment"`
+	Address      string                 `json:"address"`
+	Enabled      bool                   `json:"enabled"`
+	PublicKey    string                 `json:"public_key"`
+	CreatedAt    time.Time              `json:"created_at"`
+	ExpiresAt    *time.Time             `json:"expires_at,omitempty"`
+	PortForwards []database.PortForward `json:"port_forwards"`
+}
+
+// parseExpiry разбирает срок действия: дата включительно или RFC 3339
+func parseExpiry(value string) (*time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	if day, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		// Клиент работает до конца указанного дня
+		t := day.AddDate(0, 0, 1)
+		return &t, nil
+	}
+	return nil, fmt.Errorf("Invalid expiry date %q: use YYYY-MM-DD or RFC 3339", value)
+}
+
+// resolveServerRef ищет сервер по ID, имени или интерфейсу
+func resolveServerRef(ref string) (*database.Server, error) {
+	if server := findServer(ref); server != nil {
+		return server, nil
+	}
+
+	var found *database.Server
+	for i := range DB.Servers {
+		if DB.Servers[i].Name == ref || DB.Servers[i].Interface == ref {
+			if found != nil {
+				return nil, fmt.Errorf("Several servers match %q, use the server ID", ref)
+			}
+			found = &DB.Servers[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("Server not found: %s", ref)
+	}
+	return found, nil
+}
+
+// parseImport читает строки импорта из JSON массива или CSV с заголовком
+func parseImport(r *http.Request) ([]importRow, *opError) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxImportBody+1))
+	if err != nil {
+		return nil, errBadRequest("Failed to read body")
+	}
+	if len(data) > maxImportBody {
+		return nil, errBadRequest(fmt.Sprintf("Import is larger than %d MB", maxImportBody>>20))
+	}
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("\xef\xbb\xbf"))
+
+	var rows []importRow
+	if strings.Contains(r.Header.Get("Content-Type"), "json") || bytes.HasPrefix(data, []byte("[")) {
+		// Лишние поля не ошибка - можно загрузить JSON экспорта
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return nil, errBadRequest("Invalid JSON: " + err.Error())
+		}
+	} else {
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.TrimLeadingSpace = true
+		reader.FieldsPerRecord = -1
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, errBadRequest("Invalid CSV: " + err.Error())
+		}
+		if len(records) == 0 {
+			return nil, errBadRequest("Empty import")
+		}
+
+		// Колонки по заголовку; лишние колонки экспорта (id, enabled...) пропускаем
+		index := map[string]int{}
+		for i, column := range records[0] {
+			index[strings.ToLower(strings.TrimSpace(column))] = i
+		}
+		if _, ok := index["name"]; !ok {
+			return nil, errBadRequest("CSV header must contain a name column")
+		}
+		field := func(record []string, column string) string {
+			if i, ok := index[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		for _, record := range records[1:] {
+			rows = append(rows, importRow{
+				Name:      field(record, "name"),
+				Comment:   field(record, "comment"),
+				Server:    field(record, "server"),
+				Address:   field(record, "address"),
+				ExpiresAt: field(record, "expires_at"),
+			})
+		}
+	}
+
+	if len(rows) == 0 {
+		return nil, errBadRequest("Empty import")
+	}
+	if len(rows) > maxImportRows {
+		return nil, errBadRequest(fmt.Sprintf("Too many rows: %d (max %d)", len(rows), maxImportRows))
+	}
+	return rows, nil
+}
+
+// v1ImportClients POST /clients/import?dry_run=true&server_id=
+//
+// Все строки проверяются заранее; если есть ошибки - ничего не создается
+func v1ImportClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	if !requirePerm(w, r, permClients) {
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	defaultServer := r.URL.Query().Get("server_id")
+
+	rows, opErr := parseImport(r)
+	if opErr != nil {
+		writeAPIError(w, opErr)
+		return
+	}
+
+	user := currentUser(r)
+	report := importReport{DryRun: dryRun, Rows: make([]importRowResult, len(rows))}
+	requests := make([]clientCreateRequest, len(rows))
+	batchIPs := map[string]int{} // server_id/ip -> номер строки
+
+	for i, row := range rows {
+		result := &report.Rows[i]
+		result.Row = i + 1
+		result.Name = strings.TrimSpace(row.Name)
+		result.Status = "ok"
+
+		fail := func(message string) {
+			result.Status = "error"
+			result.Error = message
+			report.Errors++
+		}
+
+		ref := row.Server
+		if ref == "" {
+			ref = defaultServer
+		}
+		if ref == "" {
+			fail("Server is required")
+			continue
+		}
+		server, err := resolveServerRef(ref)
+		if err != nil {
+			fail(err.Error())
+			continue
+		}
+		result.ServerID = server.ID
+
+		expiresAt, err := parseExpiry(row.ExpiresAt)
+		if err != nil {
+			fail(err.Error())
+			continue
+		}
+
+		req := clientCreateRequest{
+			Name:      result.Name,
+			Comment:   strings.TrimSpace(row.Comment),
+			Address:   strings.TrimSpace(row.Address),
+			ExpiresAt: expiresAt,
+		}
+		if opErr := validateNewClient(user, server.ID, req); opErr != nil {
+			fail(opErr.Message)
+			continue
+		}
+
+		if req.Address != "" {
+			key := server.ID + "/" + req.Address
+			if other, ok := batchIPs[key]; ok {
+				fail(fmt.Sprintf("IP %s is also used in row %d", req.Address, other))
+				continue
+			}
+			batchIPs[key] = result.Row
+			result.Address = req.Address
+		}
+
+		requests[i] = req
+	}
+
+	report.Valid = report.Errors == 0
+	if dryRun || !report.Valid {
+		status := http.StatusOK
+		if !report.Valid && !dryRun {
+			status = http.StatusUnprocessableEntity
+		}
+		writeJSON(w, status, report)
+		return
+	}
+
+	// Сначала статические адреса, чтобы автоматическая выдача их не заняла
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return requests[order[a]].Address != "" && requests[order[b]].Address == ""
+	})
+
+	for _, i := range order {
+		result := &report.Rows[i]
+		client, opErr := opCreateClient(user, result.ServerID, requests[i])
+		if opErr != nil {
+			result.Status = "error"
+			result.Error = opErr.Message
+			report.Errors++
+			continue
+		}
+		result.Status = "created"
+		result.ClientID = client.ID
+		result.Address = client.Address
+		report.Created++
+	}
+	report.Valid = report.Errors == 0
+
+	writeJSON(w, http.StatusCreated, report)
+}
+
+// v1ExportClients GET /clients/export?format=csv|json&server_id=
+func v1ExportClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		writeAPIError(w, errBadRequest("Format must be csv or json"))
+		return
+	}
+	serverID := r.URL.Query().Get("server_id")
+
+	user := currentUser(r)
+	rows := []exportRow{}
+	for _, client := range DB.Clients {
+		if !canAccessServer(user, client.ServerID) || (serverID != "" && client.ServerID != serverID) {
+			continue
+		}
+		row := exportRow{
+			ID:           client.ID,
+			ServerID:     client.ServerID,
+			Name:         client.Name,
+			Comment:      client.Comment,
+			Address:      client.Address,
+			Enabled:      client.Enabled,
+			PublicKey:    client.PublicKey,
+			CreatedAt:    client.CreatedAt,
+			ExpiresAt:    client.ExpiresAt,
+			PortForwards: client.PortForwards,
+		}
+		if row.PortForwards == nil {
+			row.PortForwards = []database.PortForward{}
+		}
+		if server := findServer(client.ServerID); server != nil {
+			row.Server = server.Interface
+		}
+		rows = append(rows, row)
+	}
+
+	filename := "clients_" + time.Now().Format("2006-01-02") + "." + format
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+
+	if format == "json" {
+		writeJSON(w, 200, rows)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	writer := csv.NewWriter(w)
+	writer.Write(exportColumns)
+	for _, row := range rows {
+		expires := ""
+		if row.ExpiresAt != nil {
+			expires = row.ExpiresAt.Format(time.RFC3339)
+		}
+		writer.Write([]string{
+			row.Name, row.Comment, row.Server, row.Address, expires,
+			row.ID, row.ServerID, strconv.FormatBool(row.Enabled), row.PublicKey, row.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	writer.Flush()
+}
+
+// v1ServerBundle GET /servers/{id}/bundle - ZIP с .conf и QR PNG всех клиентов сервера
+func v1ServerBundle(w http.ResponseWriter, r *http.Request, server *database.Server) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	if !requirePerm(w, r, permClients) {
+		return
+	}
+
+	// Внешний адрес определяем один раз на весь архив
+	endpoint := database.GetServerEndpoint()
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	used := map[string]int{}
+
+	for _, client := range DB.Clients {
+		// У перенесенных клиентов приватный ключ неизвестен - конфиг не собрать
+		if client.ServerID != server.ID || client.PrivateKey == "" {
+			continue
+		}
+
+		name := database.SanitizeFilename(client.Name)
+		used[name]++
+		if used[name] > 1 {
+			name = fmt.Sprintf("%s_%d", name, used[name])
+		}
+
+		config := wireguard.GenerateClientConfigWithEndpoint(client, server, endpoint)
+		png, err := wireguard.GenerateQRCode(config)
+		if err != nil {
+			writeAPIError(w, errInternal("Failed to generate QR code for "+client.Name))
+			return
+		}
+
+		files := []struct {
+			name    string
+			content []byte
+		}{{name + ".conf", []byte(config)}, {name + ".png", png}}
+		for _, f := range files {
+			file, err := archive.Create(f.name)
+			if err == nil {
+				_, err = file.Write(f.content)
+			}
+			if err != nil {
+				writeAPIError(w, errInternal("Failed to build archive"))
+				return
+			}
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		writeAPIError(w, errInternal("Failed to build archive"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s_clients.zip", database.SanitizeFilename(server.Name)))
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"wg-panel/internal/database"
+	"wg-panel/internal/dns"
+)
+
+// dnsUpdate изменяемые настройки фильтрации DNS (nil - не менять)
+type dnsUpdate struct {
+	Blocklists   *[]string `json:"blocklists"`
+	Allowlist    *[]string `json:"allowlist"`
+	Blocklist    *[]string `json:"blocklist"`
+	RefreshHours *int      `json:"refresh_hours"`
+}
+
+// dnsUpdateMu упорядочивает изменения настроек DNS: /api/v1/dns* идут без
+// блокировки БД, а config.json и dns.Configure должны меняться в одном порядке
+var dnsUpdateMu sync.Mutex
+
+// opUpdateDNS меняет списки в config.json и пересобирает фильтр
+func opUpdateDNS(update dnsUpdate) (dns.Status, *opError) {
+	dnsUpdateMu.Lock()
+	defer dnsUpdateMu.Unlock()
+
+	cfg, opErr := saveDNSConfig(update)
+	if opErr != nil {
+		return dns.Status{}, opErr
+	}
+	dns.Configure(cfg)
+
+	// Новые URL скачиваются сразу, остальные списки берутся из кэша
+	return dns.ReloadLists(false), nil
+}
+
+// saveDNSConfig применяет изменения к Config.DNS и сохраняет config.json
+// (чтение, изменение и запись - под одной блокировкой)
+func saveDNSConfig(update dnsUpdate) (database.DNSConfig, *opError) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	cfg := Config.DNS
+
+	if update.Blocklists != nil {
+		sources := []string{}
+		for _, source := range *update.Blocklists {
+			if source = strings.TrimSpace(source); source == "" {
+				continue
+			}
+			if strings.Contains(source, "://") && !strings.HasPrefix(source, "http://") &&
+				!strings.HasPrefix(source, "https://") && !strings.HasPrefix(source, "file://") {
+				return cfg, errBadRequest("Unsupported list source " + source + " (expected http(s)://, file:// or a path)")
+			}
+			sources = append(sources, source)
+		}
+		cfg.Blocklists = sources
+	}
+	if update.Allowlist != nil {
+		domains, opErr := normalizeDomains(*update.Allowlist)
+		if opErr != nil {
+			return cfg, opErr
+		}
+		cfg.Allowlist = domains
+	}
+	if update.Blocklist != nil {
+		domains, opErr := normalizeDomains(*update.Blocklist)
+		if opErr != nil {
+			return cfg, opErr
+		}
+		cfg.Blocklist = domains
+	}
+	if update.RefreshHours != nil {
+		if *update.RefreshHours < 0 {
+			return cfg, errBadRequest("refresh_hours must not be negative")
+		}
+		cfg.RefreshHours = *update.RefreshHours
+	}
+
+	previous := Config.DNS
+	Config.DNS = cfg
+	if err := database.SaveConfig(Config); err != nil {
+		Config.DNS = previous
+		return cfg, errInternal("Failed to save config: " + err.Error())
+	}
+	return cfg, nil
+}
+
+// normalizeDomains проверяет домены и убирает повторы
+func normalizeDomains(values []string) ([]string, *opError) {
+	domains := []string{}
+	seen := make(map[string]bool)
+	for _, value := range values {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		domain := dns.NormalizeDomain(value)
+		if domain == "" {
+			return nil, errBadRequest("Invalid domain " + value)
+		}
+		if !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+	return domains, nil
+}
+
+// v1DNS GET/PATCH /dns
+func v1DNS(w http.ResponseWriter, r *http.Request) {
+	if !requirePerm(w, r, permServers) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, 200, dns.CurrentStatus())
+	case "PATCH":
+		var update dnsUpdate
+		if opErr := decodeJSON(r, &update); opErr != nil {
+			writeAPIError(w, opErr)
+			return
+		}
+		status, opErr := opUpdateDNS(update)
+		if opErr != nil {
+			writeAPIError(w, opErr)
+			return
+		}
+		writeJSON(w, 200, status)
+	default:
+		methodNotAllowed(w, "GET", "PATCH")
+	}
+}
+
+// v1DNSRefresh POST /dns/refresh - скачать списки по URL заново
+func v1DNSRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	if !requirePerm(w, r, permServers) {
+		return
+	}
+	writeJSON(w, 200, dns.ReloadLists(true))
+}
+
+// v1DNSList PUT/DELETE /dns/lists/{name} - загруженные списки (тело - текст списка)
+func v1DNSList(w http.ResponseWriter, r *http.Request, name string) {
+	if !requirePerm(w, r, permServers) {
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		list, err := dns.SaveUpload(name, r.Body)
+		if err != nil {
+			writeAPIError(w, errBadRequest(err.Error()))
+			return
+		}
+		dns.ReloadLists(false)
+		writeJSON(w, 200, list)
+	case "DELETE":
+		if err := dns.DeleteUpload(name); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				writeAPIError(w, errNotFound("List not found"))
+			} else {
+				writeAPIError(w, errBadRequest(err.Error()))
+			}
+			return
+		}
+		dns.ReloadLists(false)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, "PUT", "DELETE")
+	}
+}
+
+// v1DNSStats GET /dns/stats - запросы и блокировки по клиентам доступных серверов
+func v1DNSStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	if !requirePerm(w, r, permView) {
+		return
+	}
+
+	top := 10
+	if value := r.URL.Query().Get("top"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeAPIError(w, errBadRequest("top must be a non-negative number"))
+			return
+		}
+		top = n
+	}
+	serverID := r.URL.Query().Get("server_id")
+
+	user := currentUser(r)
+	stats := []dns.ClientStats{}
+	for _, entry := range dns.Stats(top) {
+		if serverID != "" && entry.ServerID != serverID {
+			continue
+		}
+		if canAccessServer(user, entry.ServerID) {
+			stats = append(stats, entry)
+		}
+	}
+	writeJSON(w, 200, stats)
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"wg-panel/internal/database"
+	"wg-panel/internal/dns"
+)
+
+// Параллельные PATCH /dns с разными полями не теряют изменения друг друга
+func TestUpdateDNSConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	database.ConfigFile = filepath.Join(dir, "config.json")
+	t.Cleanup(func() { dns.Configure(database.DNSConfig{}) })
+
+	list := []string{filepath.Join(dir, "missing.txt")}
+	allow := []string{"allowed.example.com"}
+	block := []string{"blocked.example.com"}
+	hours := 6
+	updates := []dnsUpdate{{Blocklists: &list}, {Allowlist: &allow}, {Blocklist: &block}, {RefreshHours: &hours}}
+
+	for round := 0; round < 100; round++ {
+		Config = &database.Config{Username: "admin"}
+
+		var wg sync.WaitGroup
+		for _, update := range updates {
+			wg.Add(1)
+			go func(update dnsUpdate) {
+				defer wg.Done()
+				if _, opErr := opUpdateDNS(update); opErr != nil {
+					t.Error(opErr.Message)
+				}
+			}(update)
+		}
+		wg.Wait()
+
+		cfg := Config.DNS
+		if strings.Join(cfg.Blocklists, ",") != list[0] || strings.Join(cfg.Allowlist, ",") != allow[0] ||
+			strings.Join(cfg.Blocklist, ",") != block[0] || cfg.RefreshHours != hours {
+			t.Fatalf("round %d: lost update, dns config = %+v", round, cfg)
+		}
+	}
+}
@@ -1,12 +1,15 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 
 	"wg-panel/internal/database"
 	"wg-panel/internal/wireguard"
@@ -15,25 +18,85 @@ import (
 var (
 	DB     *database.Database
 	Config *database.Config
+
+	// configMu защищает Config: пользователи, токены и 2FA меняются из запросов
+	configMu sync.RWMutex
 )
 
-// authMiddleware проверяет авторизацию
-func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// authMiddleware проверяет авторизацию и права пользователя
+func authMiddleware(perm int, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Проверяем cookie
-		cookie, err := r.Cookie("auth")
-		if err != nil || cookie.Value != "authenticated" {
+		// Unix сокет CLI - доступ только у root, действуем от основного администратора
+		if isLocalRequest(r) {
+			configMu.RLock()
+			owner := database.OwnerUser(Config)
+			r = withUser(r, &owner)
+			configMu.RUnlock()
+			next(w, r)
+			return
+		}
+
+		// API токен (Authorization: Bearer) - без cookie и CSRF
+		if value, ok := bearerToken(r); ok {
+			user, token, err := authenticateToken(r, value)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				authError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized: "+err.Error())
+				return
+			}
+			if !roleAllows(user.Role, perm) {
+				authError(w, r, http.StatusForbidden, codeForbidden, "Forbidden")
+				return
+			}
+			r = withUser(r, user)
+			next(w, r.WithContext(context.WithValue(r.Context(), tokenContextKey, token)))
+			return
+		}
+
+		// Проверяем cookie сессии
+		var user *database.User
+		s := sessionFromRequest(r)
+		if s != nil {
+			configMu.RLock()
+			if resolved := database.ResolveUser(Config, s.Username); resolved != nil {
+				user = copyUser(resolved)
+			}
+			configMu.RUnlock()
+		}
+
+		if user == nil {
 			if r.URL.Path == "/" {
 				http.Redirect(w, r, "/login", http.StatusSeeOther)
 				return
 			}
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			authError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+			return
+		}
+
+		// Изменяющие запросы должны содержать CSRF токен сессии
+		if !isSafeMethod(r.Method) && !checkCSRF(r, s) {
+			authError(w, r, http.StatusForbidden, "csrf_failed", "Invalid CSRF token")
+			return
+		}
+
+		if !roleAllows(user.Role, perm) {
+			authError(w, r, http.StatusForbidden, codeForbidden, "Forbidden")
 			return
 		}
-		next(w, r)
+
+		next(w, withUser(r, user))
 	}
 }
 
+// authError отвечает ошибкой авторизации (JSON для /api/v1, текстом для старого API)
+func authError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if strings.HasPrefix(r.URL.Path, apiV1Prefix) {
+		writeAPIError(w, &opError{Status: status, Code: code, Message: message})
+		return
+	}
+	http.Error(w, message, status)
+}
+
 // handleLogin обрабатывает страницу входа
 func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
@@ -52,27 +115,84 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == "POST" {
+		// Запрещаем вход с чужих сайтов (login CSRF)
+		if !sameOrigin(r) {
+			http.Error(w, "Invalid origin", http.StatusForbidden)
+			return
+		}
+
 		username := r.FormValue("username")
 		password := r.FormValue("password")
+		ip := clientIP(r)
+
+		// Защита от подбора пароля
+		if wait := limiter.blocked(ip, username); wait > 0 {
+			seconds := int(wait.Seconds()) + 1
+			log.Printf("wg_serf auth: blocked login for user=%q from ip=%s retry_after=%ds", username, ip, seconds)
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			http.Redirect(w, r, fmt.Sprintf("/login?error=3&retry=%d", seconds), http.StatusSeeOther)
+			return
+		}
+
+		configMu.RLock()
+		user, ok := database.AuthenticateUser(Config, username, password)
+		if ok {
+			user = copyUser(user)
+		}
+		configMu.RUnlock()
+
+		if ok {
+			// Второй фактор, если включен (проверяется по актуальным данным: шаг и коды одноразовые)
+			if user.TwoFactor != nil && user.TwoFactor.Enabled {
+				configMu.Lock()
+				verified := false
+				if live := database.ResolveUser(Config, username); live != nil && live.TwoFactor != nil {
+					verified = verifySecondFactor(live.TwoFactor, r.FormValue("otp"))
+				}
+				if verified {
+					// Сохраняем использованный шаг / код восстановления
+					database.SaveConfig(Config)
+				}
+				configMu.Unlock()
+
+				if !verified {
+					limiter.fail(ip, username)
+					log.Printf("wg_serf auth: failed 2fa for user=%q from ip=%s", username, ip)
+					http.Redirect(w, r, "/login?error=2", http.StatusSeeOther)
+					return
+				}
+			}
+			limiter.success(ip, username)
 
-		if username == Config.Username && password == Config.Password {
+			token, err := createSession(user.Username)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
 			http.SetCookie(w, &http.Cookie{
 				Name:     "auth",
-				Value:    "authenticated",
+				Value:    token,
 				Path:     "/",
 				MaxAge:   86400, // 24 часа
 				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
 			})
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
 		}
 
+		limiter.fail(ip, username)
+		log.Printf("wg_serf auth: failed login for user=%q from ip=%s", username, ip)
 		http.Redirect(w, r, "/login?error=1", http.StatusSeeOther)
 	}
 }
 
 // handleLogout обрабатывает выход
 func HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie("auth"); err == nil {
+		deleteSession(cookie.Value)
+	}
 	http.SetCookie(w, &http.Cookie{
 		Name:     "auth",
 		Value:    "",
@@ -95,19 +215,29 @@ func HandleIndex(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	tmpl.Execute(w, nil)
+
+	data := struct{ CSRFToken string }{}
+	if s := sessionFromRequest(r); s != nil {
+		data.CSRFToken = s.CSRFToken
+	}
+	tmpl.Execute(w, data)
 }
 
 // === ОБРАБОТЧИКИ СЕРВЕРОВ ===
 
 // HandleServers возвращает список серверов
 func HandleServers(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	if DB.Servers == nil {
-		json.NewEncoder(w).Encode([]database.Server{})
-	} else {
-		json.NewEncoder(w).Encode(DB.Servers)
+	user := currentUser(r)
+
+	servers := []database.Server{}
+	for _, server := range DB.Servers {
+		if canAccessServer(user, server.ID) {
+			servers = append(servers, server)
+		}
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newServerInfos(servers))
 }
 
 // HandleCreateServer создает новый WireGuard сервер
@@ -127,34 +257,20 @@ func HandleCreateServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Добавляем /24 если не указано
-	if !strings.Contains(address, "/") {
-		address = address + "/24"
-	}
-
 	port, err := strconv.Atoi(portStr)
 	if err != nil {
 		http.Error(w, "Invalid port", http.StatusBadRequest)
 		return
 	}
 
-	// Валидируем конфигурацию
-	if err := database.ValidateServerConfig(DB, address, port); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	server, err := wireguard.CreateServer(DB, name, address, port, dns)
-	if err != nil {
-		http.Error(w, "Failed to create server: "+err.Error(), http.StatusInternalServerError)
+	server, opErr := opCreateServer(name, address, port, dns)
+	if opErr != nil {
+		http.Error(w, opErr.Message, opErr.Status)
 		return
 	}
 
-	DB.Servers = append(DB.Servers, *server)
-	database.SaveDatabase(DB)
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(server)
+	json.NewEncoder(w).Encode(newServerInfo(server))
 }
 
 // HandleUpdateServer обновляет настройки сервера
@@ -166,35 +282,21 @@ func HandleUpdateServer(w http.ResponseWriter, r *http.Request) {
 
 	id := r.FormValue("id")
 	name := r.FormValue("name")
-	portStr := r.FormValue("port")
 	dns := r.FormValue("dns")
 
-	for i, server := range DB.Servers {
-		if server.ID == id {
-			if name != "" {
-				DB.Servers[i].Name = name
-			}
-			if portStr != "" {
-				port, err := strconv.Atoi(portStr)
-				if err == nil {
-					DB.Servers[i].ListenPort = port
-				}
-			}
-			if dns != "" {
-				DB.Servers[i].DNS = dns
-			}
-
-			// Обновляем конфиг файл
-			wireguard.UpdateServerConfig(&DB.Servers[i], DB)
+	update := serverUpdate{Name: &name, DNS: &dns}
+	if port, err := strconv.Atoi(r.FormValue("port")); err == nil {
+		update.ListenPort = &port
+	}
 
-			database.SaveDatabase(DB)
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(DB.Servers[i])
-			return
-		}
+	server, opErr := opUpdateServer(id, update)
+	if opErr != nil {
+		http.Error(w, opErr.Message, opErr.Status)
+		return
 	}
 
-	http.Error(w, "Server not found", http.StatusNotFound)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newServerInfo(server))
 }
 
 // HandleDeleteServer удаляет сервер
@@ -204,35 +306,12 @@ func HandleDeleteServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id := r.FormValue("id")
-
-	for i, server := range DB.Servers {
-		if server.ID == id {
-			// Удаляем сервер
-			if err := wireguard.DeleteServer(&server); err != nil {
-				http.Error(w, "Failed to delete server", http.StatusInternalServerError)
-				return
-			}
-
-			// Удаляем всех клиентов этого сервера
-			var newClients []database.Client
-			for _, client := range DB.Clients {
-				if client.ServerID != id {
-					newClients = append(newClients, client)
-				}
-			}
-			DB.Clients = newClients
-
-			// Удаляем сервер из базы
-			DB.Servers = append(DB.Servers[:i], DB.Servers[i+1:]...)
-			database.SaveDatabase(DB)
-
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+	if opErr := opDeleteServer(r.FormValue("id")); opErr != nil {
+		http.Error(w, opErr.Message, opErr.Status)
+		return
 	}
 
-	http.Error(w, "Server not found", http.StatusNotFound)
+	w.WriteHeader(http.StatusOK)
 }
 
 // HandleToggleServer включает/выключает сервер
@@ -242,23 +321,14 @@ func HandleToggleServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id := r.FormValue("id")
-
-	for i, server := range DB.Servers {
-		if server.ID == id {
-			if err := wireguard.ToggleServer(&DB.Servers[i]); err != nil {
-				http.Error(w, "Failed to toggle server: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-
-			database.SaveDatabase(DB)
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(DB.Servers[i])
-			return
-		}
+	server, opErr := opToggleServer(r.FormValue("id"))
+	if opErr != nil {
+		http.Error(w, opErr.Message, opErr.Status)
+		return
 	}
 
-	http.Error(w, "Server not found", http.StatusNotFound)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newServerInfo(server))
 }
 
 // === ОБРАБОТЧИКИ КЛИЕНТОВ ===
@@ -266,20 +336,25 @@ func HandleToggleServer(w http.ResponseWriter, r *http.Request) {
 // HandleClients возвращает список клиентов
 func HandleClients(w http.ResponseWriter, r *http.Request) {
 	serverID := r.URL.Query().Get("server_id")
+	user := currentUser(r)
+
+	if serverID != "" && !canAccessServer(user, serverID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	var clients []database.Client
 	for _, client := range DB.Clients {
+		if !canAccessServer(user, client.ServerID) {
+			continue
+		}
 		if serverID == "" || client.ServerID == serverID {
 			clients = append(clients, client)
 		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if clients == nil {
-		json.NewEncoder(w).Encode([]database.Client{})
-	} else {
-		json.NewEncoder(w).Encode(clients)
-	}
+	json.NewEncoder(w).Encode(newClientInfos(user, clients))
 }
 
 // HandleCreateClient создает новый конфиг клиента
@@ -289,26 +364,26 @@ func HandleCreateClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	serverID := r.FormValue("server_id")
-	name := r.FormValue("name")
-	comment := r.FormValue("comment")
-
-	if serverID == "" || name == "" {
-		http.Error(w, "Missing required fields", http.StatusBadRequest)
-		return
+	req := clientCreateRequest{
+		Name:    r.FormValue("name"),
+		Comment: r.FormValue("comment"),
+		Address: strings.TrimSpace(r.FormValue("address")),
 	}
-
-	client, err := wireguard.CreateClient(DB, serverID, name, comment)
+	expiresAt, err := parseExpiry(r.FormValue("expires_at"))
 	if err != nil {
-		http.Error(w, "Failed to create client: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	req.ExpiresAt = expiresAt
 
-	DB.Clients = append(DB.Clients, *client)
-	database.SaveDatabase(DB)
+	client, opErr := opCreateClient(currentUser(r), r.FormValue("server_id"), req)
+	if opErr != nil {
+		http.Error(w, opErr.Message, opErr.Status)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(client)
+	json.NewEncoder(w).Encode(newClientInfo(currentUser(r), client))
 }
 
 // HandleDeleteClient удаляет клиента
@@ -318,26 +393,12 @@ func HandleDeleteClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id := r.FormValue("id")
-
-	for i, client := range DB.Clients {
-		if client.ID == id {
-			// Удаляем клиента
-			if err := wireguard.DeleteClient(DB, &client); err != nil {
-				http.Error(w, "Failed to delete client", http.StatusInternalServerError)
-				return
-			}
-
-			// Удаляем из базы
-			DB.Clients = append(DB.Clients[:i], DB.Clients[i+1:]...)
-			database.SaveDatabase(DB)
-
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+	if opErr := opDeleteClient(currentUser(r), r.FormValue("id")); opErr != nil {
+		http.Error(w, opErr.Message, opErr.Status)
+		return
 	}
 
-	http.Error(w, "Client not found", http.StatusNotFound)
+	w.WriteHeader(http.StatusOK)
 }
 
 // HandleToggleClient включает/выключает клиента
@@ -347,23 +408,14 @@ func HandleToggleClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id := r.FormValue("id")
-
-	for i, client := range DB.Clients {
-		if client.ID == id {
-			if err := wireguard.ToggleClient(DB, &DB.Clients[i]); err != nil {
-				http.Error(w, "Failed to toggle client", http.StatusInternalServerError)
-				return
-			}
-
-			database.SaveDatabase(DB)
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(DB.Clients[i])
-			return
-		}
+	client, opErr := opToggleClient(currentUser(r), r.FormValue("id"))
+	if opErr != nil {
+		http.Error(w, opErr.Message, opErr.Status)
+		return
 	}
 
-	http.Error(w, "Client not found", http.StatusNotFound)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newClientInfo(currentUser(r), client))
 }
 
 // HandleUpdateClient обновляет имя и комментарий клиента
@@ -377,20 +429,14 @@ func HandleUpdateClient(w http.ResponseWriter, r *http.Request) {
 	name := r.FormValue("name")
 	comment := r.FormValue("comment")
 
-	for i, client := range DB.Clients {
-		if client.ID == id {
-			if name != "" {
-				DB.Clients[i].Name = name
-			}
-			DB.Clients[i].Comment = comment
-			database.SaveDatabase(DB)
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(DB.Clients[i])
-			return
-		}
+	client, opErr := opUpdateClient(currentUser(r), id, clientUpdate{Name: &name, Comment: &comment})
+	if opErr != nil {
+		http.Error(w, opErr.Message, opErr.Status)
+		return
 	}
 
-	http.Error(w, "Client not found", http.StatusNotFound)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newClientInfo(currentUser(r), client))
 }
 
 // HandleAddPortForward добавляет проброс порта
@@ -401,36 +447,42 @@ func HandleAddPortForward(w http.ResponseWriter, r *http.Request) {
 	}
 
 	clientID := r.FormValue("client_id")
-	portStr := r.FormValue("port")
-	protocol := r.FormValue("protocol")
-	description := r.FormValue("description")
+	pf := database.PortForward{
+		Protocol:      r.FormValue("protocol"),
+		AllInterfaces: r.FormValue("all_interfaces") == "true",
+		Description:   r.FormValue("description"),
+	}
 
-	port, err := strconv.Atoi(portStr)
-	if err != nil {
+	// Необязательные поля: конец диапазона, порт на клиенте, источники через запятую
+	var err error
+	if pf.Port, err = strconv.Atoi(r.FormValue("port")); err != nil {
 		http.Error(w, "Invalid port", http.StatusBadRequest)
 		return
 	}
-
-	if protocol != "tcp" && protocol != "udp" && protocol != "both" {
-		http.Error(w, "Protocol must be tcp, udp or both", http.StatusBadRequest)
-		return
+	if value := r.FormValue("port_end"); value != "" {
+		if pf.PortEnd, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "Invalid port_end", http.StatusBadRequest)
+			return
+		}
 	}
-
-	for i, client := range DB.Clients {
-		if client.ID == clientID {
-			if err := wireguard.AddPortForward(DB, &DB.Clients[i], port, protocol, description); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-
-			database.SaveDatabase(DB)
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(DB.Clients[i])
+	if value := r.FormValue("internal_port"); value != "" {
+		if pf.InternalPort, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "Invalid internal_port", http.StatusBadRequest)
 			return
 		}
 	}
+	if value := r.FormValue("source_cidrs"); value != "" {
+		pf.SourceCIDRs = strings.Split(value, ",")
+	}
 
-	http.Error(w, "Client not found", http.StatusNotFound)
+	client, opErr := opAddPortForward(currentUser(r), clientID, pf)
+	if opErr != nil {
+		http.Error(w, opErr.Message, opErr.Status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newClientInfo(currentUser(r), client))
 }
 
 // HandleRemovePortForward удаляет проброс порта
@@ -450,99 +502,74 @@ func HandleRemovePortForward(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for i, client := range DB.Clients {
-		if client.ID == clientID {
-			if err := wireguard.RemovePortForward(&DB.Clients[i], port, protocol); err != nil {
-				http.Error(w, err.Error(), http.StatusNotFound)
-				return
-			}
-
-			database.SaveDatabase(DB)
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(DB.Clients[i])
-			return
-		}
+	client, opErr := opRemovePortForward(currentUser(r), clientID, port, protocol)
+	if opErr != nil {
+		http.Error(w, opErr.Message, opErr.Status)
+		return
 	}
 
-	http.Error(w, "Client not found", http.StatusNotFound)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newClientInfo(currentUser(r), client))
 }
 
 // HandleDownloadConfig скачивает конфиг клиента
 func HandleDownloadConfig(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-
-	for _, client := range DB.Clients {
-		if client.ID == id {
-			// Находим сервер
-			var server *database.Server
-			for i := range DB.Servers {
-				if DB.Servers[i].ID == client.ServerID {
-					server = &DB.Servers[i]
-					break
-				}
-			}
-
-			if server == nil {
-				http.Error(w, "Server not found", http.StatusNotFound)
-				return
-			}
-
-			config := wireguard.GenerateClientConfig(client, server)
-
-			// Создаем безопасное имя файла (без пробелов и спецсимволов)
-			safeName := database.SanitizeFilename(client.Name)
-
-			w.Header().Set("Content-Type", "application/x-wireguard-profile")
-			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.conf", safeName))
-			w.Write([]byte(config))
-			return
-		}
+	client, config, opErr := clientConfig(currentUser(r), r.URL.Query().Get("id"))
+	if opErr != nil {
+		http.Error(w, opErr.Message, opErr.Status)
+		return
 	}
 
-	http.Error(w, "Client not found", http.StatusNotFound)
+	// Создаем безопасное имя файла (без пробелов и спецсимволов)
+	safeName := database.SanitizeFilename(client.Name)
+
+	w.Header().Set("Content-Type", "application/x-wireguard-profile")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.conf", safeName))
+	w.Write([]byte(config))
 }
 
 // HandleQRCode генерирует QR код для конфига
 func HandleQRCode(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-
-	for _, client := range DB.Clients {
-		if client.ID == id {
-			// Находим сервер
-			var server *database.Server
-			for i := range DB.Servers {
-				if DB.Servers[i].ID == client.ServerID {
-					server = &DB.Servers[i]
-					break
-				}
-			}
+	_, config, opErr := clientConfig(currentUser(r), r.URL.Query().Get("id"))
+	if opErr != nil {
+		http.Error(w, opErr.Message, opErr.Status)
+		return
+	}
 
-			if server == nil {
-				http.Error(w, "Server not found", http.StatusNotFound)
-				return
-			}
+	// Генерируем QR код
+	png, err := wireguard.GenerateQRCode(config)
+	if err != nil {
+		http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
+		return
+	}
 
-			config := wireguard.GenerateClientConfig(client, server)
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
 
-			// Генерируем QR код
-			png, err := wireguard.GenerateQRCode(config)
-			if err != nil {
-				http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
-				return
-			}
+// HandleStats возвращает статистику
+func HandleStats(w http.ResponseWriter, r *http.Request) {
+	wireguard.UpdateStats(DB)
+	user := currentUser(r)
 
-			w.Header().Set("Content-Type", "image/png")
-			w.Write(png)
-			return
+	clients := []database.Client{}
+	for _, client := range DB.Clients {
+		if canAccessServer(user, client.ServerID) {
+			clients = append(clients, client)
 		}
 	}
 
-	http.Error(w, "Client not found", http.StatusNotFound)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newClientInfos(user, clients))
 }
 
-// HandleStats возвращает статистику
-func HandleStats(w http.ResponseWriter, r *http.Request) {
-	wireguard.UpdateStats(DB)
+// HandleFirewallPreview показывает правила, которые панель поставит для текущей БД
+func HandleFirewallPreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(DB.Clients)
+	json.NewEncoder(w).Encode(wireguard.PreviewFirewall(DB))
 }
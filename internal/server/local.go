@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	"wg-panel/internal/database"
+)
+
+// Локальный доступ к API через unix сокет (команды CLI, health check)
+
+// SocketPath сокет для команд CLI (доступен только root)
+const SocketPath = "/opt/wg_serf/wg_serf.sock"
+
+const (
+	localContextKey    contextKey = "local"
+	peerCredContextKey contextKey = "peer_cred"
+)
+
+// startedAt время запуска для health check
+var startedAt = time.Now()
+
+// LocalHandler обработчик локальных запросов: права основного администратора
+func LocalHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), localContextKey, true)
+		http.DefaultServeMux.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// isLocalRequest проверяет что запрос пришел через unix сокет
+func isLocalRequest(r *http.Request) bool {
+	local, _ := r.Context().Value(localContextKey).(bool)
+	return local
+}
+
+// peerCredentials возвращает uid/pid процесса на другой стороне сокета
+func peerCredentials(conn net.Conn) (*syscall.Ucred, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, syscall.EINVAL
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cred, credErr
+}
+
+// peerAllowed пускает только root и пользователя, от которого работает сервис
+func peerAllowed(cred *syscall.Ucred) bool {
+	return cred != nil && (cred.Uid == 0 || int(cred.Uid) == os.Geteuid())
+}
+
+// ServeLocal слушает unix сокет для CLI
+func ServeLocal(path string) error {
+	// Сокет от прошлого запуска
+	os.Remove(path)
+
+	// Сокет создается сразу с правами 0600
+	oldMask := syscall.Umask(0177)
+	listener, err := net.Listen("unix", path)
+	syscall.Umask(oldMask)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	local := LocalHandler()
+	srv := &http.Server{
+		// Права файла - первая линия, uid собеседника проверяем для каждого соединения
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			cred, err := peerCredentials(conn)
+			if err != nil {
+				return ctx
+			}
+			return context.WithValue(ctx, peerCredContextKey, cred)
+		},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cred, _ := r.Context().Value(peerCredContextKey).(*syscall.Ucred)
+			if !peerAllowed(cred) {
+				if cred != nil {
+					log.Printf("wg_serf auth: rejected local socket request from uid=%d pid=%d", cred.Uid, cred.Pid)
+				} else {
+					log.Printf("wg_serf auth: rejected local socket request without peer credentials")
+				}
+				writeAPIError(w, errForbidden())
+				return
+			}
+			local.ServeHTTP(w, r)
+		}),
+	}
+
+	return srv.Serve(listener)
+}
+
+// healthInfo ответ health check
+type healthInfo struct {
+	Status        string `json:"status"`
+	Version       string `json:"version"`
+	UptimeSeconds int    `json:"uptime_seconds"`
+	TLS           bool   `json:"tls"`
+	Servers       int    `json:"servers"`
+	ServersActive int    `json:"servers_active"`
+	Clients       int    `json:"clients"`
+}
+
+// HandleHealth состояние сервиса (для мониторинга и wg_serf health)
+func HandleHealth(w http.ResponseWriter, r *http.Request) {
+	active := 0
+	for _, server := range DB.Servers {
+		if server.Enabled {
+			active++
+		}
+	}
+
+	writeJSON(w, 200, healthInfo{
+		Status:        "ok",
+		Version:       Version,
+		UptimeSeconds: int(time.Since(startedAt).Seconds()),
+		TLS:           database.PanelScheme(Config) == "https",
+		Servers:       len(DB.Servers),
+		ServersActive: active,
+		Clients:       len(DB.Clients),
+	})
+}
@@ -0,0 +1,461 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"wg-panel/internal/database"
+	"wg-panel/internal/dns"
+	"wg-panel/internal/wireguard"
+)
+
+// Спецификация OpenAPI 3 для всех маршрутов панели
+
+// Version версия панели для спецификации (задается из main)
+var Version = "dev"
+
+// apiParam параметр запроса (query, path или поле формы)
+type apiParam struct {
+	Name        string
+	Type        string // string, integer, boolean
+	Required    bool
+	Description string
+}
+
+// apiOperation описание одного метода API
+type apiOperation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Perm        int // Требуемое право (-1 - без авторизации)
+	Query       []apiParam
+	Form        []apiParam // Тело application/x-www-form-urlencoded
+	Body        string     // Тело JSON - имя схемы из components
+	TextBody    bool       // Тело - произвольный текст (text/plain)
+	Status      int        // Код успешного ответа (по умолчанию 200)
+	Result      string     // Схема ответа, "[]Имя" - массив
+	ContentType string     // Тип ответа если не JSON
+}
+
+const noAuth = -1
+
+// registeredRoutes шаблоны маршрутов из SetupRoutes
+var registeredRoutes []string
+
+// apiSchemas схемы ответов и тел запросов, выводятся из структур Go
+var apiSchemas = map[string]interface{}{
+	"Server":            serverInfo{},
+	"Client":            clientInfo{},
+	"PortForward":       database.PortForward{},
+	"ACLRule":           database.ACLRule{},
+	"EgressPolicy":      database.EgressPolicy{},
+	"EgressRule":        database.EgressRule{},
+	"User":              userInfo{},
+	"APIToken":          tokenInfo{},
+	"Error":             apiErrorBody{},
+	"ClientPage":        apiPage{},
+	"ServerCreate":      serverCreateRequest{},
+	"ServerUpdate":      serverUpdate{},
+	"ClientCreate":      clientCreateRequest{},
+	"ClientUpdate":      clientUpdate{},
+	"PortForwardCreate": portForwardRequest{},
+	"Health":            healthInfo{},
+	"ImportRow":         importRow{},
+	"ImportReport":      importReport{},
+	"ClientExport":      exportRow{},
+	"AdoptCandidate":    adoptCandidateInfo{},
+	"AdoptRequest":      adoptRequest{},
+	"ReconcileReport":   wireguard.ReconcileReport{},
+	"ReconcileChange":   wireguard.ReconcileChange{},
+	"DriftStatus":       wireguard.DriftStatus{},
+	"FirewallPreview":   wireguard.FirewallPreview{},
+	"DNSStatus":         dns.Status{},
+	"DNSList":           dns.ListStatus{},
+	"DNSUpdate":         dnsUpdate{},
+	"DNSClientStats":    dns.ClientStats{},
+	"DomainCount":       dns.DomainCount{},
+	"TwoFactorStatus": struct {
+		Enabled           bool `json:"enabled"`
+		Pending           bool `json:"pending"`
+		RecoveryCodesLeft int  `json:"recovery_codes_left"`
+	}{},
+	"TwoFactorSetup": struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}{},
+	"RecoveryCodes": struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{},
+}
+
+func query(name, description string) apiParam {
+	return apiParam{Name: name, Type: "string", Description: description}
+}
+
+func required(name, typ string) apiParam {
+	return apiParam{Name: name, Type: typ, Required: true}
+}
+
+func optional(name, typ string) apiParam {
+	return apiParam{Name: name, Type: typ}
+}
+
+// apiOperations все методы API (полноту по маршрутам проверяет openapi_test.go)
+var apiOperations = []apiOperation{
+	// Веб-интерфейс и авторизация
+	{Method: "GET", Path: "/", Summary: "Web panel", Perm: permView, ContentType: "text/html"},
+	{Method: "GET", Path: "/login", Summary: "Login page", Perm: noAuth, ContentType: "text/html"},
+	{Method: "POST", Path: "/login", Summary: "Log in and receive a session cookie", Perm: noAuth, Status: 303,
+		Form: []apiParam{required("username", "string"), required("password", "string"), {Name: "otp", Type: "string", Description: "TOTP or recovery code if 2FA is enabled"}}},
+	{Method: "GET", Path: "/logout", Summary: "Log out", Perm: noAuth, Status: 303},
+	{Method: "GET", Path: "/api/health", Summary: "Service health (also on the local unix socket)", Perm: permView, Result: "Health"},
+	{Method: "GET", Path: "/api/openapi.json", Summary: "This specification", Perm: noAuth, Result: "object"},
+
+	// Серверы
+	{Method: "GET", Path: "/api/servers", Summary: "List servers", Perm: permView, Result: "[]Server"},
+	{Method: "POST", Path: "/api/server/create", Summary: "Create server", Perm: permServers, Result: "Server",
+		Form: []apiParam{required("name", "string"), {Name: "address", Type: "string", Required: true, Description: "Subnet, /24 is added if omitted"}, required("port", "integer"), optional("dns", "string")}},
+	{Method: "POST", Path: "/api/server/update", Summary: "Update server", Perm: permServers, Result: "Server",
+		Form: []apiParam{required("id", "string"), optional("name", "string"), optional("port", "integer"), optional("dns", "string")}},
+	{Method: "POST", Path: "/api/server/delete", Summary: "Delete server and its clients", Perm: permServers,
+		Form: []apiParam{required("id", "string")}},
+	{Method: "POST", Path: "/api/server/toggle", Summary: "Enable or disable server", Perm: permServers, Result: "Server",
+		Form: []apiParam{required("id", "string")}},
+
+	// Клиенты
+	{Method: "GET", Path: "/api/clients", Summary: "List clients", Perm: permView, Result: "[]Client",
+		Query: []apiParam{query("server_id", "Only clients of this server")}},
+	{Method: "POST", Path: "/api/client/create", Summary: "Create client", Perm: permClients, Result: "Client",
+		Form: []apiParam{required("server_id", "string"), required("name", "string"), optional("comment", "string"), {Name: "address", Type: "string", Description: "Static IP, next free if empty"}, {Name: "expires_at", Type: "string", Description: "YYYY-MM-DD (inclusive) or RFC 3339"}}},
+	{Method: "POST", Path: "/api/client/delete", Summary: "Delete client", Perm: permClients,
+		Form: []apiParam{required("id", "string")}},
+	{Method: "POST", Path: "/api/client/toggle", Summary: "Enable or disable client", Perm: permClients, Result: "Client",
+		Form: []apiParam{required("id", "string")}},
+	{Method: "POST", Path: "/api/client/update", Summary: "Update client", Perm: permClients, Result: "Client",
+		Form: []apiParam{required("id", "string"), optional("name", "string"), optional("comment", "string")}},
+	{Method: "GET", Path: "/api/client/download", Summary: "Download client config", Perm: permClients, ContentType: "application/x-wireguard-profile",
+		Query: []apiParam{required("id", "string")}},
+	{Method: "GET", Path: "/api/client/qr", Summary: "Client config as QR code", Perm: permClients, ContentType: "image/png",
+		Query: []apiParam{required("id", "string")}},
+	{Method: "POST", Path: "/api/client/portforward/add", Summary: "Add port forward", Perm: permClients, Result: "Client",
+		Form: []apiParam{required("client_id", "string"), {Name: "port", Type: "integer", Required: true, Description: "External port or start of the range"},
+			{Name: "port_end", Type: "integer", Description: "End of the external port range"},
+			{Name: "internal_port", Type: "integer", Description: "Port on the client, defaults to the external port"},
+			{Name: "protocol", Type: "string", Required: true, Description: "tcp, udp or both"},
+			{Name: "source_cidrs", Type: "string", Description: "Comma-separated source addresses or CIDRs allowed to connect"},
+			{Name: "all_interfaces", Type: "boolean", Description: "Accept on every ingress interface, not only the external one"},
+			optional("description", "string")}},
+	{Method: "POST", Path: "/api/client/portforward/remove", Summary: "Remove port forward", Perm: permClients, Result: "Client",
+		Form: []apiParam{required("client_id", "string"), required("port", "integer"), required("protocol", "string")}},
+	{Method: "GET", Path: "/api/stats", Summary: "Refresh and return client statistics", Perm: permView, Result: "[]Client"},
+	{Method: "GET", Path: "/api/firewall/preview", Summary: "Generated firewall ruleset and its difference from the live rules", Perm: permServers, Result: "FirewallPreview"},
+
+	// Пользователи
+	{Method: "GET", Path: "/api/me", Summary: "Current user", Perm: permView, Result: "User"},
+	{Method: "GET", Path: "/api/users", Summary: "List users", Perm: permUsers, Result: "[]User"},
+	{Method: "POST", Path: "/api/user/create", Summary: "Create user", Perm: permUsers, Result: "User",
+		Form: []apiParam{required("username", "string"), required("password", "string"), {Name: "role", Type: "string", Description: "owner, operator or viewer"}, {Name: "servers", Type: "string", Description: "Comma-separated server IDs"}}},
+	{Method: "POST", Path: "/api/user/update", Summary: "Update user", Perm: permUsers, Result: "User",
+		Form: []apiParam{required("username", "string"), optional("role", "string"), optional("password", "string"), optional("servers", "string")}},
+	{Method: "POST", Path: "/api/user/delete", Summary: "Delete user", Perm: permUsers,
+		Form: []apiParam{required("username", "string")}},
+
+	// Двухфакторная аутентификация
+	{Method: "GET", Path: "/api/2fa", Summary: "2FA status of current user", Perm: permView, Result: "TwoFactorStatus"},
+	{Method: "POST", Path: "/api/2fa/setup", Summary: "Start 2FA setup", Perm: permView, Result: "TwoFactorSetup"},
+	{Method: "GET", Path: "/api/2fa/qr", Summary: "QR code for authenticator app", Perm: permView, ContentType: "image/png"},
+	{Method: "POST", Path: "/api/2fa/enable", Summary: "Confirm code and enable 2FA", Perm: permView, Result: "RecoveryCodes",
+		Form: []apiParam{required("code", "string")}},
+	{Method: "POST", Path: "/api/2fa/disable", Summary: "Disable 2FA", Perm: permView,
+		Form: []apiParam{optional("code", "string")}},
+
+	// API токены
+	{Method: "GET", Path: "/api/tokens", Summary: "List API tokens", Perm: permView, Result: "[]APIToken"},
+	{Method: "POST", Path: "/api/token/create", Summary: "Create API token (value is returned once)", Perm: permView, Result: "APIToken",
+		Form: []apiParam{required("name", "string"), optional("role", "string"), optional("servers", "string"), {Name: "allowed_ips", Type: "string", Description: "Comma-separated IPs or CIDRs"}, optional("expires_days", "integer")}},
+	{Method: "POST", Path: "/api/token/revoke", Summary: "Revoke API token", Perm: permView,
+		Form: []apiParam{required("id", "string")}},
+
+	// REST API v1
+	{Method: "GET", Path: "/api/v1/servers", Summary: "List servers", Perm: permView, Result: "[]Server"},
+	{Method: "POST", Path: "/api/v1/servers", Summary: "Create server", Perm: permServers, Body: "ServerCreate", Status: 201, Result: "Server"},
+	{Method: "GET", Path: "/api/v1/servers/adopt", Summary: "Preview WireGuard interfaces and configs not managed by the panel", Perm: permServers, Result: "[]AdoptCandidate"},
+	{Method: "POST", Path: "/api/v1/servers/adopt", Summary: "Adopt existing interfaces keeping keys, addresses and ports; nothing is changed if any of them has errors", Perm: permServers, Body: "AdoptRequest", Status: 201, Result: "[]AdoptCandidate"},
+	{Method: "GET", Path: "/api/v1/servers/{id}", Summary: "Get server", Perm: permView, Result: "Server"},
+	{Method: "PATCH", Path: "/api/v1/servers/{id}", Summary: "Update server", Perm: permServers, Body: "ServerUpdate", Result: "Server"},
+	{Method: "DELETE", Path: "/api/v1/servers/{id}", Summary: "Delete server and its clients", Perm: permServers, Status: 204},
+	{Method: "GET", Path: "/api/v1/servers/{id}/clients", Summary: "List clients of server", Perm: permView, Result: "ClientPage", Query: clientListQuery},
+	{Method: "POST", Path: "/api/v1/servers/{id}/clients", Summary: "Create client", Perm: permClients, Body: "ClientCreate", Status: 201, Result: "Client"},
+	{Method: "GET", Path: "/api/v1/servers/{id}/clients/{client_id}", Summary: "Get client", Perm: permView, Result: "Client"},
+	{Method: "PATCH", Path: "/api/v1/servers/{id}/clients/{client_id}", Summary: "Update client", Perm: permClients, Body: "ClientUpdate", Result: "Client"},
+	{Method: "DELETE", Path: "/api/v1/servers/{id}/clients/{client_id}", Summary: "Delete client", Perm: permClients, Status: 204},
+	{Method: "GET", Path: "/api/v1/clients", Summary: "List clients", Perm: permView, Result: "ClientPage",
+		Query: append([]apiParam{query("server_id", "Only clients of this server")}, clientListQuery...)},
+	{Method: "POST", Path: "/api/v1/clients/import", Summary: "Bulk import clients from CSV (header: name,comment,server,address,expires_at) or a JSON array of ImportRow; nothing is created if any row fails", Perm: permClients, Body: "[]ImportRow", Result: "ImportReport",
+		Query: []apiParam{{Name: "dry_run", Type: "boolean", Description: "Only validate and report per-row errors"}, query("server_id", "Server for rows without a server column")}},
+	{Method: "GET", Path: "/api/v1/clients/export", Summary: "Export clients with metadata (no private keys)", Perm: permView, Result: "[]ClientExport",
+		Query: []apiParam{query("format", "json (default) or csv"), query("server_id", "Only clients of this server")}},
+	{Method: "GET", Path: "/api/v1/servers/{id}/bundle", Summary: "ZIP with .conf files and QR PNGs of all server clients", Perm: permClients, ContentType: "application/zip"},
+	{Method: "GET", Path: "/api/v1/clients/{client_id}", Summary: "Get client", Perm: permView, Result: "Client"},
+	{Method: "PATCH", Path: "/api/v1/clients/{client_id}", Summary: "Update client", Perm: permClients, Body: "ClientUpdate", Result: "Client"},
+	{Method: "DELETE", Path: "/api/v1/clients/{client_id}", Summary: "Delete client", Perm: permClients, Status: 204},
+	{Method: "GET", Path: "/api/v1/clients/{client_id}/config", Summary: "Client config", Perm: permClients, ContentType: "application/x-wireguard-profile"},
+	{Method: "GET", Path: "/api/v1/clients/{client_id}/qr", Summary: "Client config as QR code", Perm: permClients, ContentType: "image/png"},
+	{Method: "GET", Path: "/api/v1/clients/{client_id}/port-forwards", Summary: "List port forwards", Perm: permView, Result: "[]PortForward"},
+	{Method: "POST", Path: "/api/v1/clients/{client_id}/port-forwards", Summary: "Add port forward", Perm: permClients, Body: "PortForwardCreate", Status: 201, Result: "Client"},
+	{Method: "DELETE", Path: "/api/v1/clients/{client_id}/port-forwards/{port}/{protocol}", Summary: "Remove port forward", Perm: permClients, Result: "Client"},
+	{Method: "GET", Path: "/api/v1/reconcile", Summary: "Dry run: differences between the database and interfaces, peers and iptables rules", Perm: permServers, Result: "ReconcileReport"},
+	{Method: "POST", Path: "/api/v1/reconcile", Summary: "Apply only the differences between the database and the system", Perm: permServers, Result: "ReconcileReport"},
+	{Method: "GET", Path: "/api/v1/drift", Summary: "Result of the last background drift check", Perm: permServers, Result: "DriftStatus"},
+	{Method: "POST", Path: "/api/v1/drift", Summary: "Run a drift check now (repairs if auto_repair is enabled)", Perm: permServers, Result: "DriftStatus"},
+	{Method: "GET", Path: "/api/v1/dns", Summary: "Built-in DNS settings and blocklist status", Perm: permServers, Result: "DNSStatus"},
+	{Method: "PATCH", Path: "/api/v1/dns", Summary: "Update blocklist sources, allow overrides and blocked domains", Perm: permServers, Body: "DNSUpdate", Result: "DNSStatus"},
+	{Method: "POST", Path: "/api/v1/dns/refresh", Summary: "Download URL blocklists again and rebuild the filter", Perm: permServers, Result: "DNSStatus"},
+	{Method: "PUT", Path: "/api/v1/dns/lists/{name}", Summary: "Upload a blocklist (hosts, adblock or one domain per line)", Perm: permServers, TextBody: true, Result: "DNSList"},
+	{Method: "DELETE", Path: "/api/v1/dns/lists/{name}", Summary: "Delete an uploaded blocklist", Perm: permServers, Status: 204},
+	{Method: "GET", Path: "/api/v1/dns/stats", Summary: "DNS queries and blocked domains per client since start", Perm: permView, Result: "[]DNSClientStats",
+		Query: []apiParam{query("server_id", "Only clients of this server"), {Name: "top", Type: "integer", Description: "Blocked domains per client (default 10)"}}},
+	{Method: "GET", Path: "/api/v1/stats", Summary: "Refresh statistics and list clients", Perm: permView, Result: "ClientPage", Query: clientListQuery},
+	{Method: "GET", Path: "/api/v1/me", Summary: "Current user", Perm: permView, Result: "User"},
+}
+
+// clientListQuery фильтры и пагинация списков клиентов v1
+var clientListQuery = []apiParam{
+	{Name: "enabled", Type: "boolean", Description: "Filter by enabled state"},
+	{Name: "online", Type: "boolean", Description: "Filter by recent handshake"},
+	query("q", "Substring of name, comment or address"),
+	{Name: "page", Type: "integer", Description: "Page number, starting at 1"},
+	{Name: "per_page", Type: "integer", Description: "Page size, 1-500 (default 50)"},
+}
+
+// permNames названия прав для x-required-permission
+var permNames = map[int]string{
+	permView:    "view",
+	permClients: "clients",
+	permServers: "servers",
+	permUsers:   "users",
+}
+
+// HandleOpenAPI отдает спецификацию OpenAPI
+func HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openAPISpec())
+}
+
+// openAPISpec строит документ OpenAPI 3
+func openAPISpec() map[string]interface{} {
+	schemas := map[string]interface{}{}
+	for name, v := range apiSchemas {
+		schemas[name] = objectSchema(reflect.TypeOf(v))
+	}
+
+	paths := map[string]map[string]interface{}{}
+	for _, op := range apiOperations {
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]interface{}{}
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = operationSpec(op)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "WG_SERF API",
+			"version":     Version,
+			"description": "WireGuard panel API. Legacy /api/* routes take form fields, /api/v1 is JSON.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"cookieAuth": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": "auth"},
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// operationSpec описывает одну операцию
+func operationSpec(op apiOperation) map[string]interface{} {
+	spec := map[string]interface{}{"summary": op.Summary}
+
+	var params []interface{}
+	for _, name := range pathParams(op.Path) {
+		params = append(params, map[string]interface{}{
+			"name": name, "in": "path", "required": true,
+			"schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, p := range op.Query {
+		params = append(params, paramSpec(p, "query"))
+	}
+	if params != nil {
+		spec["parameters"] = params
+	}
+
+	if len(op.Form) > 0 {
+		properties := map[string]interface{}{}
+		var requiredFields []string
+		for _, p := range op.Form {
+			properties[p.Name] = typeSpec(p)
+			if p.Required {
+				requiredFields = append(requiredFields, p.Name)
+			}
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if requiredFields != nil {
+			schema["required"] = requiredFields
+		}
+		spec["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"application/x-www-form-urlencoded": map[string]interface{}{"schema": schema}},
+		}
+	}
+	if op.TextBody {
+		spec["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}},
+		}
+	}
+	if op.Body != "" {
+		spec["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": schemaRef(op.Body)}},
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = 200
+	}
+	response := map[string]interface{}{"description": http.StatusText(status)}
+	switch {
+	case op.ContentType != "":
+		response["content"] = map[string]interface{}{op.ContentType: map[string]interface{}{}}
+	case op.Result != "":
+		response["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": schemaRef(op.Result)}}
+	}
+
+	responses := map[string]interface{}{strconv.Itoa(status): response}
+	if op.Perm != noAuth {
+		errorContent := map[string]interface{}{"description": "Error"}
+		if strings.HasPrefix(op.Path, apiV1Prefix) {
+			errorContent["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": schemaRef("Error")}}
+		}
+		responses["default"] = errorContent
+		spec["security"] = []interface{}{
+			map[string]interface{}{"cookieAuth": []string{}},
+			map[string]interface{}{"bearerAuth": []string{}},
+		}
+		spec["x-required-permission"] = permNames[op.Perm]
+	}
+	spec["responses"] = responses
+
+	return spec
+}
+
+// pathParams возвращает имена параметров {name} из пути
+func pathParams(path string) []string {
+	var names []string
+	for _, part := range strings.Split(path, "/") {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			names = append(names, part[1:len(part)-1])
+		}
+	}
+	return names
+}
+
+func paramSpec(p apiParam, in string) map[string]interface{} {
+	spec := map[string]interface{}{"name": p.Name, "in": in, "schema": typeSpec(p)}
+	if p.Required {
+		spec["required"] = true
+	}
+	if p.Description != "" {
+		spec["description"] = p.Description
+	}
+	return spec
+}
+
+func typeSpec(p apiParam) map[string]interface{} {
+	spec := map[string]interface{}{"type": p.Type}
+	if p.Description != "" {
+		spec["description"] = p.Description
+	}
+	return spec
+}
+
+// schemaRef ссылка на схему, "[]Имя" - массив, "object" - произвольный объект
+func schemaRef(name string) map[string]interface{} {
+	if name == "object" {
+		return map[string]interface{}{"type": "object"}
+	}
+	if strings.HasPrefix(name, "[]") {
+		return map[string]interface{}{"type": "array", "items": schemaRef(name[2:])}
+	}
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor строит JSON схему по типу Go (имена полей из json тегов)
+func schemaFor(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		schema := schemaFor(t.Elem())
+		schema["nullable"] = true
+		return schema
+	}
+
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	if name := schemaName(t); name != "" {
+		return schemaRef(name)
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Struct:
+		return objectSchema(t)
+	}
+	return map[string]interface{}{}
+}
+
+// objectSchema раскрывает поля структуры (вложенные известные типы - ссылками)
+func objectSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		properties[name] = schemaFor(field.Type)
+	}
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// schemaName возвращает имя схемы из components для именованного типа
+func schemaName(t reflect.Type) string {
+	if t.Name() == "" {
+		return ""
+	}
+	for name, v := range apiSchemas {
+		if reflect.TypeOf(v) == t {
+			return name
+		}
+	}
+	return ""
+}
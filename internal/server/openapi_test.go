@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// specHas описана ли операция в спецификации
+func specHas(method, path string) bool {
+	for _, op := range apiOperations {
+		if op.Method == method && op.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Каждый маршрут SetupRoutes, кроме диспетчера v1, описан в спецификации
+func TestRoutesDescribedInSpec(t *testing.T) {
+	SetupRoutes()
+
+	for _, route := range registeredRoutes {
+		if route == apiV1Prefix {
+			continue // Пути диспетчера проверяются по таблице v1Routes
+		}
+		found := false
+		for _, op := range apiOperations {
+			if op.Path == route {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("route %s is not described in openapi.go", route)
+		}
+	}
+}
+
+// Каждый путь и метод диспетчера v1 описан в спецификации, и наоборот
+func TestV1RoutesMatchSpec(t *testing.T) {
+	served := make(map[string]bool)
+	for _, route := range v1Routes {
+		for _, method := range route.methods {
+			path := apiV1Prefix + route.path
+			served[method+" "+path] = true
+			if !specHas(method, path) {
+				t.Errorf("%s %s is served but not described in openapi.go", method, path)
+			}
+		}
+	}
+
+	for _, op := range apiOperations {
+		if strings.HasPrefix(op.Path, apiV1Prefix) && !served[op.Method+" "+op.Path] {
+			t.Errorf("%s %s is described in openapi.go but not served", op.Method, op.Path)
+		}
+	}
+}
+
+// Ни один маршрут таблицы не перекрыт маршрутом выше него
+func TestV1RoutesReachable(t *testing.T) {
+	for i, route := range v1Routes {
+		var parts []string
+		for _, segment := range strings.Split(route.path, "/") {
+			if strings.HasPrefix(segment, "{") {
+				segment = "value"
+			}
+			parts = append(parts, segment)
+		}
+
+		for j, other := range v1Routes {
+			if _, ok := other.match(parts); ok {
+				if j != i {
+					t.Errorf("%s is shadowed by %s", route.path, other.path)
+				}
+				break
+			}
+		}
+	}
+}
+
+func TestV1Dispatch(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		status int
+		allow  string
+	}{
+		{"PUT", "/api/v1/servers", http.StatusMethodNotAllowed, "GET, POST"},
+		{"POST", "/api/v1/servers/abc", http.StatusMethodNotAllowed, "GET, PATCH, DELETE"},
+		{"GET", "/api/v1/clients/import", http.StatusMethodNotAllowed, "POST"},
+		{"GET", "/api/v1/clients/abc/port-forwards/80/tcp", http.StatusMethodNotAllowed, "DELETE"},
+		{"GET", "/api/v1/unknown", http.StatusNotFound, ""},
+		{"GET", "/api/v1/clients/abc/unknown", http.StatusNotFound, ""},
+		{"GET", "/api/v1/", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			HandleAPIv1(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if allow := w.Header().Get("Allow"); allow != tt.allow {
+				t.Errorf("Allow = %q, want %q", allow, tt.allow)
+			}
+		})
+	}
+}
@@ -0,0 +1,481 @@
+package server
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"wg-panel/internal/database"
+	"wg-panel/internal/wireguard"
+)
+
+// Операции над серверами и клиентами, общие для старого API и /api/v1
+
+// Коды ошибок API
+const (
+	codeInvalidRequest   = "invalid_request"
+	codeUnauthorized     = "unauthorized"
+	codeForbidden        = "forbidden"
+	codeNotFound         = "not_found"
+	codeConflict         = "conflict"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeInternal         = "internal_error"
+)
+
+// opError ошибка операции с HTTP статусом и кодом
+type opError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *opError) Error() string {
+	return e.Message
+}
+
+func errBadRequest(message string) *opError {
+	return &opError{Status: 400, Code: codeInvalidRequest, Message: message}
+}
+
+func errForbidden() *opError {
+	return &opError{Status: 403, Code: codeForbidden, Message: "Forbidden"}
+}
+
+func errNotFound(message string) *opError {
+	return &opError{Status: 404, Code: codeNotFound, Message: message}
+}
+
+func errInternal(message string) *opError {
+	return &opError{Status: 500, Code: codeInternal, Message: message}
+}
+
+// findServer ищет сервер по ID
+func findServer(id string) *database.Server {
+	for i := range DB.Servers {
+		if DB.Servers[i].ID == id {
+			return &DB.Servers[i]
+		}
+	}
+	return nil
+}
+
+// findClient ищет клиента по ID
+func findClient(id string) *database.Client {
+	for i := range DB.Clients {
+		if DB.Clients[i].ID == id {
+			return &DB.Clients[i]
+		}
+	}
+	return nil
+}
+
+// accessibleClient ищет клиента и проверяет доступ пользователя к его серверу
+func accessibleClient(user *database.User, id string) (*database.Client, *opError) {
+	client := findClient(id)
+	if client == nil {
+		return nil, errNotFound("Client not found")
+	}
+	if !canAccessServer(user, client.ServerID) {
+		return nil, errForbidden()
+	}
+	return client, nil
+}
+
+// opCreateServer создает сервер
+func opCreateServer(name, address string, port int, dns string) (*database.Server, *opError) {
+	if name == "" || address == "" || port == 0 {
+		return nil, errBadRequest("Missing required fields")
+	}
+
+	// Добавляем /24 если не указано
+	if !strings.Contains(address, "/") {
+		address = address + "/24"
+	}
+
+	// Валидируем конфигурацию
+	if err := database.ValidateServerConfig(DB, address, port); err != nil {
+		return nil, errBadRequest(err.Error())
+	}
+
+	server, err := wireguard.CreateServer(DB, name, address, port, dns)
+	if err != nil {
+		return nil, errInternal("Failed to create server: " + err.Error())
+	}
+
+	DB.Servers = append(DB.Servers, *server)
+	wireguard.ApplyFirewall(DB)
+	database.SaveDatabase(DB)
+	return &DB.Servers[len(DB.Servers)-1], nil
+}
+
+// opAdoptInterfaces переносит существующие интерфейсы WireGuard в панель
+func opAdoptInterfaces(ifaces []string) ([]wireguard.AdoptCandidate, *opError) {
+	if len(ifaces) == 0 {
+		return nil, errBadRequest("No interfaces selected")
+	}
+
+	candidates, err := wireguard.AdoptInterfaces(DB, ifaces)
+	if err != nil {
+		return nil, &opError{Status: 409, Code: codeConflict, Message: err.Error()}
+	}
+	wireguard.ApplyFirewall(DB)
+	return candidates, nil
+}
+
+// serverUpdate изменяемые поля сервера (nil - не менять)
+type serverUpdate struct {
+	Name       *string             `json:"name"`
+	ListenPort *int                `json:"listen_port"`
+	DNS        *string             `json:"dns"`
+	Enabled    *bool               `json:"enabled"`
+	Isolation  *bool               `json:"isolation"`
+	ACLs       *[]database.ACLRule `json:"acls"`
+	DNSFilter  *bool               `json:"dns_filter"`
+}
+
+// opUpdateServer обновляет настройки сервера
+func opUpdateServer(id string, update serverUpdate) (*database.Server, *opError) {
+	server := findServer(id)
+	if server == nil {
+		return nil, errNotFound("Server not found")
+	}
+
+	if update.ACLs != nil {
+		for i := range *update.ACLs {
+			if err := wireguard.ValidateACL(&(*update.ACLs)[i]); err != nil {
+				return nil, errBadRequest(err.Error())
+			}
+		}
+	}
+
+	if update.ListenPort != nil && *update.ListenPort != server.ListenPort {
+		if *update.ListenPort < 1 || *update.ListenPort > 65535 {
+			return nil, errBadRequest("listen_port must be between 1 and 65535")
+		}
+		if err := database.CheckServerPort(DB, server.ID, *update.ListenPort); err != nil {
+			return nil, &opError{Status: 409, Code: codeConflict, Message: err.Error()}
+		}
+	}
+
+	if update.Name != nil && *update.Name != "" {
+		server.Name = *update.Name
+	}
+	if update.Isolation != nil {
+		server.Isolation = *update.Isolation
+	}
+	if update.ACLs != nil {
+		server.ACLs = *update.ACLs
+	}
+	if update.DNSFilter != nil {
+		server.DNSFilter = *update.DNSFilter
+	}
+	if update.ListenPort != nil {
+		server.ListenPort = *update.ListenPort
+	}
+	if update.DNS != nil && *update.DNS != "" {
+		server.DNS = *update.DNS
+	}
+
+	// Обновляем конфиг файл
+	wireguard.UpdateServerConfig(server, DB)
+
+	if update.Enabled != nil && *update.Enabled != server.Enabled {
+		if err := wireguard.ToggleServer(server); err != nil {
+			return nil, errInternal("Failed to toggle server: " + err.Error())
+		}
+	}
+
+	// Порт, включение сервера и изоляция меняют правила
+	wireguard.ApplyFirewall(DB)
+	database.SaveDatabase(DB)
+	return server, nil
+}
+
+// opToggleServer включает/выключает сервер
+func opToggleServer(id string) (*database.Server, *opError) {
+	server := findServer(id)
+	if server == nil {
+		return nil, errNotFound("Server not found")
+	}
+
+	if err := wireguard.ToggleServer(server); err != nil {
+		return nil, errInternal("Failed to toggle server: " + err.Error())
+	}
+
+	wireguard.ApplyFirewall(DB)
+	database.SaveDatabase(DB)
+	return server, nil
+}
+
+// opDeleteServer удаляет сервер и всех его клиентов
+func opDeleteServer(id string) *opError {
+	for i, server := range DB.Servers {
+		if server.ID == id {
+			// Удаляем сервер
+			if err := wireguard.DeleteServer(&server); err != nil {
+				return errInternal("Failed to delete server")
+			}
+
+			// Удаляем всех клиентов этого сервера
+			var newClients []database.Client
+			for _, client := range DB.Clients {
+				if client.ServerID != id {
+					newClients = append(newClients, client)
+				}
+			}
+			DB.Clients = newClients
+
+			// Удаляем сервер из базы
+			DB.Servers = append(DB.Servers[:i], DB.Servers[i+1:]...)
+			database.UnregisterInterface(DB, server.Interface)
+			wireguard.ApplyFirewall(DB)
+			database.SaveDatabase(DB)
+			return nil
+		}
+	}
+
+	return errNotFound("Server not found")
+}
+
+// validateNewClient проверяет параметры нового клиента
+func validateNewClient(user *database.User, serverID string, req clientCreateRequest) *opError {
+	if serverID == "" || req.Name == "" {
+		return errBadRequest("Missing required fields")
+	}
+
+	if !canAccessServer(user, serverID) {
+		return errForbidden()
+	}
+
+	server := findServer(serverID)
+	if server == nil {
+		return errNotFound("Server not found")
+	}
+
+	if req.Address != "" {
+		if err := database.ValidateClientIP(DB, server, req.Address); err != nil {
+			return &opError{Status: 409, Code: codeConflict, Message: err.Error()}
+		}
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return errBadRequest("Expiry must be in the future")
+	}
+
+	return nil
+}
+
+// opCreateClient создает клиента на сервере
+func opCreateClient(user *database.User, serverID string, req clientCreateRequest) (*database.Client, *opError) {
+	if opErr := validateNewClient(user, serverID, req); opErr != nil {
+		return nil, opErr
+	}
+
+	client, err := wireguard.CreateClient(DB, serverID, req.Name, req.Comment, req.Address)
+	if err != nil {
+		return nil, errInternal("Failed to create client: " + err.Error())
+	}
+	client.ExpiresAt = req.ExpiresAt
+
+	DB.Clients = append(DB.Clients, *client)
+	database.SaveDatabase(DB)
+	return &DB.Clients[len(DB.Clients)-1], nil
+}
+
+// clientUpdate изменяемые поля клиента (nil - не менять)
+type clientUpdate struct {
+	Name    *string                `json:"name"`
+	Comment *string                `json:"comment"`
+	Enabled *bool                  `json:"enabled"`
+	Groups  *[]string              `json:"groups"`
+	Egress  *database.EgressPolicy `json:"egress"` // Заменяет политику целиком, {} - снять ограничения
+}
+
+// opUpdateClient обновляет клиента
+func opUpdateClient(user *database.User, id string, update clientUpdate) (*database.Client, *opError) {
+	client, opErr := accessibleClient(user, id)
+	if opErr != nil {
+		return nil, opErr
+	}
+
+	var groups []string
+	if update.Groups != nil {
+		var err error
+		if groups, err = wireguard.ValidateGroups(*update.Groups); err != nil {
+			return nil, errBadRequest(err.Error())
+		}
+	}
+	var egress *database.EgressPolicy
+	if update.Egress != nil {
+		var err error
+		if egress, err = wireguard.ValidateEgress(update.Egress); err != nil {
+			return nil, errBadRequest(err.Error())
+		}
+	}
+
+	if update.Name != nil && *update.Name != "" {
+		client.Name = *update.Name
+	}
+	if update.Comment != nil {
+		client.Comment = *update.Comment
+	}
+
+	// Пробросы портов работают только у активных клиентов, группы определяют ACL,
+	// политика - цепочку клиента
+	rulesChanged := false
+	if update.Groups != nil {
+		client.Groups = groups
+		rulesChanged = true
+	}
+	if update.Egress != nil {
+		client.Egress = egress
+		rulesChanged = true
+	}
+	if update.Enabled != nil && *update.Enabled != client.Enabled {
+		if err := wireguard.ToggleClient(DB, client); err != nil {
+			return nil, errInternal("Failed to toggle client")
+		}
+		rulesChanged = true
+	}
+	if rulesChanged {
+		wireguard.ApplyFirewall(DB)
+	}
+
+	database.SaveDatabase(DB)
+	return client, nil
+}
+
+// opToggleClient включает/выключает клиента
+func opToggleClient(user *database.User, id string) (*database.Client, *opError) {
+	client, opErr := accessibleClient(user, id)
+	if opErr != nil {
+		return nil, opErr
+	}
+
+	if err := wireguard.ToggleClient(DB, client); err != nil {
+		return nil, errInternal("Failed to toggle client")
+	}
+
+	wireguard.ApplyFirewall(DB)
+	database.SaveDatabase(DB)
+	return client, nil
+}
+
+// opDeleteClient удаляет клиента
+func opDeleteClient(user *database.User, id string) *opError {
+	for i, client := range DB.Clients {
+		if client.ID == id {
+			if !canAccessServer(user, client.ServerID) {
+				return errForbidden()
+			}
+
+			// Удаляем клиента
+			if err := wireguard.DeleteClient(DB, &client); err != nil {
+				return errInternal("Failed to delete client")
+			}
+
+			// Удаляем из базы
+			DB.Clients = append(DB.Clients[:i], DB.Clients[i+1:]...)
+			wireguard.ApplyFirewall(DB)
+			database.SaveDatabase(DB)
+			return nil
+		}
+	}
+
+	return errNotFound("Client not found")
+}
+
+// opAddPortForward добавляет проброс порта клиенту
+func opAddPortForward(user *database.User, clientID string, pf database.PortForward) (*database.Client, *opError) {
+	if err := wireguard.ValidatePortForward(&pf); err != nil {
+		return nil, errBadRequest(err.Error())
+	}
+
+	client, opErr := accessibleClient(user, clientID)
+	if opErr != nil {
+		return nil, opErr
+	}
+
+	if err := wireguard.AddPortForward(DB, client, pf, reservedForwardPorts()); err != nil {
+		return nil, &opError{Status: 409, Code: codeConflict, Message: err.Error()}
+	}
+
+	// Правила не встали - проброс не сохраняем
+	if err := wireguard.ApplyFirewall(DB); err != nil {
+		wireguard.RemovePortForward(client, pf.Port, pf.Protocol)
+		return nil, errInternal("Failed to apply firewall rules: " + err.Error())
+	}
+
+	database.SaveDatabase(DB)
+	return client, nil
+}
+
+// reservedForwardPorts порты самой панели и список запрета из config.json
+func reservedForwardPorts() []wireguard.ReservedPort {
+	var reserved []wireguard.ReservedPort
+	if Config == nil {
+		return reserved
+	}
+
+	if port, err := strconv.Atoi(Config.Port); err == nil {
+		reserved = append(reserved, wireguard.ReservedPort{Start: port, End: port, Protocol: "tcp", Reason: "веб-панель"})
+	}
+
+	// HTTP-01 проверяется на обычном HTTP порту
+	if Config.TLS.Mode == database.TLSModeACME && Config.TLS.ACMEChallenge != "tls-alpn-01" {
+		httpPort := Config.TLS.HTTPPort
+		if httpPort == "" {
+			httpPort = "80"
+		}
+		if port, err := strconv.Atoi(httpPort); err == nil {
+			reserved = append(reserved, wireguard.ReservedPort{Start: port, End: port, Protocol: "tcp", Reason: "ACME HTTP-01"})
+		}
+	}
+
+	for _, spec := range Config.ForwardDenylist {
+		entry, err := wireguard.ParseReservedPort(spec, "forward_denylist")
+		if err != nil {
+			log.Printf("⚠️  forward_denylist: %v", err)
+			continue
+		}
+		reserved = append(reserved, entry)
+	}
+
+	return reserved
+}
+
+// opRemovePortForward удаляет проброс порта клиента
+func opRemovePortForward(user *database.User, clientID string, port int, protocol string) (*database.Client, *opError) {
+	client, opErr := accessibleClient(user, clientID)
+	if opErr != nil {
+		return nil, opErr
+	}
+
+	if err := wireguard.RemovePortForward(client, port, protocol); err != nil {
+		return nil, errNotFound(err.Error())
+	}
+
+	wireguard.ApplyFirewall(DB)
+	database.SaveDatabase(DB)
+	return client, nil
+}
+
+// clientConfig возвращает конфиг клиента и его сервер
+func clientConfig(user *database.User, id string) (*database.Client, string, *opError) {
+	client, opErr := accessibleClient(user, id)
+	if opErr != nil {
+		return nil, "", opErr
+	}
+
+	server := findServer(client.ServerID)
+	if server == nil {
+		return nil, "", errNotFound("Server not found")
+	}
+
+	if client.PrivateKey == "" {
+		return nil, "", &opError{Status: 409, Code: codeConflict, Message: "Client private key is unknown (adopted from an existing config)"}
+	}
+
+	return client, wireguard.GenerateClientConfig(*client, server), nil
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"testing"
+
+	"wg-panel/internal/database"
+)
+
+// Новый порт сервера проверяется до изменения: конфликт - 409, сервер не меняется
+func TestUpdateServerPortConflict(t *testing.T) {
+	DB = &database.Database{
+		Servers: []database.Server{
+			{ID: "s1", Name: "office", Interface: "wg0", ListenPort: 51820},
+			{ID: "s2", Name: "lab", Interface: "wg1", ListenPort: 51821},
+		},
+		Clients: []database.Client{{ID: "c1", ServerID: "s2", Name: "nas", PortForwards: []database.PortForward{{Port: 5000, Protocol: "udp"}}}},
+	}
+
+	tests := []struct {
+		port   int
+		status int
+	}{
+		{51821, 409},
+		{5000, 409},
+		{0, 400},
+		{70000, 400},
+	}
+	for _, tt := range tests {
+		name := "renamed"
+		_, opErr := opUpdateServer("s1", serverUpdate{Name: &name, ListenPort: &tt.port})
+		if opErr == nil || opErr.Status != tt.status {
+			t.Errorf("port %d: err = %+v, want status %d", tt.port, opErr, tt.status)
+		}
+		if server := DB.Servers[0]; server.ListenPort != 51820 || server.Name != "office" {
+			t.Errorf("port %d: server changed to %+v", tt.port, server)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	loginFreeAttempts = 3                // Попыток без задержки
+	loginBaseDelay    = time.Second      // Начальная задержка после превышения
+	loginMaxLockout   = 15 * time.Minute // Максимальная блокировка
+	loginForgetAfter  = time.Hour        // Через сколько забывать неудачные попытки
+)
+
+// loginAttempts состояние неудачных попыток входа для одного ключа (IP или логин)
+type loginAttempts struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// loginLimiter ограничивает подбор пароля по IP и по логину
+type loginLimiter struct {
+	mu       sync.Mutex
+	attempts map[string]*loginAttempts
+}
+
+var limiter = &loginLimiter{attempts: make(map[string]*loginAttempts)}
+
+// blocked возвращает оставшееся время блокировки для IP или логина
+func (l *loginLimiter) blocked(ip, username string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range []string{"ip:" + ip, "user:" + username} {
+		a, ok := l.attempts[key]
+		if !ok {
+			continue
+		}
+		if d := a.LockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// fail регистрирует неудачную попытку и продлевает блокировку по экспоненте
+func (l *loginLimiter) fail(ip, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.cleanup(now)
+
+	for _, key := range []string{"ip:" + ip, "user:" + username} {
+		a, ok := l.attempts[key]
+		if !ok {
+			a = &loginAttempts{}
+			l.attempts[key] = a
+		}
+		a.Failures++
+		a.LastFailure = now
+
+		if a.Failures > loginFreeAttempts {
+			delay := loginMaxLockout
+			if shift := a.Failures - loginFreeAttempts - 1; shift < 20 {
+				delay = loginBaseDelay << uint(shift)
+			}
+			if delay > loginMaxLockout {
+				delay = loginMaxLockout
+			}
+			a.LockedUntil = now.Add(delay)
+			log.Printf("wg_serf auth: lockout key=%s failures=%d duration=%s", key, a.Failures, delay)
+		}
+	}
+}
+
+// success сбрасывает счетчики после успешного входа
+func (l *loginLimiter) success(ip, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, "ip:"+ip)
+	delete(l.attempts, "user:"+username)
+}
+
+// cleanup удаляет давно неактивные записи
+func (l *loginLimiter) cleanup(now time.Time) {
+	for key, a := range l.attempts {
+		if now.Sub(a.LastFailure) > loginForgetAfter && now.After(a.LockedUntil) {
+			delete(l.attempts, key)
+		}
+	}
+}
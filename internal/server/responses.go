@@ -0,0 +1,149 @@
+package server
+
+import (
+	"time"
+
+	"wg-panel/internal/database"
+	"wg-panel/internal/wireguard"
+)
+
+// Ответы API с серверами и клиентами: приватный ключ сервера не отдается никогда,
+// ключи клиента - только тем, кому доступны конфиги (право clients)
+
+// serverInfo данные сервера для API (без приватного ключа)
+type serverInfo struct {
+	ID           string             `json:"id"`
+	Name         string             `json:"name"`
+	Interface    string             `json:"interface"`
+	PublicKey    string             `json:"public_key"`
+	Address      string             `json:"address"`
+	ListenPort   int                `json:"listen_port"`
+	DNS          string             `json:"dns"`
+	Enabled      bool               `json:"enabled"`
+	CreatedAt    time.Time          `json:"created_at"`
+	PostUp       string             `json:"post_up"`
+	PostDown     string             `json:"post_down"`
+	NextClientIP int                `json:"next_client_ip"`
+	Isolation    bool               `json:"isolation"`
+	ACLs         []database.ACLRule `json:"acls,omitempty"`
+	DNSFilter    bool               `json:"dns_filter"`
+}
+
+// newServerInfo формирует данные сервера для ответа
+func newServerInfo(server *database.Server) serverInfo {
+	return serverInfo{
+		ID:           server.ID,
+		Name:         server.Name,
+		Interface:    server.Interface,
+		PublicKey:    server.PublicKey,
+		Address:      server.Address,
+		ListenPort:   server.ListenPort,
+		DNS:          server.DNS,
+		Enabled:      server.Enabled,
+		CreatedAt:    server.CreatedAt,
+		PostUp:       server.PostUp,
+		PostDown:     server.PostDown,
+		NextClientIP: server.NextClientIP,
+		Isolation:    server.Isolation,
+		ACLs:         server.ACLs,
+		DNSFilter:    server.DNSFilter,
+	}
+}
+
+// newServerInfos формирует список серверов для ответа
+func newServerInfos(servers []database.Server) []serverInfo {
+	infos := make([]serverInfo, 0, len(servers))
+	for i := range servers {
+		infos = append(infos, newServerInfo(&servers[i]))
+	}
+	return infos
+}
+
+// clientInfo данные клиента для API (ключи - только с правом clients)
+type clientInfo struct {
+	ID            string                 `json:"id"`
+	ServerID      string                 `json:"server_id"`
+	Name          string                 `json:"name"`
+	PublicKey     string                 `json:"public_key"`
+	PrivateKey    string                 `json:"private_key,omitempty"`
+	PresharedKey  string                 `json:"preshared_key,omitempty"`
+	HasConfig     bool                   `json:"has_config"` // Панель знает приватный ключ и может выдать конфиг
+	Address       string                 `json:"address"`
+	Enabled       bool                   `json:"enabled"`
+	Comment       string                 `json:"comment"`
+	CreatedAt     time.Time              `json:"created_at"`
+	RxBytes       int64                  `json:"rx_bytes"`
+	TxBytes       int64                  `json:"tx_bytes"`
+	LastHandshake time.Time              `json:"last_handshake"`
+	Endpoint      string                 `json:"endpoint"`
+	PortForwards  []database.PortForward `json:"port_forwards"`
+	ExpiresAt     *time.Time             `json:"expires_at,omitempty"`
+	Groups        []string               `json:"groups,omitempty"`
+	Egress        *database.EgressPolicy `json:"egress,omitempty"`
+}
+
+// newClientInfo формирует данные клиента для ответа пользователю
+func newClientInfo(user *database.User, client *database.Client) clientInfo {
+	info := clientInfo{
+		ID:            client.ID,
+		ServerID:      client.ServerID,
+		Name:          client.Name,
+		PublicKey:     client.PublicKey,
+		HasConfig:     client.PrivateKey != "",
+		Address:       client.Address,
+		Enabled:       client.Enabled,
+		Comment:       client.Comment,
+		CreatedAt:     client.CreatedAt,
+		RxBytes:       client.RxBytes,
+		TxBytes:       client.TxBytes,
+		LastHandshake: client.LastHandshake,
+		Endpoint:      client.Endpoint,
+		PortForwards:  client.PortForwards,
+		ExpiresAt:     client.ExpiresAt,
+		Groups:        client.Groups,
+		Egress:        client.Egress,
+	}
+	if roleAllows(user.Role, permClients) {
+		info.PrivateKey = client.PrivateKey
+		info.PresharedKey = client.PresharedKey
+	}
+	return info
+}
+
+// newClientInfos формирует список клиентов для ответа пользователю
+func newClientInfos(user *database.User, clients []database.Client) []clientInfo {
+	infos := make([]clientInfo, 0, len(clients))
+	for i := range clients {
+		infos = append(infos, newClientInfo(user, &clients[i]))
+	}
+	return infos
+}
+
+// adoptCandidateInfo интерфейс для переноса в панель (ключи как у серверов и клиентов)
+type adoptCandidateInfo struct {
+	Interface  string       `json:"interface"`
+	ConfigPath string       `json:"config_path,omitempty"`
+	Running    bool         `json:"running"`
+	Server     serverInfo   `json:"server"`
+	Clients    []clientInfo `json:"clients"`
+	Warnings   []string     `json:"warnings"`
+	Errors     []string     `json:"errors"`
+}
+
+// newAdoptCandidateInfos формирует список кандидатов на перенос для ответа
+func newAdoptCandidateInfos(user *database.User, candidates []wireguard.AdoptCandidate) []adoptCandidateInfo {
+	infos := make([]adoptCandidateInfo, 0, len(candidates))
+	for i := range candidates {
+		c := &candidates[i]
+		infos = append(infos, adoptCandidateInfo{
+			Interface:  c.Interface,
+			ConfigPath: c.ConfigPath,
+			Running:    c.Running,
+			Server:     newServerInfo(&c.Server),
+			Clients:    newClientInfos(user, c.Clients),
+			Warnings:   c.Warnings,
+			Errors:     c.Errors,
+		})
+	}
+	return infos
+}
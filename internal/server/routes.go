@@ -2,33 +2,106 @@ package server
 
 import (
 	"net/http"
+	"strings"
+
+	"wg-panel/internal/database"
 )
 
+// handle регистрирует маршрут и запоминает его для проверки спецификации
+func handle(pattern string, handler http.HandlerFunc) {
+	registeredRoutes = append(registeredRoutes, pattern)
+	http.HandleFunc(pattern, withDBLock(handler))
+}
+
+// withDBLock держит блокировку БД на время запроса: чтение - общую, изменения -
+// монопольную, чтобы фоновые циклы не меняли БД посреди обработки
+func withDBLock(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Фильтр DNS не хранится в БД, а скачивание списков может идти долго;
+		// вход не читает БД, а проверка пароля (PBKDF2) не должна задерживать остальных
+		if strings.HasPrefix(r.URL.Path, apiV1Prefix+"dns") || withoutDB[r.URL.Path] {
+			handler(w, r)
+			return
+		}
+
+		if readsDBOnly(r) {
+			database.Mu.RLock()
+			defer database.Mu.RUnlock()
+		} else {
+			database.Mu.Lock()
+			defer database.Mu.Unlock()
+		}
+		handler(w, r)
+	}
+}
+
+// withoutDB маршруты, которые не обращаются к БД
+var withoutDB = map[string]bool{"/login": true, "/logout": true}
+
+// readsDBOnly запрос только читает БД (статистика при чтении обновляет трафик клиентов)
+func readsDBOnly(r *http.Request) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	return r.URL.Path != "/api/stats" && r.URL.Path != apiV1Prefix+"stats"
+}
+
 // SetupRoutes настраивает маршруты HTTP сервера
 func SetupRoutes() {
 	// Главная страница
-	http.HandleFunc("/", authMiddleware(HandleIndex))
+	handle("/", authMiddleware(permView, HandleIndex))
 
 	// API для серверов
-	http.HandleFunc("/api/servers", authMiddleware(HandleServers))
-	http.HandleFunc("/api/server/create", authMiddleware(HandleCreateServer))
-	http.HandleFunc("/api/server/update", authMiddleware(HandleUpdateServer))
-	http.HandleFunc("/api/server/delete", authMiddleware(HandleDeleteServer))
-	http.HandleFunc("/api/server/toggle", authMiddleware(HandleToggleServer))
+	handle("/api/servers", authMiddleware(permView, HandleServers))
+	handle("/api/server/create", authMiddleware(permServers, HandleCreateServer))
+	handle("/api/server/update", authMiddleware(permServers, HandleUpdateServer))
+	handle("/api/server/delete", authMiddleware(permServers, HandleDeleteServer))
+	handle("/api/server/toggle", authMiddleware(permServers, HandleToggleServer))
 
 	// API для клиентов
-	http.HandleFunc("/api/clients", authMiddleware(HandleClients))
-	http.HandleFunc("/api/client/create", authMiddleware(HandleCreateClient))
-	http.HandleFunc("/api/client/delete", authMiddleware(HandleDeleteClient))
-	http.HandleFunc("/api/client/toggle", authMiddleware(HandleToggleClient))
-	http.HandleFunc("/api/client/update", authMiddleware(HandleUpdateClient))
-	http.HandleFunc("/api/client/download", authMiddleware(HandleDownloadConfig))
-	http.HandleFunc("/api/client/qr", authMiddleware(HandleQRCode))
-	http.HandleFunc("/api/client/portforward/add", authMiddleware(HandleAddPortForward))
-	http.HandleFunc("/api/client/portforward/remove", authMiddleware(HandleRemovePortForward))
-	http.HandleFunc("/api/stats", authMiddleware(HandleStats))
+	handle("/api/clients", authMiddleware(permView, HandleClients))
+	handle("/api/client/create", authMiddleware(permClients, HandleCreateClient))
+	handle("/api/client/delete", authMiddleware(permClients, HandleDeleteClient))
+	handle("/api/client/toggle", authMiddleware(permClients, HandleToggleClient))
+	handle("/api/client/update", authMiddleware(permClients, HandleUpdateClient))
+	handle("/api/client/download", authMiddleware(permClients, HandleDownloadConfig))
+	handle("/api/client/qr", authMiddleware(permClients, HandleQRCode))
+	handle("/api/client/portforward/add", authMiddleware(permClients, HandleAddPortForward))
+	handle("/api/client/portforward/remove", authMiddleware(permClients, HandleRemovePortForward))
+	handle("/api/stats", authMiddleware(permView, HandleStats))
+
+	// Правила firewall панели
+	handle("/api/firewall/preview", authMiddleware(permServers, HandleFirewallPreview))
+
+	// API для пользователей
+	handle("/api/me", authMiddleware(permView, HandleMe))
+	handle("/api/users", authMiddleware(permUsers, HandleUsers))
+	handle("/api/user/create", authMiddleware(permUsers, HandleCreateUser))
+	handle("/api/user/update", authMiddleware(permUsers, HandleUpdateUser))
+	handle("/api/user/delete", authMiddleware(permUsers, HandleDeleteUser))
+
+	// API для двухфакторной аутентификации
+	handle("/api/2fa", authMiddleware(permView, HandleTwoFactorStatus))
+	handle("/api/2fa/setup", authMiddleware(permView, HandleTwoFactorSetup))
+	handle("/api/2fa/qr", authMiddleware(permView, HandleTwoFactorQR))
+	handle("/api/2fa/enable", authMiddleware(permView, HandleTwoFactorEnable))
+	handle("/api/2fa/disable", authMiddleware(permView, HandleTwoFactorDisable))
+
+	// API токены
+	handle("/api/tokens", authMiddleware(permView, HandleTokens))
+	handle("/api/token/create", authMiddleware(permView, HandleCreateToken))
+	handle("/api/token/revoke", authMiddleware(permView, HandleRevokeToken))
+
+	// REST API v1
+	handle(apiV1Prefix, authMiddleware(permView, HandleAPIv1))
 
 	// Авторизация
-	http.HandleFunc("/login", HandleLogin)
-	http.HandleFunc("/logout", HandleLogout)
+	handle("/login", HandleLogin)
+	handle("/logout", HandleLogout)
+
+	// Состояние сервиса
+	handle("/api/health", authMiddleware(permView, HandleHealth))
+
+	// Спецификация API
+	handle("/api/openapi.json", HandleOpenAPI)
 }
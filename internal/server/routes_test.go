@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"wg-panel/internal/database"
+)
+
+// Вход и выход не ждут блокировку БД, остальные запросы ждут
+func TestWithDBLock(t *testing.T) {
+	tests := []struct {
+		method, path string
+		waits        bool
+	}{
+		{"POST", "/login", false},
+		{"GET", "/login", false},
+		{"POST", "/logout", false},
+		{"PATCH", "/api/v1/dns", false},
+		{"GET", "/api/servers", true},
+		{"POST", "/api/server/create", true},
+		{"POST", "/login/extra", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			database.Mu.Lock()
+			locked := true
+			defer func() {
+				if locked {
+					database.Mu.Unlock()
+				}
+			}()
+
+			done := make(chan struct{})
+			handler := withDBLock(func(w http.ResponseWriter, r *http.Request) { close(done) })
+			go handler(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+
+			select {
+			case <-done:
+				if tt.waits {
+					t.Error("handler ran while the DB was locked")
+				}
+			case <-time.After(50 * time.Millisecond):
+				if !tt.waits {
+					t.Fatal("handler waits for the DB lock")
+				}
+				database.Mu.Unlock()
+				locked = false
+				<-done
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"wg-panel/internal/database"
+)
+
+const (
+	apiTokenPrefix    = "wgs_"
+	tokenUsageRefresh = time.Minute // Как часто сохранять время последнего использования
+)
+
+const tokenContextKey contextKey = "api_token"
+
+// tokenInfo данные токена для API (без хеша)
+type tokenInfo struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Username   string     `json:"username"`
+	Role       string     `json:"role"`
+	Servers    []string   `json:"servers"`
+	Prefix     string     `json:"prefix"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Token      string     `json:"token,omitempty"` // Только при создании
+}
+
+// newTokenInfo формирует данные токена для ответа
+func newTokenInfo(t *database.APIToken) tokenInfo {
+	info := tokenInfo{
+		ID:         t.ID,
+		Name:       t.Name,
+		Username:   t.Username,
+		Role:       t.Role,
+		Servers:    t.Servers,
+		Prefix:     t.Prefix,
+		AllowedIPs: t.AllowedIPs,
+		CreatedAt:  t.CreatedAt,
+		ExpiresAt:  t.ExpiresAt,
+		LastUsedAt: t.LastUsedAt,
+	}
+	if info.Servers == nil {
+		info.Servers = []string{}
+	}
+	if info.AllowedIPs == nil {
+		info.AllowedIPs = []string{}
+	}
+	return info
+}
+
+// roleRank возвращает уровень роли для сравнения
+func roleRank(role string) int {
+	switch role {
+	case database.RoleOwner:
+		return 2
+	case database.RoleOperator:
+		return 1
+	}
+	return 0
+}
+
+// bearerToken извлекает токен из заголовка Authorization
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[7:]), true
+}
+
+// authenticateToken проверяет API токен и возвращает пользователя с правами токена
+func authenticateToken(r *http.Request, value string) (*database.User, *database.APIToken, error) {
+	// Под полной блокировкой: обновляется время последнего использования
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	token := database.FindAPIToken(Config, value)
+	if token == nil {
+		return nil, nil, fmt.Errorf("invalid token")
+	}
+
+	now := time.Now()
+	if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+		return nil, nil, fmt.Errorf("token expired")
+	}
+
+	if len(token.AllowedIPs) > 0 && !ipAllowed(clientIP(r), token.AllowedIPs) {
+		return nil, nil, fmt.Errorf("IP not allowed")
+	}
+
+	owner := database.ResolveUser(Config, token.Username)
+	if owner == nil {
+		return nil, nil, fmt.Errorf("token owner not found")
+	}
+
+	// Права токена не выше прав владельца
+	user := &database.User{
+		Username: owner.Username,
+		Role:     token.Role,
+		Servers:  owner.Servers,
+	}
+	if roleRank(owner.Role) < roleRank(token.Role) {
+		user.Role = owner.Role
+	}
+	if len(token.Servers) > 0 {
+		// Владельца могли ограничить после выпуска токена - берем пересечение
+		user.Servers = tokenServers(owner, token.Servers)
+		if len(user.Servers) == 0 {
+			return nil, nil, fmt.Errorf("token servers are outside the owner's scope")
+		}
+		if user.Role == database.RoleOwner {
+			// Ограниченный по серверам токен не может управлять всем
+			user.Role = database.RoleOperator
+		}
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > tokenUsageRefresh {
+		token.LastUsedAt = &now
+		database.SaveConfig(Config)
+	}
+
+	copied := *token
+	return user, &copied, nil
+}
+
+// tokenServers оставляет из серверов токена только доступные владельцу
+func tokenServers(owner *database.User, servers []string) []string {
+	var allowed []string
+	for _, id := range servers {
+		if canAccessServer(owner, id) {
+			allowed = append(allowed, id)
+		}
+	}
+	return allowed
+}
+
+// ipAllowed проверяет IP по списку адресов и подсетей
+func ipAllowed(ip string, allowed []string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, entry := range allowed {
+		if strings.Contains(entry, "/") {
+			if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(parsed) {
+				return true
+			}
+		} else if other := net.ParseIP(entry); other != nil && other.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTokenRequest проверяет что запрос авторизован API токеном
+func isTokenRequest(r *http.Request) bool {
+	_, ok := r.Context().Value(tokenContextKey).(*database.APIToken)
+	return ok
+}
+
+// HandleTokens возвращает токены пользователя (владелец панели видит все)
+func HandleTokens(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	tokens := []tokenInfo{}
+	for i := range Config.APITokens {
+		if user.Role == database.RoleOwner || Config.APITokens[i].Username == user.Username {
+			tokens = append(tokens, newTokenInfo(&Config.APITokens[i]))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// HandleCreateToken создает API токен (значение показывается один раз)
+func HandleCreateToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Токеном нельзя выпустить новый токен
+	if isTokenRequest(r) {
+		http.Error(w, "Tokens can only be created from a panel session", http.StatusForbidden)
+		return
+	}
+
+	user := currentUser(r)
+	name := strings.TrimSpace(r.FormValue("name"))
+	role := r.FormValue("role")
+	if name == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+	if role == "" {
+		role = database.RoleViewer
+	}
+	if !database.IsValidRole(role) {
+		http.Error(w, "Role must be owner, operator or viewer", http.StatusBadRequest)
+		return
+	}
+	if roleRank(role) > roleRank(user.Role) {
+		http.Error(w, "Token role cannot exceed your role", http.StatusForbidden)
+		return
+	}
+
+	servers, err := parseServerIDs(r.FormValue("servers"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(tokenServers(user, servers)) != len(servers) {
+		http.Error(w, "Token servers cannot exceed your servers", http.StatusForbidden)
+		return
+	}
+
+	var allowedIPs []string
+	for _, entry := range strings.Split(r.FormValue("allowed_ips"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			http.Error(w, "Invalid IP or CIDR: "+entry, http.StatusBadRequest)
+			return
+		}
+		allowedIPs = append(allowedIPs, entry)
+	}
+
+	var expiresAt *time.Time
+	if daysStr := r.FormValue("expires_days"); daysStr != "" {
+		days, err := strconv.Atoi(daysStr)
+		if err != nil || days <= 0 {
+			http.Error(w, "Invalid expires_days", http.StatusBadRequest)
+			return
+		}
+		t := time.Now().AddDate(0, 0, days)
+		expiresAt = &t
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	value := apiTokenPrefix + secret
+
+	token := database.APIToken{
+		ID:         fmt.Sprintf("%d", time.Now().UnixNano()),
+		Name:       name,
+		Username:   user.Username,
+		Role:       role,
+		Servers:    servers,
+		TokenHash:  database.HashAPIToken(value),
+		Prefix:     value[:len(apiTokenPrefix)+6],
+		AllowedIPs: allowedIPs,
+		CreatedAt:  time.Now(),
+		ExpiresAt:  expiresAt,
+	}
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	Config.APITokens = append(Config.APITokens, token)
+	if err := database.SaveConfig(Config); err != nil {
+		http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	info := newTokenInfo(&token)
+	info.Token = value
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// HandleRevokeToken отзывает API токен
+func HandleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := currentUser(r)
+	id := r.FormValue("id")
+
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	for i, token := range Config.APITokens {
+		if token.ID == id {
+			if user.Role != database.RoleOwner && token.Username != user.Username {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			Config.APITokens = append(Config.APITokens[:i], Config.APITokens[i+1:]...)
+			if err := database.SaveConfig(Config); err != nil {
+				http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	http.Error(w, "Token not found", http.StatusNotFound)
+}
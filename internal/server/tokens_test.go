@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wg-panel/internal/database"
+)
+
+const operatorToken = "wgs_operator_test_token"
+
+// setupTokenTest конфиг с оператором на s1,s2 и его токеном на те же серверы
+func setupTokenTest(t *testing.T) {
+	t.Helper()
+	database.ConfigFile = filepath.Join(t.TempDir(), "config.json")
+	Config = &database.Config{
+		Username: "admin",
+		Password: "admin",
+		Users: []database.User{
+			{Username: "op", Role: database.RoleOperator, Servers: []string{"s1", "s2"}},
+		},
+		APITokens: []database.APIToken{
+			{ID: "1", Name: "ci", Username: "op", Role: database.RoleOperator, Servers: []string{"s1", "s2"}, TokenHash: database.HashAPIToken(operatorToken)},
+		},
+	}
+	DB = &database.Database{Servers: []database.Server{{ID: "s1"}, {ID: "s2"}, {ID: "s3"}}}
+}
+
+// Сужение прав оператора сужает и его токены
+func TestTokenScopeFollowsOwner(t *testing.T) {
+	setupTokenTest(t)
+	r := httptest.NewRequest("GET", "/api/v1/servers", nil)
+
+	user, _, err := authenticateToken(r, operatorToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(user.Servers, ",") != "s1,s2" {
+		t.Errorf("servers = %v, want [s1 s2]", user.Servers)
+	}
+
+	Config.Users[0].Servers = []string{"s2", "s3"}
+	user, _, err = authenticateToken(r, operatorToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(user.Servers, ",") != "s2" {
+		t.Errorf("servers after owner scope shrank = %v, want [s2]", user.Servers)
+	}
+
+	Config.Users[0].Servers = []string{"s3"}
+	if _, _, err := authenticateToken(r, operatorToken); err == nil {
+		t.Error("token outside the owner's scope is accepted")
+	}
+
+	// Без ограничения у владельца - серверы токена как есть
+	Config.Users[0].Servers = nil
+	user, _, err = authenticateToken(r, operatorToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(user.Servers, ",") != "s1,s2" {
+		t.Errorf("servers of unscoped owner = %v, want [s1 s2]", user.Servers)
+	}
+
+	// Токен без серверов получает серверы владельца
+	Config.Users[0].Servers = []string{"s3"}
+	Config.APITokens[0].Servers = nil
+	user, _, err = authenticateToken(r, operatorToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(user.Servers, ",") != "s3" {
+		t.Errorf("servers of unscoped token = %v, want [s3]", user.Servers)
+	}
+}
+
+func TestCreateTokenScope(t *testing.T) {
+	setupTokenTest(t)
+	operator := &database.User{Username: "op", Role: database.RoleOperator, Servers: []string{"s1", "s2"}}
+
+	tests := []struct {
+		servers string
+		status  int
+	}{
+		{"s1", http.StatusOK},
+		{"s1,s2", http.StatusOK},
+		{"", http.StatusOK},
+		{"s1,s3", http.StatusForbidden},
+		{"s3", http.StatusForbidden},
+		{"missing", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		form := url.Values{"name": {"ci"}, "role": {database.RoleOperator}, "servers": {tt.servers}}
+		r := httptest.NewRequest("POST", "/api/token/create", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		HandleCreateToken(w, withUser(r, operator))
+		if w.Code != tt.status {
+			t.Errorf("servers %q: status %d, want %d (%s)", tt.servers, w.Code, tt.status, w.Body.String())
+		}
+	}
+}
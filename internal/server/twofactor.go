@@ -0,0 +1,184 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"wg-panel/internal/database"
+	"wg-panel/internal/wireguard"
+)
+
+const totpIssuer = "WG_SERF"
+
+// verifySecondFactor проверяет TOTP код или код восстановления
+func verifySecondFactor(tf *database.TwoFactor, code string) bool {
+	if code == "" {
+		return false
+	}
+	if database.VerifyTOTP(tf, code, time.Now()) {
+		return true
+	}
+	return database.UseRecoveryCode(tf, code)
+}
+
+// HandleTwoFactorStatus возвращает состояние 2FA текущего пользователя
+func HandleTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	tf := currentUser(r).TwoFactor
+
+	status := map[string]interface{}{
+		"enabled":             tf != nil && tf.Enabled,
+		"pending":             tf != nil && !tf.Enabled,
+		"recovery_codes_left": 0,
+	}
+	if tf != nil {
+		status["recovery_codes_left"] = len(tf.RecoveryCodes)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// HandleTwoFactorSetup выдает новый секрет TOTP (до подтверждения 2FA не включена)
+func HandleTwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if isTokenRequest(r) {
+		http.Error(w, "2FA can only be managed from a panel session", http.StatusForbidden)
+		return
+	}
+
+	user := currentUser(r)
+	if user.TwoFactor != nil && user.TwoFactor.Enabled {
+		http.Error(w, "2FA already enabled", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := database.GenerateTOTPSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	database.SetTwoFactor(Config, user.Username, &database.TwoFactor{Secret: secret})
+	if err := database.SaveConfig(Config); err != nil {
+		http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret": secret,
+		"uri":    database.TOTPURI(totpIssuer, user.Username, secret),
+	})
+}
+
+// HandleTwoFactorQR генерирует QR код для приложения-аутентификатора
+func HandleTwoFactorQR(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user.TwoFactor == nil || user.TwoFactor.Enabled {
+		http.Error(w, "2FA setup not started", http.StatusBadRequest)
+		return
+	}
+
+	png, err := wireguard.GenerateQRCode(database.TOTPURI(totpIssuer, user.Username, user.TwoFactor.Secret))
+	if err != nil {
+		http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(png)
+}
+
+// HandleTwoFactorEnable подтверждает секрет кодом и включает 2FA
+func HandleTwoFactorEnable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if isTokenRequest(r) {
+		http.Error(w, "2FA can only be managed from a panel session", http.StatusForbidden)
+		return
+	}
+
+	codes, hashes, err := database.GenerateRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	// Проверяется актуальный секрет, а не копия из запроса
+	user := database.ResolveUser(Config, currentUser(r).Username)
+	if user == nil || user.TwoFactor == nil || user.TwoFactor.Enabled {
+		http.Error(w, "2FA setup not started", http.StatusBadRequest)
+		return
+	}
+
+	tf := user.TwoFactor
+	if !database.VerifyTOTP(tf, r.FormValue("code"), time.Now()) {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	database.SetTwoFactor(Config, user.Username, &database.TwoFactor{
+		Secret:        tf.Secret,
+		Enabled:       true,
+		LastStep:      tf.LastStep,
+		RecoveryCodes: hashes,
+	})
+	if err := database.SaveConfig(Config); err != nil {
+		http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Коды восстановления показываются только один раз
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// HandleTwoFactorDisable отключает 2FA (требуется код или код восстановления)
+func HandleTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if isTokenRequest(r) {
+		http.Error(w, "2FA can only be managed from a panel session", http.StatusForbidden)
+		return
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	user := database.ResolveUser(Config, currentUser(r).Username)
+	if user == nil || user.TwoFactor == nil {
+		http.Error(w, "2FA not enabled", http.StatusBadRequest)
+		return
+	}
+
+	if user.TwoFactor.Enabled && !verifySecondFactor(user.TwoFactor, r.FormValue("code")) {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	database.SetTwoFactor(Config, user.Username, nil)
+	if err := database.SaveConfig(Config); err != nil {
+		http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
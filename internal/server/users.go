@@ -0,0 +1,240 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"wg-panel/internal/database"
+)
+
+// userInfo данные пользователя для API (без хеша пароля)
+type userInfo struct {
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	Servers   []string  `json:"servers"`
+	CreatedAt time.Time `json:"created_at"`
+	Primary   bool      `json:"primary"` // Основной администратор из config.json
+}
+
+// newUserInfo формирует данные пользователя для ответа
+func newUserInfo(user *database.User) userInfo {
+	servers := user.Servers
+	if servers == nil {
+		servers = []string{}
+	}
+	return userInfo{
+		Username:  user.Username,
+		Role:      user.Role,
+		Servers:   servers,
+		CreatedAt: user.CreatedAt,
+		Primary:   user.Username == Config.Username,
+	}
+}
+
+// parseServerIDs разбирает список ID серверов через запятую
+func parseServerIDs(value string) ([]string, error) {
+	var ids []string
+	for _, id := range strings.Split(value, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		found := false
+		for _, server := range DB.Servers {
+			if server.ID == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Server not found: %s", id)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// HandleMe возвращает текущего пользователя
+func HandleMe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newUserInfo(currentUser(r)))
+}
+
+// HandleUsers возвращает список пользователей
+func HandleUsers(w http.ResponseWriter, r *http.Request) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	owner := database.OwnerUser(Config)
+	users := []userInfo{newUserInfo(&owner)}
+	for i := range Config.Users {
+		users = append(users, newUserInfo(&Config.Users[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// HandleCreateUser создает пользователя
+func HandleCreateUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := strings.TrimSpace(r.FormValue("username"))
+	password := r.FormValue("password")
+	role := r.FormValue("role")
+
+	if username == "" || password == "" || role == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	if !database.IsValidRole(role) {
+		http.Error(w, "Role must be owner, operator or viewer", http.StatusBadRequest)
+		return
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	if database.ResolveUser(Config, username) != nil {
+		http.Error(w, "User already exists", http.StatusConflict)
+		return
+	}
+
+	servers, err := parseServerIDs(r.FormValue("servers"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := database.HashPassword(password)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	user := database.User{
+		Username:     username,
+		PasswordHash: hash,
+		Role:         role,
+		Servers:      servers,
+		CreatedAt:    time.Now(),
+	}
+	Config.Users = append(Config.Users, user)
+	if err := database.SaveConfig(Config); err != nil {
+		http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newUserInfo(&user))
+}
+
+// HandleUpdateUser обновляет роль, пароль или доступные серверы пользователя.
+// Сначала проверяются все поля, затем изменения применяются вместе
+func HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.FormValue("username")
+	role := r.FormValue("role")
+	if role != "" && !database.IsValidRole(role) {
+		http.Error(w, "Role must be owner, operator or viewer", http.StatusBadRequest)
+		return
+	}
+
+	_, updateServers := r.Form["servers"]
+	var servers []string
+	if updateServers {
+		var err error
+		if servers, err = parseServerIDs(r.FormValue("servers")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var hash string
+	if password := r.FormValue("password"); password != "" {
+		var err error
+		if hash, err = database.HashPassword(password); err != nil {
+			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	if username == Config.Username {
+		http.Error(w, "Primary owner is managed in config.json", http.StatusBadRequest)
+		return
+	}
+
+	user := database.FindUser(Config, username)
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	updated := *user
+	if role != "" {
+		updated.Role = role
+	}
+	if hash != "" {
+		updated.PasswordHash = hash
+	}
+	if updateServers {
+		updated.Servers = servers
+	}
+
+	previous := *user
+	*user = updated
+	if err := database.SaveConfig(Config); err != nil {
+		*user = previous
+		http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newUserInfo(user))
+}
+
+// HandleDeleteUser удаляет пользователя
+func HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.FormValue("username")
+
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	if username == Config.Username {
+		http.Error(w, "Primary owner cannot be deleted", http.StatusBadRequest)
+		return
+	}
+
+	for i, user := range Config.Users {
+		if user.Username == username {
+			Config.Users = append(Config.Users[:i], Config.Users[i+1:]...)
+			if err := database.SaveConfig(Config); err != nil {
+				http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	http.Error(w, "User not found", http.StatusNotFound)
+}
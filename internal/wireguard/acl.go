@@ -0,0 +1,136 @@
+package wireguard
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"wg-panel/internal/database"
+)
+
+// Изоляция клиентов: при включенной изоляции трафик между клиентами одного сервера
+// запрещен, кроме разрешенного правилами ACL между группами клиентов
+
+var groupNameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,31}$`)
+
+// ValidateGroups проверяет и нормализует группы клиента (без повторов, по алфавиту)
+func ValidateGroups(groups []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var result []string
+	for _, group := range groups {
+		group = strings.TrimSpace(group)
+		if group == "" || seen[group] {
+			continue
+		}
+		if !groupNameRe.MatchString(group) {
+			return nil, fmt.Errorf("Invalid group name %q", group)
+		}
+		seen[group] = true
+		result = append(result, group)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// ValidateACL проверяет правило ACL
+func ValidateACL(acl *database.ACLRule) error {
+	acl.From = strings.TrimSpace(acl.From)
+	acl.To = strings.TrimSpace(acl.To)
+	acl.Ports = strings.TrimSpace(acl.Ports)
+
+	if !groupNameRe.MatchString(acl.From) || !groupNameRe.MatchString(acl.To) {
+		return fmt.Errorf("ACL from and to must be group names")
+	}
+	if acl.Protocol != "" && acl.Protocol != "tcp" && acl.Protocol != "udp" && acl.Protocol != "both" {
+		return fmt.Errorf("ACL protocol must be tcp, udp, both or empty")
+	}
+	if acl.Ports == "" {
+		return nil
+	}
+	if acl.Protocol == "" {
+		return fmt.Errorf("ACL ports require a protocol")
+	}
+
+	ports, err := normalizePorts(acl.Ports)
+	if err != nil {
+		return fmt.Errorf("Invalid ACL ports %q", acl.Ports)
+	}
+	acl.Ports = ports
+	return nil
+}
+
+// normalizePorts проверяет порт или диапазон 8000-8100
+func normalizePorts(value string) (string, error) {
+	start, end, isRange := strings.Cut(value, "-")
+	first, err := strconv.Atoi(start)
+	last := first
+	if err == nil && isRange {
+		last, err = strconv.Atoi(end)
+	}
+	if err != nil || first < 1 || last > 65535 || last < first {
+		return "", fmt.Errorf("invalid ports %q", value)
+	}
+	if last == first {
+		return strconv.Itoa(first), nil
+	}
+	return strconv.Itoa(first) + "-" + strconv.Itoa(last), nil
+}
+
+// isolationRules правила изоляции сервера: ответный трафик и пробросы портов
+// (hairpin) разрешены, дальше разрешения ACL и запрет всего остального между клиентами
+func isolationRules(db *database.Database, server *database.Server) []firewallRule {
+	if !server.Isolation {
+		return nil
+	}
+	iface := server.Interface
+	between := []string{"-i", iface, "-o", iface}
+
+	rules := []firewallRule{
+		newRule("filter", chainForward, concat(between, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT")...),
+		newRule("filter", chainForward, concat(between, "-m", "conntrack", "--ctstate", "DNAT", "-j", "ACCEPT")...),
+	}
+
+	members := groupMembers(db, server)
+	for _, acl := range server.ACLs {
+		protocols := []string{acl.Protocol}
+		if acl.Protocol == "both" {
+			protocols = []string{"tcp", "udp"}
+		}
+
+		for _, from := range members[acl.From] {
+			for _, to := range members[acl.To] {
+				if from == to {
+					continue
+				}
+				for _, proto := range protocols {
+					args := []string{"-s", from + "/32", "-d", to + "/32", "-i", iface, "-o", iface}
+					if proto != "" {
+						args = append(args, "-p", proto)
+						if acl.Ports != "" {
+							args = append(args, "-m", proto, "--dport", strings.Replace(acl.Ports, "-", ":", 1))
+						}
+					}
+					rules = append(rules, newRule("filter", chainForward, concat(args, "-j", "ACCEPT")...))
+				}
+			}
+		}
+	}
+
+	return append(rules, newRule("filter", chainForward, concat(between, "-j", "DROP")...))
+}
+
+// groupMembers адреса активных клиентов сервера по группам
+func groupMembers(db *database.Database, server *database.Server) map[string][]string {
+	members := make(map[string][]string)
+	for _, client := range db.Clients {
+		if client.ServerID != server.ID || !client.Enabled {
+			continue
+		}
+		for _, group := range client.Groups {
+			members[group] = append(members[group], client.Address)
+		}
+	}
+	return members
+}
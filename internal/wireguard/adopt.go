@@ -0,0 +1,574 @@
+package wireguard
+
+import (
+	"bufio"
+	"crypto/ecdh"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"wg-panel/internal/database"
+)
+
+// Перенос в панель WireGuard интерфейсов, созданных без нее (wg-quick конфиги и
+// запущенные интерфейсы). Сначала строится предпросмотр, затем выбранные интерфейсы
+// записываются в БД с сохранением ключей, адресов и портов
+
+// AdoptCandidate интерфейс, который можно перенести в панель
+type AdoptCandidate struct {
+	Interface  string            `json:"interface"`
+	ConfigPath string            `json:"config_path,omitempty"` // Пусто - интерфейс без конфиг файла
+	Running    bool              `json:"running"`
+	Server     database.Server   `json:"server"`
+	Clients    []database.Client `json:"clients"`
+	Warnings   []string          `json:"warnings"` // Что будет потеряно или изменено
+	Errors     []string          `json:"errors"`   // Причины, по которым перенос невозможен
+}
+
+// ConfigDir каталог конфигов wg-quick (переменная - для тестов)
+var ConfigDir = "/etc/wireguard"
+
+// adoptBackupSuffix суффикс копии оригинального конфига
+const adoptBackupSuffix = ".pre-wg_serf"
+
+// FindUnmanagedInterfaces возвращает интерфейсы и конфиги, которых нет в БД
+func FindUnmanagedInterfaces(db *database.Database) []string {
+	managed := make(map[string]bool)
+	for _, server := range db.Servers {
+		managed[server.Interface] = true
+	}
+
+	seen := make(map[string]bool)
+	var result []string
+	add := func(iface string) {
+		if iface != "" && !managed[iface] && !seen[iface] {
+			seen[iface] = true
+			result = append(result, iface)
+		}
+	}
+
+	for _, iface := range runningInterfaces() {
+		add(iface)
+	}
+
+	configs, _ := filepath.Glob(filepath.Join(ConfigDir, "*.conf"))
+	for _, path := range configs {
+		add(strings.TrimSuffix(filepath.Base(path), ".conf"))
+	}
+
+	return result
+}
+
+// PreviewAdoption разбирает все интерфейсы, не управляемые панелью
+func PreviewAdoption(db *database.Database) []AdoptCandidate {
+	candidates := []AdoptCandidate{}
+	for _, iface := range FindUnmanagedInterfaces(db) {
+		candidates = append(candidates, InspectInterface(db, iface))
+	}
+	return candidates
+}
+
+// InspectInterface собирает сервер и клиентов из конфига и живого состояния интерфейса
+func InspectInterface(db *database.Database, iface string) AdoptCandidate {
+	candidate := AdoptCandidate{
+		Interface: iface,
+		Clients:   []database.Client{},
+		Warnings:  []string{},
+		Errors:    []string{},
+	}
+
+	var config *wgQuickConfig
+	configPath := filepath.Join(ConfigDir, iface+".conf")
+	if content, err := os.ReadFile(configPath); err == nil {
+		candidate.ConfigPath = configPath
+		config = parseWgQuickConfig(string(content))
+	}
+
+	var dump *wgQuickConfig
+	for _, running := range runningInterfaces() {
+		if running == iface {
+			candidate.Running = true
+			dump = readInterfaceDump(iface)
+			break
+		}
+	}
+
+	if config == nil && dump == nil {
+		candidate.Errors = append(candidate.Errors, "интерфейс не найден")
+		return candidate
+	}
+	if config == nil {
+		config = dump
+		candidate.Warnings = append(candidate.Warnings, "конфиг файл не найден, данные взяты из wg show")
+	} else if dump != nil {
+		candidate.Warnings = append(candidate.Warnings, mergeDump(config, dump)...)
+	}
+	candidate.Warnings = append(candidate.Warnings, config.Warnings...)
+
+	candidate.Server = buildAdoptedServer(iface, config, candidate.Running)
+	candidate.Errors = append(candidate.Errors, validateAdoptedServer(db, &candidate.Server)...)
+	candidate.Clients, candidate.Warnings = buildAdoptedClients(&candidate.Server, config, candidate.Warnings)
+
+	if candidate.ConfigPath != "" {
+		candidate.Warnings = append(candidate.Warnings,
+			fmt.Sprintf("конфиг будет перезаписан панелью, оригинал сохранится в %s%s", candidate.ConfigPath, adoptBackupSuffix))
+	}
+
+	return candidate
+}
+
+// AdoptInterfaces переносит интерфейсы в БД. Если хоть один нельзя перенести - ничего не меняется
+func AdoptInterfaces(db *database.Database, ifaces []string) ([]AdoptCandidate, error) {
+	unmanaged := make(map[string]bool)
+	for _, iface := range FindUnmanagedInterfaces(db) {
+		unmanaged[iface] = true
+	}
+
+	var candidates []AdoptCandidate
+	ports := make(map[int]string)
+	networks := make(map[string]string)
+	for _, iface := range ifaces {
+		if !unmanaged[iface] {
+			return nil, fmt.Errorf("%s: интерфейс не найден или уже управляется панелью", iface)
+		}
+		unmanaged[iface] = false
+
+		candidate := InspectInterface(db, iface)
+		if len(candidate.Errors) > 0 {
+			return nil, fmt.Errorf("%s: %s", iface, strings.Join(candidate.Errors, "; "))
+		}
+
+		// Конфликты между выбранными интерфейсами
+		if other, exists := ports[candidate.Server.ListenPort]; exists {
+			return nil, fmt.Errorf("%s: порт %d уже используется %s", iface, candidate.Server.ListenPort, other)
+		}
+		network := networkPrefix(candidate.Server.Address)
+		if other, exists := networks[network]; exists {
+			return nil, fmt.Errorf("%s: подсеть уже используется %s", iface, other)
+		}
+		ports[candidate.Server.ListenPort] = iface
+		networks[network] = iface
+
+		candidates = append(candidates, candidate)
+	}
+
+	for i := range candidates {
+		candidate := &candidates[i]
+		if candidate.ConfigPath != "" {
+			backupConfig(candidate.ConfigPath)
+		}
+
+		db.Servers = append(db.Servers, candidate.Server)
+		db.Clients = append(db.Clients, candidate.Clients...)
+		database.RegisterInterface(db, candidate.Interface)
+		updateNextClientIP(&db.Servers[len(db.Servers)-1], db)
+		log.Printf("📥 Интерфейс %s перенесен в панель: %d клиентов", candidate.Interface, len(candidate.Clients))
+	}
+
+	database.SaveDatabase(db)
+	return candidates, nil
+}
+
+// wgQuickPeer peer из конфига или wg show
+type wgQuickPeer struct {
+	Name         string
+	PublicKey    string
+	PresharedKey string
+	AllowedIPs   []string
+}
+
+// wgQuickConfig разобранный конфиг wg-quick
+type wgQuickConfig struct {
+	PrivateKey string
+	Addresses  []string
+	ListenPort int
+	DNS        string
+	PostUp     []string
+	PostDown   []string
+	Peers      []wgQuickPeer
+	Warnings   []string
+}
+
+// parseWgQuickConfig разбирает конфиг wg-quick (ключи без учета регистра, комментарий
+// перед [Peer] считается именем клиента)
+func parseWgQuickConfig(content string) *wgQuickConfig {
+	config := &wgQuickConfig{}
+	section := ""
+	comment := ""
+	var peer *wgQuickPeer
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			if name := peerNameFromComment(line); name != "" {
+				if peer != nil && peer.PublicKey == "" && peer.Name == "" {
+					peer.Name = name
+				} else {
+					comment = name
+				}
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			section = strings.ToLower(strings.Trim(line, "[] "))
+			if section == "peer" {
+				config.Peers = append(config.Peers, wgQuickPeer{Name: comment})
+				peer = &config.Peers[len(config.Peers)-1]
+			}
+			comment = ""
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+
+		switch section {
+		case "interface":
+			switch key {
+			case "privatekey":
+				config.PrivateKey = value
+			case "address":
+				config.Addresses = append(config.Addresses, splitList(value)...)
+			case "listenport":
+				config.ListenPort, _ = strconv.Atoi(value)
+			case "dns":
+				config.DNS = value
+			case "postup":
+				config.PostUp = append(config.PostUp, value)
+			case "postdown":
+				config.PostDown = append(config.PostDown, value)
+			default:
+				config.Warnings = append(config.Warnings, fmt.Sprintf("параметр %s не поддерживается и будет потерян", key))
+			}
+		case "peer":
+			switch key {
+			case "publickey":
+				peer.PublicKey = value
+			case "presharedkey":
+				peer.PresharedKey = value
+			case "allowedips":
+				peer.AllowedIPs = append(peer.AllowedIPs, splitList(value)...)
+			default:
+				config.Warnings = append(config.Warnings, fmt.Sprintf("параметр peer %s не поддерживается и будет потерян", key))
+			}
+		}
+	}
+
+	return config
+}
+
+// peerNameFromComment извлекает имя клиента из комментария
+// (# laptop, # Name = laptop, # Client: laptop, ### begin laptop ###)
+func peerNameFromComment(line string) string {
+	text := strings.TrimSpace(strings.Trim(line, "# "))
+	lower := strings.ToLower(text)
+	if strings.HasPrefix(lower, "end ") {
+		return ""
+	}
+	if strings.HasPrefix(lower, "begin ") {
+		text = text[len("begin "):]
+	}
+	if i := strings.IndexAny(text, ":="); i >= 0 {
+		// Закомментированные параметры (# PostUp = ...) - не имя
+		key := strings.ToLower(strings.TrimSpace(text[:i]))
+		if key != "name" && key != "client" && key != "friendly_name" {
+			return ""
+		}
+		text = text[i+1:]
+	}
+	return strings.TrimSpace(text)
+}
+
+// splitList разбивает значение вида "a, b"
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// runningInterfaces возвращает запущенные интерфейсы WireGuard
+func runningInterfaces() []string {
+	output, err := exec.Command("wg", "show", "interfaces").Output()
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(output))
+}
+
+// readInterfaceDump читает состояние интерфейса из wg show dump и ip addr
+func readInterfaceDump(iface string) *wgQuickConfig {
+	output, err := exec.Command("wg", "show", iface, "dump").Output()
+	if err != nil {
+		return nil
+	}
+
+	config := &wgQuickConfig{}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	for i, line := range lines {
+		fields := strings.Split(line, "\t")
+		if i == 0 {
+			// private-key public-key listen-port fwmark
+			if len(fields) >= 3 {
+				config.PrivateKey = fields[0]
+				config.ListenPort, _ = strconv.Atoi(fields[2])
+			}
+			continue
+		}
+
+		// public-key preshared-key endpoint allowed-ips ...
+		if len(fields) < 4 {
+			continue
+		}
+		peer := wgQuickPeer{PublicKey: fields[0]}
+		if fields[1] != "(none)" {
+			peer.PresharedKey = fields[1]
+		}
+		if fields[3] != "(none)" {
+			peer.AllowedIPs = splitList(fields[3])
+		}
+		config.Peers = append(config.Peers, peer)
+	}
+
+	// Адрес интерфейса: "5: wg0    inet 10.0.0.1/24 scope global wg0"
+	output, err = exec.Command("ip", "-o", "-4", "addr", "show", "dev", iface).Output()
+	if err == nil {
+		fields := strings.Fields(string(output))
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] == "inet" {
+				config.Addresses = append(config.Addresses, fields[i+1])
+			}
+		}
+	}
+
+	return config
+}
+
+// mergeDump дополняет конфиг живым состоянием интерфейса
+func mergeDump(config, dump *wgQuickConfig) []string {
+	var warnings []string
+
+	if config.PrivateKey == "" {
+		config.PrivateKey = dump.PrivateKey
+	}
+	if config.ListenPort == 0 && dump.ListenPort != 0 {
+		config.ListenPort = dump.ListenPort
+		warnings = append(warnings, fmt.Sprintf("ListenPort не задан в конфиге, используется текущий %d", dump.ListenPort))
+	}
+	if len(config.Addresses) == 0 {
+		config.Addresses = dump.Addresses
+	}
+
+	known := make(map[string]bool)
+	for _, peer := range config.Peers {
+		known[peer.PublicKey] = true
+	}
+	for _, peer := range dump.Peers {
+		if !known[peer.PublicKey] {
+			config.Peers = append(config.Peers, peer)
+			warnings = append(warnings, fmt.Sprintf("peer %s есть только в запущенном интерфейсе", shortKey(peer.PublicKey)))
+		}
+	}
+
+	return warnings
+}
+
+// buildAdoptedServer собирает сервер панели из конфига
+func buildAdoptedServer(iface string, config *wgQuickConfig, running bool) database.Server {
+	server := database.Server{
+		ID:         fmt.Sprintf("%d", time.Now().UnixNano()),
+		Name:       iface,
+		Interface:  iface,
+		PrivateKey: config.PrivateKey,
+		ListenPort: config.ListenPort,
+		DNS:        config.DNS,
+		Enabled:    running,
+		CreatedAt:  time.Now(),
+		PostUp:     strings.Join(config.PostUp, "; "),
+		PostDown:   strings.Join(config.PostDown, "; "),
+	}
+
+	for _, address := range config.Addresses {
+		if ip, _, err := net.ParseCIDR(address); err == nil && ip.To4() != nil {
+			server.Address = address
+			break
+		}
+	}
+
+	if server.DNS == "" {
+		server.DNS = "8.8.8.8, 1.1.1.1"
+	}
+
+	if publicKey, err := publicKeyFor(server.PrivateKey); err == nil {
+		server.PublicKey = publicKey
+	}
+
+	return server
+}
+
+// validateAdoptedServer проверяет, что сервер можно вести панелью
+func validateAdoptedServer(db *database.Database, server *database.Server) []string {
+	var errors []string
+
+	if server.PrivateKey == "" {
+		errors = append(errors, "приватный ключ не найден")
+	} else if server.PublicKey == "" {
+		errors = append(errors, "неверный приватный ключ")
+	}
+	if server.ListenPort == 0 {
+		errors = append(errors, "ListenPort не задан")
+	} else if !database.IsPortAvailableForServer(db, server.ListenPort) {
+		errors = append(errors, fmt.Sprintf("порт %d уже используется", server.ListenPort))
+	}
+
+	if server.Address == "" {
+		errors = append(errors, "не найден IPv4 адрес интерфейса")
+	} else if !strings.HasSuffix(server.Address, "/24") {
+		errors = append(errors, fmt.Sprintf("подсеть %s не поддерживается, панель работает только с /24", server.Address))
+	} else if !database.IsNetworkAvailable(db, server.Address) {
+		errors = append(errors, fmt.Sprintf("подсеть %s уже используется", server.Address))
+	}
+
+	return errors
+}
+
+// buildAdoptedClients превращает peers в клиентов панели. Приватные ключи клиентов
+// неизвестны, поэтому конфиги для них панель выдать не сможет
+func buildAdoptedClients(server *database.Server, config *wgQuickConfig, warnings []string) ([]database.Client, []string) {
+	clients := []database.Client{}
+	baseID := time.Now().UnixNano()
+
+	for i, peer := range config.Peers {
+		if peer.PublicKey == "" {
+			warnings = append(warnings, "peer без PublicKey пропущен")
+			continue
+		}
+
+		// Адрес проверяется так же, как заданный вручную: подсеть, адрес сети и
+		// broadcast, адрес сервера и уже перенесенные клиенты
+		adopted := &database.Database{Clients: clients}
+		address := ""
+		var rejected []string
+		for _, allowed := range peer.AllowedIPs {
+			ip, ipNet, err := net.ParseCIDR(allowed)
+			if err != nil || ip.To4() == nil {
+				continue
+			}
+			if ones, _ := ipNet.Mask.Size(); ones != 32 {
+				continue
+			}
+			if err := database.ValidateClientIP(adopted, server, ip.String()); err != nil {
+				rejected = append(rejected, err.Error())
+				continue
+			}
+			address = ip.String()
+			break
+		}
+
+		if address == "" {
+			reason := "нет адреса /32 в подсети сервера"
+			if len(rejected) > 0 {
+				reason = strings.Join(rejected, "; ")
+			}
+			warnings = append(warnings, fmt.Sprintf("peer %s пропущен: %s (AllowedIPs: %s)",
+				shortKey(peer.PublicKey), reason, strings.Join(peer.AllowedIPs, ", ")))
+			continue
+		}
+
+		if len(peer.AllowedIPs) > 1 {
+			warnings = append(warnings, fmt.Sprintf("peer %s: AllowedIPs %s сокращены до %s/32",
+				shortKey(peer.PublicKey), strings.Join(peer.AllowedIPs, ", "), address))
+		}
+
+		name := peer.Name
+		if name == "" {
+			name = "peer-" + address
+		}
+
+		clients = append(clients, database.Client{
+			ID:           fmt.Sprintf("%d", baseID+int64(i)+1),
+			ServerID:     server.ID,
+			Name:         name,
+			PublicKey:    peer.PublicKey,
+			PresharedKey: peer.PresharedKey,
+			Address:      address,
+			Enabled:      true,
+			Comment:      "перенесен из " + server.Interface,
+			CreatedAt:    time.Now(),
+		})
+	}
+
+	if len(clients) > 0 {
+		warnings = append(warnings, "приватные ключи клиентов неизвестны: скачать их конфиги из панели нельзя")
+	}
+
+	return clients, warnings
+}
+
+// publicKeyFor вычисляет публичный ключ из приватного (X25519, как wg pubkey)
+func publicKeyFor(privateKey string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil {
+		return "", err
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// backupConfig сохраняет оригинальный конфиг (один раз)
+func backupConfig(path string) {
+	backup := path + adoptBackupSuffix
+	if _, err := os.Stat(backup); err == nil {
+		return
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(backup, content, 0600); err != nil {
+		log.Printf("⚠️  Не удалось сохранить копию %s: %v", path, err)
+	}
+}
+
+// networkPrefix первые два октета адреса (так панель сравнивает подсети)
+func networkPrefix(address string) string {
+	parts := strings.Split(address, ".")
+	if len(parts) < 2 {
+		return address
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// shortKey сокращает ключ для сообщений
+func shortKey(key string) string {
+	if len(key) > 8 {
+		return key[:8] + "..."
+	}
+	return key
+}
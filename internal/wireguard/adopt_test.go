@@ -0,0 +1,164 @@
+package wireguard
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"wg-panel/internal/database"
+)
+
+func TestParseWgQuickConfig(t *testing.T) {
+	content, err := os.ReadFile("testdata/wg0.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := parseWgQuickConfig(string(content))
+
+	if config.PrivateKey != "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=" || config.ListenPort != 51820 || config.DNS != "10.9.0.1" {
+		t.Errorf("interface = %+v", config)
+	}
+	if want := []string{"10.9.0.1/24", "fd00:9::1/64"}; !reflect.DeepEqual(config.Addresses, want) {
+		t.Errorf("Addresses = %v, want %v", config.Addresses, want)
+	}
+	if len(config.PostUp) != 2 || len(config.PostDown) != 1 {
+		t.Errorf("PostUp = %v, PostDown = %v", config.PostUp, config.PostDown)
+	}
+
+	wantPeers := []wgQuickPeer{
+		{Name: "laptop", PublicKey: "LAPTOPxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx=", AllowedIPs: []string{"10.9.0.2/32"}},
+		{Name: "Телефон Маши", PublicKey: "PHONExxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx=", PresharedKey: "PSKxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx=",
+			AllowedIPs: []string{"10.9.0.3/32", "fd00:9::3/128"}},
+		{Name: "router", PublicKey: "ROUTERxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx=", AllowedIPs: []string{"10.9.0.4/32", "192.168.10.0/24"}},
+		{PublicKey: "NONAMExxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx=", AllowedIPs: []string{"10.9.0.5/32"}},
+	}
+	if !reflect.DeepEqual(config.Peers, wantPeers) {
+		t.Errorf("Peers = %+v\nwant %+v", config.Peers, wantPeers)
+	}
+
+	// Неподдерживаемые параметры попадают в предупреждения
+	warnings := strings.Join(config.Warnings, "\n")
+	for _, key := range []string{"mtu", "table", "peer persistentkeepalive", "peer endpoint"} {
+		if !strings.Contains(warnings, "параметр "+key+" ") {
+			t.Errorf("no warning about %s in %q", key, warnings)
+		}
+	}
+	if len(config.Warnings) != 4 {
+		t.Errorf("Warnings = %q", config.Warnings)
+	}
+}
+
+func TestPeerNameFromComment(t *testing.T) {
+	tests := map[string]string{
+		"# laptop":                   "laptop",
+		"#laptop":                    "laptop",
+		"# Name = Телефон Маши":      "Телефон Маши",
+		"# name=phone":               "phone",
+		"# Client: office-pc":        "office-pc",
+		"# friendly_name = nas":      "nas",
+		"### begin router ###":       "router",
+		"### BEGIN router ###":       "router",
+		"### end router ###":         "",
+		"# PostUp = iptables -A ...": "",
+		"# Endpoint: 1.2.3.4:51820":  "",
+		"#":                          "",
+		"####":                       "",
+	}
+	for line, want := range tests {
+		if got := peerNameFromComment(line); got != want {
+			t.Errorf("peerNameFromComment(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+func TestMergeDump(t *testing.T) {
+	config := &wgQuickConfig{
+		Peers: []wgQuickPeer{{Name: "laptop", PublicKey: "LAPTOPkey", AllowedIPs: []string{"10.9.0.2/32"}}},
+	}
+	dump := &wgQuickConfig{
+		PrivateKey: "dumpkey",
+		ListenPort: 51820,
+		Addresses:  []string{"10.9.0.1/24"},
+		Peers: []wgQuickPeer{
+			{PublicKey: "LAPTOPkey", AllowedIPs: []string{"10.9.0.99/32"}},
+			{PublicKey: "RUNTIMEkey", AllowedIPs: []string{"10.9.0.7/32"}},
+		},
+	}
+
+	warnings := mergeDump(config, dump)
+	if config.PrivateKey != "dumpkey" || config.ListenPort != 51820 || !reflect.DeepEqual(config.Addresses, dump.Addresses) {
+		t.Errorf("interface = %+v", config)
+	}
+	// Peer из конфига не перезаписывается, peer только из wg show добавляется
+	if len(config.Peers) != 2 || config.Peers[0].Name != "laptop" || config.Peers[0].AllowedIPs[0] != "10.9.0.2/32" || config.Peers[1].PublicKey != "RUNTIMEkey" {
+		t.Errorf("Peers = %+v", config.Peers)
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "51820") || !strings.Contains(warnings[1], "RUNTIME") {
+		t.Errorf("warnings = %q", warnings)
+	}
+
+	// Значения из конфига важнее живого состояния
+	config = &wgQuickConfig{PrivateKey: "filekey", ListenPort: 51000, Addresses: []string{"10.8.0.1/24"}}
+	if warnings := mergeDump(config, dump); len(warnings) != 2 || config.PrivateKey != "filekey" || config.ListenPort != 51000 || config.Addresses[0] != "10.8.0.1/24" {
+		t.Errorf("config = %+v, warnings = %q", config, warnings)
+	}
+}
+
+func TestBuildAdoptedClients(t *testing.T) {
+	content, err := os.ReadFile("testdata/wg0.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := parseWgQuickConfig(string(content))
+	config.Peers = append(config.Peers,
+		wgQuickPeer{PublicKey: "SERVERIPxxx", AllowedIPs: []string{"10.9.0.1/32"}},
+		wgQuickPeer{PublicKey: "NETWORKxxxx", AllowedIPs: []string{"10.9.0.0/32"}},
+		wgQuickPeer{PublicKey: "BROADCASTxx", AllowedIPs: []string{"10.9.0.255/32"}},
+		wgQuickPeer{PublicKey: "DUPLICATEx", AllowedIPs: []string{"10.9.0.2/32"}},
+		wgQuickPeer{PublicKey: "OUTSIDExxxx", AllowedIPs: []string{"10.8.0.2/32"}},
+		wgQuickPeer{PublicKey: "SUBNETxxxxx", AllowedIPs: []string{"10.9.0.0/24"}},
+		wgQuickPeer{AllowedIPs: []string{"10.9.0.50/32"}},
+		// Первый адрес занят сервером, берется следующий
+		wgQuickPeer{PublicKey: "SECONDxxxxx", AllowedIPs: []string{"10.9.0.1/32", "10.9.0.6/32"}},
+	)
+	server := &database.Server{ID: "s1", Interface: "wg0", Address: "10.9.0.1/24"}
+
+	clients, warnings := buildAdoptedClients(server, config, nil)
+
+	got := make(map[string]string)
+	for _, client := range clients {
+		got[client.Address] = client.Name
+		if client.ServerID != "s1" || !client.Enabled {
+			t.Errorf("client = %+v", client)
+		}
+	}
+	want := map[string]string{
+		"10.9.0.2": "laptop",
+		"10.9.0.3": "Телефон Маши",
+		"10.9.0.4": "router",
+		"10.9.0.5": "peer-10.9.0.5",
+		"10.9.0.6": "peer-10.9.0.6",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("clients = %v, want %v", got, want)
+	}
+
+	joined := strings.Join(warnings, "\n")
+	for _, want := range []string{
+		"peer SERVERIP... пропущен: IP 10.9.0.1 занят сервером",
+		"peer NETWORKx... пропущен: IP 10.9.0.0 зарезервирован",
+		"peer BROADCAS... пропущен: IP 10.9.0.255 зарезервирован",
+		"peer DUPLICAT... пропущен: IP 10.9.0.2 уже занят",
+		"peer OUTSIDEx... пропущен: IP 10.8.0.2 вне подсети",
+		"peer SUBNETxx... пропущен: нет адреса /32 в подсети сервера",
+		"peer без PublicKey пропущен",
+		"peer PHONExxx...: AllowedIPs 10.9.0.3/32, fd00:9::3/128 сокращены до 10.9.0.3/32",
+		"peer SECONDxx...: AllowedIPs 10.9.0.1/32, 10.9.0.6/32 сокращены до 10.9.0.6/32",
+		"приватные ключи клиентов неизвестны",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("no warning %q in:\n%s", want, joined)
+		}
+	}
+}
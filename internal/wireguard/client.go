@@ -4,15 +4,17 @@ import (
 	"fmt"
 	"log"
 	"os/exec"
+	"strings"
 	"time"
 
 	"wg-panel/internal/database"
+	"wg-panel/internal/dns"
 
 	"github.com/skip2/go-qrcode"
 )
 
-// CreateClient создает нового клиента
-func CreateClient(db *database.Database, serverID, name, comment string) (*database.Client, error) {
+// CreateClient создает нового клиента (address - статический IP, пусто - следующий свободный)
+func CreateClient(db *database.Database, serverID, name, comment, address string) (*database.Client, error) {
 	// Находим сервер
 	var server *database.Server
 	for i := range db.Servers {
@@ -32,6 +34,17 @@ func CreateClient(db *database.Database, serverID, name, comment string) (*datab
 		return nil, err
 	}
 
+	// Следующий свободный IP (пропускаем занятые статическими адресами)
+	if address == "" {
+		address = database.GetNextClientIP(server)
+		for address != "" && !database.IsClientIPAvailable(db, serverID, address) {
+			if server.NextClientIP > 254 {
+				return nil, fmt.Errorf("no free IP addresses left in %s", server.Address)
+			}
+			address = database.GetNextClientIP(server)
+		}
+	}
+
 	// Создаем клиента
 	client := database.Client{
 		ID:         fmt.Sprintf("%d", time.Now().UnixNano()),
@@ -39,7 +52,7 @@ func CreateClient(db *database.Database, serverID, name, comment string) (*datab
 		Name:       name,
 		PublicKey:  publicKey,
 		PrivateKey: privateKey,
-		Address:    database.GetNextClientIP(server),
+		Address:    address,
 		Enabled:    true,
 		Comment:    comment,
 		CreatedAt:  time.Now(),
@@ -117,7 +130,16 @@ func ToggleClient(db *database.Database, client *database.Client) error {
 // GenerateClientConfig генерирует конфиг для клиента
 func GenerateClientConfig(client database.Client, server *database.Server) string {
 	// Получаем endpoint сервера
-	endpoint := database.GetServerEndpoint()
+	return GenerateClientConfigWithEndpoint(client, server, database.GetServerEndpoint())
+}
+
+// GenerateClientConfigWithEndpoint генерирует конфиг с известным endpoint (для пакетной выгрузки)
+func GenerateClientConfigWithEndpoint(client database.Client, server *database.Server, endpoint string) string {
+	// Встроенный DNS отвечает на адресе сервера в туннеле
+	resolver := server.DNS
+	if builtin := dns.ClientResolver(server); builtin != "" {
+		resolver = builtin
+	}
 
 	config := fmt.Sprintf(`[Interface]
 PrivateKey = %s
@@ -129,11 +151,41 @@ PublicKey = %s
 Endpoint = %s:%d
 AllowedIPs = 0.0.0.0/0
 PersistentKeepalive = 10
-`, client.PrivateKey, client.Address, server.DNS, server.PublicKey, endpoint, server.ListenPort)
+`, client.PrivateKey, client.Address, resolver, server.PublicKey, endpoint, server.ListenPort)
+
+	if client.PresharedKey != "" {
+		config += fmt.Sprintf("PresharedKey = %s\n", client.PresharedKey)
+	}
 
 	return config
 }
 
+// DisableExpiredClients отключает клиентов с истекшим сроком действия
+func DisableExpiredClients(db *database.Database) int {
+	now := time.Now()
+	disabled := 0
+
+	for i := range db.Clients {
+		client := &db.Clients[i]
+		if !client.Enabled || client.ExpiresAt == nil || now.Before(*client.ExpiresAt) {
+			continue
+		}
+
+		if err := ToggleClient(db, client); err != nil {
+			log.Printf("⚠️  Не удалось отключить клиента %s: %v", client.Name, err)
+			continue
+		}
+		log.Printf("⏰ Клиент %s отключен: истек срок действия", client.Name)
+		disabled++
+	}
+
+	if disabled > 0 {
+		ApplyFirewall(db)
+		database.SaveDatabase(db)
+	}
+	return disabled
+}
+
 // GenerateQRCode генерирует QR код для конфига
 func GenerateQRCode(config string) ([]byte, error) {
 	return qrcode.Encode(config, qrcode.Medium, 256)
@@ -141,8 +193,15 @@ func GenerateQRCode(config string) ([]byte, error) {
 
 // addPeerToWireGuard добавляет peer в WireGuard
 func addPeerToWireGuard(server *database.Server, client database.Client) error {
+	// Ключ передается через stdin, чтобы не светить его в списке процессов,
+	// /dev/null снимает ключ (для обновления существующего peer)
+	pskFile := "/dev/null"
+	if client.PresharedKey != "" {
+		pskFile = "/dev/stdin"
+	}
 	cmd := exec.Command("wg", "set", server.Interface, "peer", client.PublicKey,
-		"allowed-ips", client.Address+"/32")
+		"preshared-key", pskFile, "allowed-ips", client.Address+"/32")
+	cmd.Stdin = strings.NewReader(client.PresharedKey)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		log.Printf("Ошибка добавления peer: %s, %v", string(output), err)
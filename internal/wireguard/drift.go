@@ -0,0 +1,168 @@
+package wireguard
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"wg-panel/internal/database"
+)
+
+// Проверка расхождений: ручной wg set или iptables -F больше не остаются
+// незамеченными до перезапуска. Фоновая проверка - это синхронизация в режиме
+// dry run, при включенном auto_repair найденная разница сразу применяется
+
+// Интервал по умолчанию, если в config.json не задан
+const defaultDriftInterval = 60
+
+// DriftStatus результат последней проверки расхождений
+type DriftStatus struct {
+	Enabled      bool              `json:"enabled"`
+	AutoRepair   bool              `json:"auto_repair"`
+	Interval     int               `json:"interval_seconds"`
+	CheckedAt    *time.Time        `json:"checked_at,omitempty"`
+	DriftSince   *time.Time        `json:"drift_since,omitempty"` // Когда появились текущие расхождения
+	Changes      []ReconcileChange `json:"changes"`               // Что отличается от БД (при auto_repair - что не удалось исправить)
+	Errors       []string          `json:"errors"`
+	LastRepairAt *time.Time        `json:"last_repair_at,omitempty"`
+	LastRepair   *ReconcileReport  `json:"last_repair,omitempty"`
+}
+
+var (
+	driftMu     sync.Mutex
+	driftConfig database.DriftConfig
+	driftStatus = DriftStatus{Changes: []ReconcileChange{}, Errors: []string{}}
+)
+
+// DriftInterval интервал проверки из настроек (0 - проверка отключена)
+func DriftInterval(cfg database.DriftConfig) time.Duration {
+	switch {
+	case cfg.Interval < 0:
+		return 0
+	case cfg.Interval == 0:
+		return defaultDriftInterval * time.Second
+	default:
+		return time.Duration(cfg.Interval) * time.Second
+	}
+}
+
+// DriftCheckLoop периодически сравнивает систему с БД
+func DriftCheckLoop(db *database.Database, cfg database.DriftConfig) {
+	interval := DriftInterval(cfg)
+
+	driftMu.Lock()
+	driftConfig = cfg
+	driftStatus.Enabled = interval > 0
+	driftStatus.AutoRepair = cfg.AutoRepair
+	driftStatus.Interval = int(interval / time.Second)
+	driftMu.Unlock()
+
+	if interval == 0 {
+		log.Println("🔍 Проверка расхождений отключена")
+		return
+	}
+	log.Printf("🔍 Проверка расхождений каждые %s (автоисправление: %v)", interval, cfg.AutoRepair)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Запросы API вызывают CheckDrift уже под блокировкой БД
+		database.Mu.Lock()
+		CheckDrift(db)
+		database.Mu.Unlock()
+	}
+}
+
+// CheckDrift проверяет расхождения сейчас и при auto_repair исправляет их.
+// Вызывающий держит database.Mu
+func CheckDrift(db *database.Database) DriftStatus {
+	driftMu.Lock()
+	defer driftMu.Unlock()
+
+	report := Reconcile(db, true)
+	now := time.Now()
+
+	previous := driftKeys(driftStatus.Changes)
+	current := driftKeys(report.Changes)
+	errorsChanged := strings.Join(driftStatus.Errors, "\n") != strings.Join(report.Errors, "\n")
+
+	driftStatus.CheckedAt = &now
+	driftStatus.Changes = report.Changes
+	driftStatus.Errors = report.Errors
+
+	switch {
+	case len(report.Changes) == 0:
+		if driftStatus.DriftSince != nil {
+			log.Println("✅ Расхождений с БД больше нет")
+		}
+		driftStatus.DriftSince = nil
+	case current != previous:
+		// В лог пишем только новую картину, а не одно и то же каждую минуту
+		if driftStatus.DriftSince == nil {
+			driftStatus.DriftSince = &now
+		}
+		log.Printf("⚠️  Система расходится с БД (%d):", len(report.Changes))
+		for _, change := range report.Changes {
+			log.Printf("  • %s %s %s", change.Action, change.Target, change.Detail)
+		}
+	}
+
+	if len(report.Changes) > 0 && driftConfig.AutoRepair {
+		// Исправление меняет только систему: БД остается источником истины
+		repair := Reconcile(db, false)
+
+		driftStatus.LastRepairAt = &now
+		driftStatus.LastRepair = repair
+
+		// В статусе остается только то, что исправить не удалось
+		failed := []ReconcileChange{}
+		for _, change := range repair.Changes {
+			if change.Error != "" {
+				failed = append(failed, change)
+				log.Printf("  ❌ %s %s: %s", change.Action, change.Target, change.Error)
+			}
+		}
+		driftStatus.Changes = failed
+		if len(failed) == 0 {
+			driftStatus.DriftSince = nil
+		}
+
+		log.Printf("🔧 Расхождения исправлены: применено %d, ошибок %d", repair.Applied, repair.Failed)
+	}
+
+	if errorsChanged {
+		for _, e := range report.Errors {
+			log.Println("⚠️  Проверка расхождений:", e)
+		}
+	}
+
+	return snapshotDrift()
+}
+
+// LastDrift результат последней проверки
+func LastDrift() DriftStatus {
+	driftMu.Lock()
+	defer driftMu.Unlock()
+	return snapshotDrift()
+}
+
+// snapshotDrift копия статуса (вызывать под driftMu)
+func snapshotDrift() DriftStatus {
+	status := driftStatus
+	status.Changes = append([]ReconcileChange{}, driftStatus.Changes...)
+	status.Errors = append([]string{}, driftStatus.Errors...)
+	return status
+}
+
+// driftKeys отпечаток набора изменений для сравнения с прошлой проверкой
+func driftKeys(changes []ReconcileChange) string {
+	keys := make([]string, 0, len(changes))
+	for _, change := range changes {
+		keys = append(keys, change.Action+" "+change.Interface+" "+change.Target+" "+change.Detail)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "\n")
+}
@@ -0,0 +1,128 @@
+package wireguard
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"wg-panel/internal/database"
+)
+
+// Политики исходящего трафика: у клиента с политикой своя цепочка WGSERF-C-*,
+// в которую из WGSERF-FWD переходит весь его трафик. RETURN возвращает пакет
+// к общим правилам (изоляция, разрешения интерфейса), DROP его отбрасывает
+
+// Префикс цепочек клиентов (в nftables - обычные цепочки таблицы в нижнем регистре)
+const clientChainPrefix = "WGSERF-C-"
+
+// Частные сети, которые остаются доступны при BlockInternet
+var privateNetworks = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10"}
+
+// ValidateEgress проверяет и нормализует политику. Пустая политика означает
+// отсутствие ограничений и возвращается как nil
+func ValidateEgress(policy *database.EgressPolicy) (*database.EgressPolicy, error) {
+	if policy == nil {
+		return nil, nil
+	}
+	for _, list := range [][]database.EgressRule{policy.Allow, policy.Deny} {
+		for i := range list {
+			if err := validateEgressRule(&list[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !policy.BlockInternet && len(policy.Allow) == 0 && len(policy.Deny) == 0 {
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// validateEgressRule проверяет одно назначение
+func validateEgressRule(rule *database.EgressRule) error {
+	cidr, err := normalizeCIDR(strings.TrimSpace(rule.CIDR))
+	if err != nil {
+		return fmt.Errorf("Invalid egress CIDR %q", rule.CIDR)
+	}
+	rule.CIDR = cidr
+
+	if rule.Protocol != "" && rule.Protocol != "tcp" && rule.Protocol != "udp" && rule.Protocol != "both" {
+		return fmt.Errorf("Egress protocol must be tcp, udp, both or empty")
+	}
+	rule.Ports = strings.TrimSpace(rule.Ports)
+	if rule.Ports == "" {
+		return nil
+	}
+	if rule.Protocol == "" {
+		return fmt.Errorf("Egress ports require a protocol")
+	}
+	ports, err := normalizePorts(rule.Ports)
+	if err != nil {
+		return fmt.Errorf("Invalid egress ports %q", rule.Ports)
+	}
+	rule.Ports = ports
+	return nil
+}
+
+// clientChain имя цепочки клиента (ID бывают длиннее, чем допускает iptables)
+func clientChain(client *database.Client) string {
+	sum := sha256.Sum256([]byte(client.ID))
+	return clientChainPrefix + hex.EncodeToString(sum[:4])
+}
+
+// egressRules переход в цепочку клиента и ее содержимое. Ответы на входящие
+// соединения (пробросы портов) политика не ограничивает
+func egressRules(client *database.Client, iface string) []firewallRule {
+	policy := client.Egress
+	if policy == nil {
+		return nil
+	}
+	chain := clientChain(client)
+
+	rules := []firewallRule{
+		newRule("filter", chainForward, "-s", client.Address+"/32", "-i", iface, "-j", chain),
+		newRule("filter", chain, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"),
+	}
+	for _, rule := range policy.Deny {
+		rules = append(rules, egressRuleArgs(chain, rule, "DROP")...)
+	}
+	for _, rule := range policy.Allow {
+		rules = append(rules, egressRuleArgs(chain, rule, "RETURN")...)
+	}
+
+	switch {
+	case len(policy.Allow) > 0:
+		rules = append(rules, newRule("filter", chain, "-j", "DROP"))
+	case policy.BlockInternet:
+		for _, network := range privateNetworks {
+			rules = append(rules, newRule("filter", chain, "-d", network, "-j", "RETURN"))
+		}
+		rules = append(rules, newRule("filter", chain, "-j", "DROP"))
+	}
+	return rules
+}
+
+// egressRuleArgs правила назначения (both - по правилу на протокол)
+func egressRuleArgs(chain string, rule database.EgressRule, target string) []firewallRule {
+	protocols := []string{rule.Protocol}
+	if rule.Protocol == "both" {
+		protocols = []string{"tcp", "udp"}
+	}
+
+	var rules []firewallRule
+	for _, proto := range protocols {
+		var args []string
+		// iptables -S не печатает -d 0.0.0.0/0
+		if rule.CIDR != "0.0.0.0/0" {
+			args = append(args, "-d", rule.CIDR)
+		}
+		if proto != "" {
+			args = append(args, "-p", proto)
+			if rule.Ports != "" {
+				args = append(args, "-m", proto, "--dport", strings.Replace(rule.Ports, "-", ":", 1))
+			}
+		}
+		rules = append(rules, newRule("filter", chain, concat(args, "-j", target)...))
+	}
+	return rules
+}
@@ -0,0 +1,360 @@
+package wireguard
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+
+	"wg-panel/internal/database"
+	"wg-panel/internal/dns"
+)
+
+// Собственные цепочки панели. Все правила WireGuard и пробросов портов живут только
+// в них, в стандартные цепочки добавляется лишь переход - правила Docker, firewalld
+// и администратора остаются нетронутыми. В nftables им соответствуют базовые
+// цепочки таблицы ip wg_serf
+const (
+	chainInput       = "WGSERF-IN"   // filter INPUT: порты WireGuard
+	chainForward     = "WGSERF-FWD"  // filter FORWARD: трафик интерфейсов и пробросы
+	chainPrerouting  = "WGSERF-PRE"  // nat PREROUTING: DNAT пробросов портов
+	chainOutput      = "WGSERF-OUT"  // nat OUTPUT: DNAT пробросов для соединений с самого сервера
+	chainPostrouting = "WGSERF-POST" // nat POSTROUTING: MASQUERADE подсетей и hairpin
+)
+
+// firewallChain цепочка панели и стандартная цепочка, из которой на нее переходим
+type firewallChain struct {
+	table string
+	hook  string
+	name  string
+}
+
+var firewallChains = []firewallChain{
+	{"filter", "INPUT", chainInput},
+	{"filter", "FORWARD", chainForward},
+	{"nat", "PREROUTING", chainPrerouting},
+	{"nat", "OUTPUT", chainOutput},
+	{"nat", "POSTROUTING", chainPostrouting},
+}
+
+// firewallBackend механизм, которым панель ставит свои правила. Правила описываются
+// одинаково для всех механизмов (в синтаксисе iptables), каждый переводит их сам
+type firewallBackend interface {
+	name() string
+	setup(exclusive bool) error                  // Создать свои цепочки (exclusive - сначала очистить все правила хоста)
+	teardown()                                   // Удалить свои цепочки вместе с правилами
+	list() ([]firewallRule, error)               // Правила, которые сейчас стоят в цепочках панели
+	render(rules []firewallRule) (string, error) // Сценарий загрузки полного набора правил
+	load(desired []firewallRule) error           // Заменить правила панели набором целиком, при ошибке вернуть прежние
+}
+
+// firewall текущий механизм, выбирается при запуске
+var firewall firewallBackend = iptablesBackend{}
+
+// SelectFirewall выбирает механизм по настройке (auto - определить по системе)
+func SelectFirewall(backend string) string {
+	if backend == "" || backend == database.FirewallBackendAuto {
+		backend = detectFirewallBackend()
+	}
+
+	if backend == database.FirewallBackendNFTables {
+		firewall = nftBackend{}
+	} else {
+		firewall = iptablesBackend{}
+	}
+	return firewall.name()
+}
+
+// detectFirewallBackend nftables, если iptables на хосте нет или он сам работает
+// поверх nf_tables. Если FORWARD уже закрыт политикой DROP (так делает Docker),
+// разрешение из отдельной таблицы nftables не поможет - остаемся на iptables
+func detectFirewallBackend() string {
+	if _, err := exec.LookPath("nft"); err != nil {
+		return database.FirewallBackendIPTables
+	}
+	if _, err := exec.LookPath("iptables"); err != nil {
+		return database.FirewallBackendNFTables
+	}
+
+	version, _ := exec.Command("iptables", "-V").Output()
+	if !strings.Contains(string(version), "nf_tables") {
+		return database.FirewallBackendIPTables
+	}
+
+	policy, _ := exec.Command("iptables", "-S", "FORWARD", "1").Output()
+	if strings.Contains(string(policy), "-P FORWARD DROP") {
+		return database.FirewallBackendIPTables
+	}
+	return database.FirewallBackendNFTables
+}
+
+// SetupFirewall выбирает механизм и готовит его к запуску: в режиме exclusive
+// очищает все правила хоста (как раньше), в managed только создает свои цепочки
+func SetupFirewall(mode, backend string) error {
+	SelectFirewall(backend)
+
+	if mode == database.FirewallModeExclusive {
+		log.Printf("🧨 Режим %s exclusive: все правила хоста будут сброшены", firewall.name())
+	} else {
+		log.Printf("🛡️  Режим %s managed: изменяются только правила панели", firewall.name())
+	}
+
+	// Цепочки другого механизма остались бы работать вместе с новыми
+	for _, other := range []firewallBackend{iptablesBackend{}, nftBackend{}} {
+		if other.name() != firewall.name() {
+			other.teardown()
+		}
+	}
+
+	// Содержимое цепочек не очищаем: лишние и недостающие правила исправит
+	// синхронизация, и уже работающие туннели не теряют NAT
+	if err := firewall.setup(mode == database.FirewallModeExclusive); err != nil {
+		return err
+	}
+
+	log.Println("  ✅ Цепочки панели готовы")
+	return nil
+}
+
+// RemoveFirewall удаляет цепочки панели во всех механизмах (при удалении wg_serf)
+func RemoveFirewall() {
+	iptablesBackend{}.teardown()
+	nftBackend{}.teardown()
+}
+
+// FirewallBackend имя текущего механизма
+func FirewallBackend() string {
+	return firewall.name()
+}
+
+// firewallRule правило в одной из цепочек панели. Аргументы записаны в том виде,
+// в каком их печатает iptables -S, чтобы желаемые правила можно было сравнить с текущими
+type firewallRule struct {
+	table string
+	chain string
+	args  []string
+
+	ref    string // Идентификатор из комментария правила nftables
+	handle string // Номер правила в nftables (для удаления)
+}
+
+// newRule правило панели
+func newRule(table, chain string, args ...string) firewallRule {
+	return firewallRule{table: table, chain: chain, args: args}
+}
+
+// spec правило в формате iptables -S
+func (r firewallRule) spec() string {
+	return "-A " + r.chain + " " + strings.Join(r.args, " ")
+}
+
+// key правило с таблицей - так оно показывается в отчетах
+func (r firewallRule) key() string {
+	return r.table + " " + r.spec()
+}
+
+// id по нему сравниваются желаемые и текущие правила
+func (r firewallRule) id() string {
+	if r.ref != "" {
+		return r.ref
+	}
+	sum := sha256.Sum256([]byte(r.key()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// diffRules какие правила убрать и какие добавить, с учетом повторов:
+// лишняя копия правила тоже удаляется
+func diffRules(desired, actual []firewallRule) (remove, add []firewallRule) {
+	want := make(map[string]int)
+	for _, rule := range desired {
+		want[rule.id()]++
+	}
+	have := make(map[string]int)
+	for _, rule := range actual {
+		have[rule.id()]++
+	}
+
+	for _, rule := range actual {
+		if have[rule.id()] > want[rule.id()] {
+			have[rule.id()]--
+			remove = append(remove, rule)
+		}
+	}
+
+	added := make(map[string]int)
+	for _, rule := range desired {
+		added[rule.id()]++
+		if added[rule.id()] > have[rule.id()] {
+			add = append(add, rule)
+		}
+	}
+	return remove, add
+}
+
+// misorderedChains цепочки, в которых те же правила стоят не в том порядке
+func misorderedChains(desired, actual []firewallRule) []string {
+	sequence := func(rules []firewallRule) (map[string][]string, []string) {
+		byChain := make(map[string][]string)
+		var chains []string
+		for _, rule := range rules {
+			key := rule.table + "/" + rule.chain
+			if _, ok := byChain[key]; !ok {
+				chains = append(chains, key)
+			}
+			byChain[key] = append(byChain[key], rule.id())
+		}
+		return byChain, chains
+	}
+	want, chains := sequence(desired)
+	have, _ := sequence(actual)
+
+	var result []string
+	for _, chain := range chains {
+		if strings.Join(want[chain], "\n") != strings.Join(have[chain], "\n") {
+			result = append(result, chain)
+		}
+	}
+	return result
+}
+
+// serverRules правила сервера: порт WireGuard, встроенный DNS, FORWARD интерфейса и NAT подсети
+func serverRules(server *database.Server, netInterface string) []firewallRule {
+	iface := server.Interface
+	rules := []firewallRule{
+		newRule("filter", chainInput, "-p", "udp", "-m", "udp", "--dport", fmt.Sprintf("%d", server.ListenPort), "-j", "ACCEPT"),
+	}
+	if dns.Enabled() {
+		rules = append(rules,
+			newRule("filter", chainInput, "-i", iface, "-p", "udp", "-m", "udp", "--dport", "53", "-j", "ACCEPT"),
+			newRule("filter", chainInput, "-i", iface, "-p", "tcp", "-m", "tcp", "--dport", "53", "-j", "ACCEPT"),
+		)
+	}
+	return append(rules,
+		newRule("filter", chainForward, "-i", iface, "-j", "ACCEPT"),
+		newRule("filter", chainForward, "-o", iface, "-j", "ACCEPT"),
+		newRule("nat", chainPostrouting, "-s", getNetworkFromAddress(server.Address), "-o", netInterface, "-j", "MASQUERADE"),
+	)
+}
+
+// portForwardRules правила проброса порта: DNAT с внешнего интерфейса (только с разрешенных
+// адресов, если они заданы) и разрешение в FORWARD. Hairpin: проброс работает и по публичному
+// адресу изнутри - с VPN интерфейсов и с самого сервера (DNAT в OUTPUT и MASQUERADE, чтобы
+// ответ клиента вернулся через сервер). Аргументы в порядке вывода iptables -S
+func portForwardRules(client *database.Client, pf database.PortForward, netInterface string, vpnInterfaces []string) []firewallRule {
+	protocols := []string{pf.Protocol}
+	if pf.Protocol == "both" {
+		protocols = []string{"tcp", "udp"}
+	}
+
+	start, end := forwardPorts(pf)
+	internalStart, internalEnd := forwardInternalPorts(pf)
+	external := portRange(start, end)
+	internal := portRange(internalStart, internalEnd)
+	dnat := []string{"-j", "DNAT", "--to-destination", client.Address + ":" + strings.Replace(internal, ":", "-", 1)}
+	local := []string{"-m", "addrtype", "--dst-type", "LOCAL"}
+
+	// Входящие интерфейсы: внешний и VPN (только на адреса самого сервера) или все сразу
+	type ingress struct {
+		iface string
+		match []string
+	}
+	ingresses := []ingress{{netInterface, nil}}
+	for _, iface := range vpnInterfaces {
+		ingresses = append(ingresses, ingress{iface, local})
+	}
+	if pf.AllInterfaces {
+		ingresses = []ingress{{"", local}}
+	}
+
+	sources := pf.SourceCIDRs
+	if len(sources) == 0 {
+		sources = []string{""}
+	}
+
+	var rules []firewallRule
+	for _, proto := range protocols {
+		port := []string{"-p", proto, "-m", proto, "--dport", external}
+
+		for _, source := range sources {
+			var from []string
+			if source != "" {
+				from = []string{"-s", source}
+			}
+			for _, in := range ingresses {
+				args := from
+				if in.iface != "" {
+					args = concat(args, "-i", in.iface)
+				}
+				rules = append(rules, newRule("nat", chainPrerouting, concat(concat(concat(args, port...), in.match...), dnat...)...))
+			}
+			rules = append(rules,
+				newRule("filter", chainForward, concat(from, "-d", client.Address+"/32", "-p", proto, "-m", proto, "--dport", internal, "-j", "ACCEPT")...))
+		}
+
+		rules = append(rules,
+			// Соединения самого сервера на localhost остаются локальными
+			newRule("nat", chainOutput, concat(concat(concat([]string{"!", "-d", "127.0.0.0/8"}, port...), local...), dnat...)...),
+			newRule("nat", chainPostrouting, "-d", client.Address+"/32", "-p", proto, "-m", proto, "--dport", internal,
+				"-m", "addrtype", "--src-type", "LOCAL", "-m", "conntrack", "--ctstate", "DNAT", "-j", "MASQUERADE"),
+		)
+	}
+	return rules
+}
+
+// concat аргументы правила: общий префикс и остальное
+func concat(prefix []string, args ...string) []string {
+	return append(append([]string{}, prefix...), args...)
+}
+
+// desiredRules все правила, которые должны стоять для серверов и клиентов из БД
+func desiredRules(db *database.Database) []firewallRule {
+	netInterface := database.GetDefaultInterface()
+
+	// Интерфейсы, с которых клиенты могут обращаться к пробросам по публичному адресу
+	var vpnInterfaces []string
+	for _, server := range db.Servers {
+		if server.Enabled {
+			vpnInterfaces = append(vpnInterfaces, server.Interface)
+		}
+	}
+
+	// Первый проход: политики клиентов и запреты изоляции всех серверов. Они должны
+	// стоять раньше разрешений любого интерфейса, иначе "-o wgX -j ACCEPT" одного
+	// сервера пропустит трафик клиента другого сервера мимо его политики
+	var rules []firewallRule
+	for i := range db.Servers {
+		server := &db.Servers[i]
+		if !server.Enabled {
+			continue
+		}
+		for j := range db.Clients {
+			client := &db.Clients[j]
+			if client.ServerID == server.ID && client.Enabled {
+				rules = append(rules, egressRules(client, server.Interface)...)
+			}
+		}
+		rules = append(rules, isolationRules(db, server)...)
+	}
+
+	// Второй проход: разрешения интерфейсов, NAT и пробросы портов
+	for i := range db.Servers {
+		server := &db.Servers[i]
+		if !server.Enabled {
+			continue
+		}
+		rules = append(rules, serverRules(server, netInterface)...)
+
+		for j := range db.Clients {
+			client := &db.Clients[j]
+			if client.ServerID != server.ID || !client.Enabled {
+				continue
+			}
+			for _, pf := range client.PortForwards {
+				rules = append(rules, portForwardRules(client, pf, netInterface, vpnInterfaces)...)
+			}
+		}
+	}
+	return rules
+}
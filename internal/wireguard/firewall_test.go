@@ -0,0 +1,309 @@
+package wireguard
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wg-panel/internal/database"
+)
+
+// fakeFirewallTools подменяет ip, iptables и nft: внешний интерфейс всегда eth0,
+// цепочек клиентов в системе нет
+func fakeFirewallTools(t *testing.T) {
+	t.Helper()
+	bin := t.TempDir()
+	script := "#!/bin/sh\ncase \"$(basename \"$0\") $1\" in\n\"ip route\"*) echo \"default via 192.0.2.1 dev eth0\" ;;\nesac\nexit 0\n"
+	for _, tool := range []string{"ip", "iptables", "nft"} {
+		if err := os.WriteFile(filepath.Join(bin, tool), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// multiServerDB два сервера: клиент второго может ходить только в подсеть первого,
+// у клиента первого проброс порта
+func multiServerDB() *database.Database {
+	return &database.Database{
+		Servers: []database.Server{
+			{ID: "a", Interface: "wg0", Address: "10.8.0.1/24", ListenPort: 51820, Enabled: true},
+			{ID: "b", Interface: "wg1", Address: "10.9.0.1/24", ListenPort: 51821, Enabled: true, Isolation: true},
+		},
+		Clients: []database.Client{
+			{ID: "c1", ServerID: "b", Address: "10.9.0.2", Enabled: true,
+				Egress: &database.EgressPolicy{Allow: []database.EgressRule{{CIDR: "10.8.0.0/24"}}}},
+			{ID: "c2", ServerID: "a", Address: "10.8.0.2", Enabled: true,
+				PortForwards: []database.PortForward{{Port: 8080, Protocol: "tcp"}}},
+		},
+	}
+}
+
+func ruleKeys(rules []firewallRule) []string {
+	keys := make([]string, len(rules))
+	for i, rule := range rules {
+		keys[i] = rule.key()
+	}
+	return keys
+}
+
+func TestDesiredRules(t *testing.T) {
+	fakeFirewallTools(t)
+
+	tests := []struct {
+		name   string
+		db     *database.Database
+		want   []string
+		before [][2]string // Правило [0] должно стоять раньше [1]
+	}{
+		{
+			name: "single server",
+			db: &database.Database{Servers: []database.Server{
+				{ID: "a", Interface: "wg0", Address: "10.8.0.1/24", ListenPort: 51820, Enabled: true},
+			}},
+			want: []string{
+				"filter -A WGSERF-IN -p udp -m udp --dport 51820 -j ACCEPT",
+				"filter -A WGSERF-FWD -i wg0 -j ACCEPT",
+				"filter -A WGSERF-FWD -o wg0 -j ACCEPT",
+				"nat -A WGSERF-POST -s 10.8.0.0/24 -o eth0 -j MASQUERADE",
+			},
+		},
+		{
+			name: "disabled server and client",
+			db: &database.Database{
+				Servers: []database.Server{
+					{ID: "a", Interface: "wg0", Address: "10.8.0.1/24", ListenPort: 51820, Enabled: false},
+					{ID: "b", Interface: "wg1", Address: "10.9.0.1/24", ListenPort: 51821, Enabled: true},
+				},
+				Clients: []database.Client{
+					{ID: "c1", ServerID: "b", Address: "10.9.0.2", Enabled: false,
+						Egress: &database.EgressPolicy{BlockInternet: true}},
+				},
+			},
+			want: []string{
+				"filter -A WGSERF-IN -p udp -m udp --dport 51821 -j ACCEPT",
+				"filter -A WGSERF-FWD -i wg1 -j ACCEPT",
+				"filter -A WGSERF-FWD -o wg1 -j ACCEPT",
+				"nat -A WGSERF-POST -s 10.9.0.0/24 -o eth0 -j MASQUERADE",
+			},
+		},
+		{
+			name: "multi-server egress",
+			db:   multiServerDB(),
+			want: []string{
+				// Первый проход: политики и изоляция всех серверов
+				"filter -A WGSERF-FWD -s 10.9.0.2/32 -i wg1 -j WGSERF-C-d0f631ca",
+				"filter -A WGSERF-C-d0f631ca -m conntrack --ctstate RELATED,ESTABLISHED -j RETURN",
+				"filter -A WGSERF-C-d0f631ca -d 10.8.0.0/24 -j RETURN",
+				"filter -A WGSERF-C-d0f631ca -j DROP",
+				"filter -A WGSERF-FWD -i wg1 -o wg1 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT",
+				"filter -A WGSERF-FWD -i wg1 -o wg1 -m conntrack --ctstate DNAT -j ACCEPT",
+				"filter -A WGSERF-FWD -i wg1 -o wg1 -j DROP",
+				// Второй проход: разрешения интерфейсов, NAT и пробросы
+				"filter -A WGSERF-IN -p udp -m udp --dport 51820 -j ACCEPT",
+				"filter -A WGSERF-FWD -i wg0 -j ACCEPT",
+				"filter -A WGSERF-FWD -o wg0 -j ACCEPT",
+				"nat -A WGSERF-POST -s 10.8.0.0/24 -o eth0 -j MASQUERADE",
+				"nat -A WGSERF-PRE -i eth0 -p tcp -m tcp --dport 8080 -j DNAT --to-destination 10.8.0.2:8080",
+				"nat -A WGSERF-PRE -i wg0 -p tcp -m tcp --dport 8080 -m addrtype --dst-type LOCAL -j DNAT --to-destination 10.8.0.2:8080",
+				"nat -A WGSERF-PRE -i wg1 -p tcp -m tcp --dport 8080 -m addrtype --dst-type LOCAL -j DNAT --to-destination 10.8.0.2:8080",
+				"filter -A WGSERF-FWD -d 10.8.0.2/32 -p tcp -m tcp --dport 8080 -j ACCEPT",
+				"nat -A WGSERF-OUT ! -d 127.0.0.0/8 -p tcp -m tcp --dport 8080 -m addrtype --dst-type LOCAL -j DNAT --to-destination 10.8.0.2:8080",
+				"nat -A WGSERF-POST -d 10.8.0.2/32 -p tcp -m tcp --dport 8080 -m addrtype --src-type LOCAL -m conntrack --ctstate DNAT -j MASQUERADE",
+				"filter -A WGSERF-IN -p udp -m udp --dport 51821 -j ACCEPT",
+				"filter -A WGSERF-FWD -i wg1 -j ACCEPT",
+				"filter -A WGSERF-FWD -o wg1 -j ACCEPT",
+				"nat -A WGSERF-POST -s 10.9.0.0/24 -o eth0 -j MASQUERADE",
+			},
+			// Клиент wg1 не должен уйти в wg0 по разрешению "-o wg0" раньше своей политики
+			before: [][2]string{
+				{"filter -A WGSERF-FWD -s 10.9.0.2/32 -i wg1 -j WGSERF-C-d0f631ca", "filter -A WGSERF-FWD -o wg0 -j ACCEPT"},
+				{"filter -A WGSERF-FWD -i wg1 -o wg1 -j DROP", "filter -A WGSERF-FWD -i wg1 -j ACCEPT"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ruleKeys(desiredRules(tt.db))
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("rules:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+
+			index := make(map[string]int)
+			for i, key := range got {
+				index[key] = i
+			}
+			for _, pair := range tt.before {
+				first, ok1 := index[pair[0]]
+				second, ok2 := index[pair[1]]
+				if !ok1 || !ok2 || first > second {
+					t.Errorf("%q must come before %q", pair[0], pair[1])
+				}
+			}
+		})
+	}
+}
+
+func TestIptablesRender(t *testing.T) {
+	fakeFirewallTools(t)
+
+	tests := []struct {
+		name  string
+		rules []firewallRule
+		want  string
+	}{
+		{
+			name:  "empty",
+			rules: nil,
+			want: "*filter\n" +
+				":WGSERF-IN - [0:0]\n-F WGSERF-IN\n" +
+				":WGSERF-FWD - [0:0]\n-F WGSERF-FWD\n" +
+				"COMMIT\n" +
+				"*nat\n" +
+				":WGSERF-PRE - [0:0]\n-F WGSERF-PRE\n" +
+				":WGSERF-OUT - [0:0]\n-F WGSERF-OUT\n" +
+				":WGSERF-POST - [0:0]\n-F WGSERF-POST\n" +
+				"COMMIT\n",
+		},
+		{
+			name: "client chain and both tables",
+			rules: []firewallRule{
+				newRule("filter", chainForward, "-s", "10.9.0.2/32", "-i", "wg1", "-j", "WGSERF-C-d0f631ca"),
+				newRule("filter", "WGSERF-C-d0f631ca", "-j", "DROP"),
+				newRule("nat", chainPostrouting, "-s", "10.8.0.0/24", "-o", "eth0", "-j", "MASQUERADE"),
+				newRule("filter", chainForward, "-o", "wg0", "-j", "ACCEPT"),
+			},
+			want: "*filter\n" +
+				":WGSERF-IN - [0:0]\n-F WGSERF-IN\n" +
+				":WGSERF-FWD - [0:0]\n-F WGSERF-FWD\n" +
+				":WGSERF-C-d0f631ca - [0:0]\n-F WGSERF-C-d0f631ca\n" +
+				"-A WGSERF-FWD -s 10.9.0.2/32 -i wg1 -j WGSERF-C-d0f631ca\n" +
+				"-A WGSERF-C-d0f631ca -j DROP\n" +
+				"-A WGSERF-FWD -o wg0 -j ACCEPT\n" +
+				"COMMIT\n" +
+				"*nat\n" +
+				":WGSERF-PRE - [0:0]\n-F WGSERF-PRE\n" +
+				":WGSERF-OUT - [0:0]\n-F WGSERF-OUT\n" +
+				":WGSERF-POST - [0:0]\n-F WGSERF-POST\n" +
+				"-A WGSERF-POST -s 10.8.0.0/24 -o eth0 -j MASQUERADE\n" +
+				"COMMIT\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := iptablesBackend{}.render(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("script:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNftRender(t *testing.T) {
+	fakeFirewallTools(t)
+
+	skeleton := "table ip wg_serf {\n" +
+		"\tchain input { type filter hook input priority 0; policy accept; }\n" +
+		"\tchain forward { type filter hook forward priority 0; policy accept; }\n" +
+		"\tchain prerouting { type nat hook prerouting priority -100; policy accept; }\n" +
+		"\tchain output { type nat hook output priority -100; policy accept; }\n" +
+		"\tchain postrouting { type nat hook postrouting priority 100; policy accept; }\n" +
+		"}\n"
+
+	rule := func(chain, expr string, r firewallRule) string {
+		return "add rule ip wg_serf " + chain + " " + expr + " comment \"wgserf-" + r.id() + "\"\n"
+	}
+	jump := newRule("filter", chainForward, "-s", "10.9.0.2/32", "-i", "wg1", "-j", "WGSERF-C-d0f631ca")
+	drop := newRule("filter", "WGSERF-C-d0f631ca", "-j", "DROP")
+	accept := newRule("filter", chainForward, "-o", "wg0", "-j", "ACCEPT")
+	dnat := newRule("nat", chainPrerouting, "-i", "eth0", "-p", "tcp", "-m", "tcp", "--dport", "8000:8100", "-j", "DNAT", "--to-destination", "10.8.0.2")
+	masquerade := newRule("nat", chainPostrouting, "-d", "10.8.0.2/32", "-p", "tcp", "-m", "tcp", "--dport", "8080",
+		"-m", "addrtype", "--src-type", "LOCAL", "-m", "conntrack", "--ctstate", "DNAT", "-j", "MASQUERADE")
+	output := newRule("nat", chainOutput, "!", "-d", "127.0.0.0/8", "-p", "udp", "-j", "DNAT", "--to-destination", "10.8.0.2:53")
+
+	tests := []struct {
+		name    string
+		rules   []firewallRule
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "empty",
+			rules: nil,
+			want:  skeleton + "flush table ip wg_serf\n",
+		},
+		{
+			name:  "client chain declared before flush, rules in order",
+			rules: []firewallRule{jump, drop, accept},
+			want: skeleton +
+				"add chain ip wg_serf wgserf-c-d0f631ca\n" +
+				"flush table ip wg_serf\n" +
+				rule("forward", `ip saddr 10.9.0.2/32 iifname "wg1" jump wgserf-c-d0f631ca`, jump) +
+				rule("wgserf-c-d0f631ca", "drop", drop) +
+				rule("forward", `oifname "wg0" accept`, accept),
+		},
+		{
+			name:  "nat matches and verdicts",
+			rules: []firewallRule{dnat, masquerade, output},
+			want: skeleton +
+				"flush table ip wg_serf\n" +
+				rule("prerouting", `iifname "eth0" tcp dport 8000-8100 dnat to 10.8.0.2`, dnat) +
+				rule("postrouting", "ip daddr 10.8.0.2/32 tcp dport 8080 fib saddr type local ct status dnat masquerade", masquerade) +
+				rule("output", "meta l4proto udp ip daddr != 127.0.0.0/8 dnat to 10.8.0.2:53", output),
+		},
+		{
+			name:    "unsupported match",
+			rules:   []firewallRule{newRule("filter", chainForward, "-m", "limit", "-j", "ACCEPT")},
+			wantErr: "limit",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nftBackend{}.render(tt.rules)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("script:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMisorderedChains(t *testing.T) {
+	jump := newRule("filter", chainForward, "-s", "10.9.0.2/32", "-i", "wg1", "-j", "WGSERF-C-d0f631ca")
+	accept := newRule("filter", chainForward, "-o", "wg0", "-j", "ACCEPT")
+	nat := newRule("nat", chainPostrouting, "-s", "10.8.0.0/24", "-o", "eth0", "-j", "MASQUERADE")
+
+	tests := []struct {
+		name   string
+		actual []firewallRule
+		want   []string
+	}{
+		{"same order", []firewallRule{jump, nat, accept}, nil},
+		{"other chains interleaved", []firewallRule{nat, jump, accept}, nil},
+		{"accept before policy", []firewallRule{accept, jump, nat}, []string{"filter/WGSERF-FWD"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := misorderedChains([]firewallRule{jump, accept, nat}, tt.actual)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("misordered = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
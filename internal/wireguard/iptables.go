@@ -1,10 +1,172 @@
 package wireguard
 
 import (
+	"fmt"
 	"log"
 	"os/exec"
+	"strings"
 )
 
+// iptablesBackend цепочки WGSERF-* в таблицах filter и nat с переходом из стандартных
+type iptablesBackend struct{}
+
+func (iptablesBackend) name() string { return "iptables" }
+
+func (iptablesBackend) setup(exclusive bool) error {
+	if exclusive {
+		if err := CleanIPTables(); err != nil {
+			return err
+		}
+		if err := SetupBasicIPTables(); err != nil {
+			return err
+		}
+	}
+
+	for _, chain := range firewallChains {
+		// -N падает если цепочка уже есть - это нормально
+		exec.Command("iptables", "-t", chain.table, "-N", chain.name).Run()
+
+		// Переход ставим первым, но только один раз
+		if exec.Command("iptables", "-t", chain.table, "-C", chain.hook, "-j", chain.name).Run() != nil {
+			output, err := exec.Command("iptables", "-t", chain.table, "-I", chain.hook, "1", "-j", chain.name).CombinedOutput()
+			if err != nil {
+				log.Printf("  ⚠️  Не удалось подключить %s к %s: %v (output: %s)", chain.name, chain.hook, err, string(output))
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (iptablesBackend) teardown() {
+	if _, err := exec.LookPath("iptables"); err != nil {
+		return
+	}
+	for _, chain := range firewallChains {
+		// -D удаляет один переход за раз
+		for exec.Command("iptables", "-t", chain.table, "-D", chain.hook, "-j", chain.name).Run() == nil {
+		}
+		exec.Command("iptables", "-t", chain.table, "-F", chain.name).Run()
+		exec.Command("iptables", "-t", chain.table, "-X", chain.name).Run()
+	}
+	// На цепочки клиентов ссылалась только уже удаленная WGSERF-FWD
+	for _, name := range iptablesClientChains() {
+		exec.Command("iptables", "-F", name).Run()
+		exec.Command("iptables", "-X", name).Run()
+	}
+}
+
+func (iptablesBackend) list() ([]firewallRule, error) {
+	chains := append([]firewallChain{}, firewallChains...)
+	for _, name := range iptablesClientChains() {
+		chains = append(chains, firewallChain{table: "filter", name: name})
+	}
+
+	var rules []firewallRule
+	for _, chain := range chains {
+		output, err := exec.Command("iptables", "-t", chain.table, "-S", chain.name).Output()
+		if err != nil {
+			return nil, fmt.Errorf("iptables -S %s: %v", chain.name, err)
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 3 || fields[0] != "-A" {
+				continue
+			}
+			rules = append(rules, firewallRule{table: chain.table, chain: fields[1], args: fields[2:]})
+		}
+	}
+	return rules, nil
+}
+
+// render сценарий iptables-restore --noflush: объявление цепочки панели ее очищает,
+// остальные цепочки таблицы не трогаются. Цепочки клиентов, которых нет в наборе,
+// удаляются - после очистки WGSERF-FWD на них никто не ссылается
+func (iptablesBackend) render(rules []firewallRule) (string, error) {
+	declared := make(map[string]bool)
+	var clientChains []string
+	for _, rule := range rules {
+		if strings.HasPrefix(rule.chain, clientChainPrefix) && !declared[rule.chain] {
+			declared[rule.chain] = true
+			clientChains = append(clientChains, rule.chain)
+		}
+	}
+
+	var b strings.Builder
+	for _, table := range []string{"filter", "nat"} {
+		fmt.Fprintf(&b, "*%s\n", table)
+		for _, chain := range firewallChains {
+			if chain.table == table {
+				fmt.Fprintf(&b, ":%s - [0:0]\n-F %s\n", chain.name, chain.name)
+			}
+		}
+		if table == "filter" {
+			for _, name := range clientChains {
+				fmt.Fprintf(&b, ":%s - [0:0]\n-F %s\n", name, name)
+			}
+		}
+		for _, rule := range rules {
+			if rule.table == table {
+				b.WriteString(rule.spec() + "\n")
+			}
+		}
+		if table == "filter" {
+			for _, name := range iptablesClientChains() {
+				if !declared[name] {
+					fmt.Fprintf(&b, "-F %s\n-X %s\n", name, name)
+				}
+			}
+		}
+		b.WriteString("COMMIT\n")
+	}
+	return b.String(), nil
+}
+
+// iptablesClientChains цепочки клиентов, которые сейчас есть в таблице filter
+func iptablesClientChains() []string {
+	output, err := exec.Command("iptables", "-t", "filter", "-S").Output()
+	if err != nil {
+		return nil
+	}
+	var chains []string
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "-N" && strings.HasPrefix(fields[1], clientChainPrefix) {
+			chains = append(chains, fields[1])
+		}
+	}
+	return chains
+}
+
+// load применяет набор через iptables-restore. Каждая таблица меняется атомарно,
+// но filter и nat - отдельными шагами, поэтому при ошибке возвращаем прежние правила
+func (b iptablesBackend) load(desired []firewallRule) error {
+	previous, listErr := b.list()
+
+	script, _ := b.render(desired)
+	if err := iptablesRestore(script); err != nil {
+		if listErr != nil {
+			return fmt.Errorf("%v; откат невозможен: %v", err, listErr)
+		}
+		rollback, _ := b.render(previous)
+		if rbErr := iptablesRestore(rollback); rbErr != nil {
+			return fmt.Errorf("%v; откат не удался: %v", err, rbErr)
+		}
+		return fmt.Errorf("%v (прежние правила восстановлены)", err)
+	}
+	return nil
+}
+
+// iptablesRestore загружает сценарий, не трогая цепочки вне его
+func iptablesRestore(script string) error {
+	cmd := exec.Command("iptables-restore", "--noflush")
+	cmd.Stdin = strings.NewReader(script)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("iptables-restore: %v (output: %s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
 // CleanIPTables очищает все правила iptables
 func CleanIPTables() error {
 	log.Println("🧹 Очистка iptables...")
@@ -0,0 +1,309 @@
+package wireguard
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// Своя таблица nftables. Правила описаны в синтаксисе iptables и переводятся
+// в выражения nft; в комментарии правила хранится его идентификатор, чтобы
+// сравнивать текущие правила с желаемыми без разбора вывода nft
+
+const (
+	nftFamily        = "ip"
+	nftTable         = "wg_serf"
+	nftCommentPrefix = "wgserf-"
+)
+
+// nftChain базовая цепочка таблицы для цепочки панели
+type nftChain struct {
+	chain    string // Цепочка панели (WGSERF-*)
+	table    string // Таблица iptables, к которой она относится
+	name     string
+	hook     string
+	kind     string
+	priority int
+}
+
+var nftChains = []nftChain{
+	{chainInput, "filter", "input", "input", "filter", 0},
+	{chainForward, "filter", "forward", "forward", "filter", 0},
+	{chainPrerouting, "nat", "prerouting", "prerouting", "nat", -100},
+	{chainOutput, "nat", "output", "output", "nat", -100},
+	{chainPostrouting, "nat", "postrouting", "postrouting", "nat", 100},
+}
+
+// nftBackend таблица ip wg_serf с базовыми цепочками на стандартных хуках
+type nftBackend struct{}
+
+func (nftBackend) name() string { return "nftables" }
+
+func (nftBackend) setup(exclusive bool) error {
+	if exclusive {
+		if output, err := exec.Command("nft", "flush", "ruleset").CombinedOutput(); err != nil {
+			return fmt.Errorf("nft flush ruleset: %v (output: %s)", err, strings.TrimSpace(string(output)))
+		}
+	}
+	// Объявление таблицы не трогает уже стоящие правила
+	return nftRun(nftSkeleton())
+}
+
+func (nftBackend) teardown() {
+	if _, err := exec.LookPath("nft"); err != nil {
+		return
+	}
+	exec.Command("nft", "delete", "table", nftFamily, nftTable).Run() // Таблицы может не быть
+}
+
+var (
+	nftHandleRe  = regexp.MustCompile(`\s*# handle (\d+)$`)
+	nftCommentRe = regexp.MustCompile(`\s*comment "([^"]*)"`)
+	nftChainRe   = regexp.MustCompile(`^chain (\S+) \{`)
+)
+
+func (nftBackend) list() ([]firewallRule, error) {
+	output, err := exec.Command("nft", "-a", "list", "table", nftFamily, nftTable).Output()
+	if err != nil {
+		return nil, fmt.Errorf("nft list table %s %s: %v", nftFamily, nftTable, err)
+	}
+
+	var rules []firewallRule
+	var chain *nftChain
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if match := nftChainRe.FindStringSubmatch(line); match != nil {
+			chain = nftChainByName(match[1])
+			continue
+		}
+
+		handle := nftHandleRe.FindStringSubmatch(line)
+		if chain == nil || handle == nil || strings.HasPrefix(line, "type ") {
+			continue
+		}
+		expr := nftHandleRe.ReplaceAllString(line, "")
+
+		// Правило без нашего комментария добавлено вручную - оно будет лишним
+		rule := firewallRule{table: chain.table, chain: chain.chain, handle: handle[1]}
+		if comment := nftCommentRe.FindStringSubmatch(expr); comment != nil && strings.HasPrefix(comment[1], nftCommentPrefix) {
+			rule.ref = strings.TrimPrefix(comment[1], nftCommentPrefix)
+			expr = nftCommentRe.ReplaceAllString(expr, "")
+		}
+		rule.args = strings.Fields(expr)
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// render сценарий nft -f: объявление таблицы, очистка и все правила. Цепочки
+// клиентов объявляются до правил с переходом в них, лишние удаляются после очистки.
+// Правила добавляются строго в порядке набора (как и в iptables-restore): переходы
+// в политики клиентов всех серверов стоят раньше разрешений интерфейсов
+func (nftBackend) render(rules []firewallRule) (string, error) {
+	declared := make(map[string]bool)
+	var script strings.Builder
+	script.WriteString(nftSkeleton())
+	for _, rule := range rules {
+		name := nftChainFor(rule.chain).name
+		if strings.HasPrefix(rule.chain, clientChainPrefix) && !declared[name] {
+			declared[name] = true
+			fmt.Fprintf(&script, "add chain %s %s %s\n", nftFamily, nftTable, name)
+		}
+	}
+	fmt.Fprintf(&script, "flush table %s %s\n", nftFamily, nftTable)
+	for _, name := range nftClientChains() {
+		if !declared[name] {
+			fmt.Fprintf(&script, "delete chain %s %s %s\n", nftFamily, nftTable, name)
+		}
+	}
+	for _, rule := range rules {
+		line, err := nftAddRule(rule)
+		if err != nil {
+			return "", err
+		}
+		script.WriteString(line)
+	}
+	return script.String(), nil
+}
+
+// nftClientChains цепочки клиентов, которые сейчас есть в таблице
+func nftClientChains() []string {
+	output, err := exec.Command("nft", "list", "table", nftFamily, nftTable).Output()
+	if err != nil {
+		return nil
+	}
+	var chains []string
+	for _, line := range strings.Split(string(output), "\n") {
+		match := nftChainRe.FindStringSubmatch(strings.TrimSpace(line))
+		if match != nil && strings.HasPrefix(match[1], strings.ToLower(clientChainPrefix)) {
+			chains = append(chains, match[1])
+		}
+	}
+	return chains
+}
+
+// load заменяет все правила таблицы одной транзакцией: при ошибке nft не
+// применяет ничего, и откатывать нечего
+func (b nftBackend) load(desired []firewallRule) error {
+	script, err := b.render(desired)
+	if err != nil {
+		return err
+	}
+	return nftRun(script)
+}
+
+// nftRun выполняет сценарий nft одной транзакцией
+func nftRun(script string) error {
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("nft: %v (output: %s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// nftSkeleton объявление таблицы и ее базовых цепочек
+func nftSkeleton() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "table %s %s {\n", nftFamily, nftTable)
+	for _, chain := range nftChains {
+		fmt.Fprintf(&b, "\tchain %s { type %s hook %s priority %d; policy accept; }\n", chain.name, chain.kind, chain.hook, chain.priority)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// nftAddRule команда добавления правила с идентификатором в комментарии
+func nftAddRule(rule firewallRule) (string, error) {
+	expr, err := nftExpr(rule.args)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", rule.key(), err)
+	}
+	return fmt.Sprintf("add rule %s %s %s %s comment \"%s%s\"\n",
+		nftFamily, nftTable, nftChainFor(rule.chain).name, expr, nftCommentPrefix, rule.id()), nil
+}
+
+// nftChainFor цепочка таблицы для цепочки панели (у клиентов - обычная, без хука)
+func nftChainFor(chain string) nftChain {
+	for _, c := range nftChains {
+		if c.chain == chain {
+			return c
+		}
+	}
+	return nftChain{chain: chain, table: "filter", name: strings.ToLower(chain)}
+}
+
+// nftChainByName цепочка панели по имени цепочки таблицы (nil - не наша)
+func nftChainByName(name string) *nftChain {
+	for i := range nftChains {
+		if nftChains[i].name == name {
+			return &nftChains[i]
+		}
+	}
+	if suffix := strings.TrimPrefix(name, strings.ToLower(clientChainPrefix)); suffix != name {
+		return &nftChain{chain: clientChainPrefix + suffix, table: "filter", name: name}
+	}
+	return nil
+}
+
+// nftExpr переводит аргументы iptables в выражение nft. Поддерживаются только
+// те совпадения и действия, которые использует сама панель
+func nftExpr(args []string) (string, error) {
+	var parts []string
+	proto := ""
+	portUsed := false
+	negate := false
+
+	op := func() string {
+		if negate {
+			negate = false
+			return "!= "
+		}
+		return ""
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "!" {
+			negate = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return "", fmt.Errorf("нет значения для %s", arg)
+		}
+		i++
+		value := args[i]
+
+		switch arg {
+		case "-i":
+			parts = append(parts, fmt.Sprintf("iifname %s\"%s\"", op(), value))
+		case "-o":
+			parts = append(parts, fmt.Sprintf("oifname %s\"%s\"", op(), value))
+		case "-s":
+			parts = append(parts, "ip saddr "+op()+value)
+		case "-d":
+			parts = append(parts, "ip daddr "+op()+value)
+		case "-p":
+			proto = value
+		case "-m":
+			switch value {
+			case "tcp", "udp", "conntrack", "state", "addrtype":
+			default:
+				return "", fmt.Errorf("модуль %s не поддерживается", value)
+			}
+		case "--dport":
+			if proto == "" {
+				return "", fmt.Errorf("--dport без -p")
+			}
+			parts = append(parts, fmt.Sprintf("%s dport %s%s", proto, op(), strings.Replace(value, ":", "-", 1)))
+			portUsed = true
+		case "--ctstate", "--state":
+			if value == "DNAT" || value == "SNAT" {
+				parts = append(parts, "ct status "+op()+strings.ToLower(value))
+			} else {
+				parts = append(parts, "ct state "+op()+strings.ToLower(value))
+			}
+		case "--dst-type", "--src-type":
+			field := "daddr"
+			if arg == "--src-type" {
+				field = "saddr"
+			}
+			parts = append(parts, fmt.Sprintf("fib %s type %s%s", field, op(), strings.ToLower(value)))
+		case "-j":
+			verdict, err := nftVerdict(value, args[i+1:])
+			if err != nil {
+				return "", err
+			}
+			if proto != "" && !portUsed {
+				parts = append([]string{"meta l4proto " + proto}, parts...)
+			}
+			return strings.Join(append(parts, verdict), " "), nil
+		default:
+			return "", fmt.Errorf("аргумент %s не поддерживается", arg)
+		}
+	}
+	return "", fmt.Errorf("нет действия -j")
+}
+
+// nftVerdict действие правила
+func nftVerdict(target string, rest []string) (string, error) {
+	switch target {
+	case "ACCEPT", "DROP", "RETURN":
+		return strings.ToLower(target), nil
+	case "REJECT":
+		return "reject", nil
+	case "MASQUERADE":
+		return "masquerade", nil
+	case "DNAT", "SNAT":
+		if len(rest) != 2 || (rest[0] != "--to-destination" && rest[0] != "--to-source") {
+			return "", fmt.Errorf("%s без адреса", target)
+		}
+		return fmt.Sprintf("%s to %s", strings.ToLower(target), rest[1]), nil
+	default:
+		if strings.HasPrefix(target, clientChainPrefix) {
+			return "jump " + nftChainFor(target).name, nil
+		}
+		return "", fmt.Errorf("действие %s не поддерживается", target)
+	}
+}
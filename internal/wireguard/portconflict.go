@@ -0,0 +1,173 @@
+package wireguard
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"wg-panel/internal/database"
+)
+
+// Проверка внешних портов проброса: DNAT перехватывает трафик раньше локальных
+// сервисов, поэтому проброс 22 отрезал бы SSH, а проброс порта WireGuard - сам VPN
+
+// ReservedPort порты, которые нельзя пробрасывать
+type ReservedPort struct {
+	Start    int
+	End      int
+	Protocol string // tcp, udp или both
+	Reason   string
+}
+
+// defaultReservedPorts зарезервированы всегда
+var defaultReservedPorts = []ReservedPort{
+	{22, 22, "tcp", "SSH"},
+}
+
+// ParseReservedPort разбирает запись списка запрета: 25, 135-139/tcp, 53/udp
+func ParseReservedPort(spec, reason string) (ReservedPort, error) {
+	reserved := ReservedPort{Protocol: "both", Reason: reason}
+
+	ports, protocol, hasProtocol := strings.Cut(strings.TrimSpace(spec), "/")
+	if hasProtocol {
+		if protocol != "tcp" && protocol != "udp" && protocol != "both" {
+			return reserved, fmt.Errorf("неверный протокол в %q", spec)
+		}
+		reserved.Protocol = protocol
+	}
+
+	start, end, isRange := strings.Cut(ports, "-")
+	var err error
+	if reserved.Start, err = strconv.Atoi(start); err != nil {
+		return reserved, fmt.Errorf("неверный порт в %q", spec)
+	}
+	reserved.End = reserved.Start
+	if isRange {
+		if reserved.End, err = strconv.Atoi(end); err != nil || reserved.End < reserved.Start {
+			return reserved, fmt.Errorf("неверный диапазон в %q", spec)
+		}
+	}
+	return reserved, nil
+}
+
+// protocolsOverlap пересекаются ли протоколы (both - оба)
+func protocolsOverlap(a, b string) bool {
+	return a == "both" || b == "both" || a == b
+}
+
+// checkForwardConflicts проверяет внешние порты проброса: другие пробросы, порты
+// серверов WireGuard, зарезервированные порты и сокеты, которые слушает хост
+func checkForwardConflicts(db *database.Database, pf database.PortForward, reserved []ReservedPort) error {
+	start, end := forwardPorts(pf)
+	overlaps := func(otherStart, otherEnd int) bool {
+		return otherStart <= end && otherEnd >= start
+	}
+
+	for _, client := range db.Clients {
+		for _, other := range client.PortForwards {
+			otherStart, otherEnd := forwardPorts(other)
+			if overlaps(otherStart, otherEnd) && protocolsOverlap(other.Protocol, pf.Protocol) {
+				return fmt.Errorf("порт %s/%s уже проброшен на клиента %s", portRange(otherStart, otherEnd), other.Protocol, client.Name)
+			}
+		}
+	}
+
+	if protocolsOverlap(pf.Protocol, "udp") {
+		for _, server := range db.Servers {
+			if overlaps(server.ListenPort, server.ListenPort) {
+				return fmt.Errorf("порт %d/udp занят WireGuard сервером %s", server.ListenPort, server.Name)
+			}
+		}
+	}
+
+	for _, r := range append(append([]ReservedPort{}, defaultReservedPorts...), reserved...) {
+		if overlaps(r.Start, r.End) && protocolsOverlap(r.Protocol, pf.Protocol) {
+			return fmt.Errorf("порт %s/%s зарезервирован: %s", portRange(r.Start, r.End), r.Protocol, r.Reason)
+		}
+	}
+
+	// Встроенный DNS слушает адреса серверов в туннеле - снаружи его не видно
+	tunnel := make(map[string]bool)
+	for _, server := range db.Servers {
+		if ip, _, err := net.ParseCIDR(server.Address); err == nil {
+			tunnel[ip.String()] = true
+		}
+	}
+
+	for _, proto := range []string{"tcp", "udp"} {
+		if !protocolsOverlap(pf.Protocol, proto) {
+			continue
+		}
+		listening := hostListeners(proto, tunnel)
+		for port := start; port <= end; port++ {
+			if listening[port] {
+				return fmt.Errorf("порт %d/%s уже слушает сервис на этом сервере", port, proto)
+			}
+		}
+	}
+
+	return nil
+}
+
+// procNetDir каталог таблиц сокетов (переменная, чтобы тесты могли подменить)
+var procNetDir = "/proc/net"
+
+// hostListeners порты, которые хост слушает не только на loopback и адресах
+// туннелей (из /proc/net)
+func hostListeners(proto string, tunnel map[string]bool) map[int]bool {
+	// TCP_LISTEN для tcp, несвязанные сокеты (TCP_CLOSE) для udp
+	state := "0A"
+	if proto == "udp" {
+		state = "07"
+	}
+
+	ports := make(map[int]bool)
+	for _, path := range []string{filepath.Join(procNetDir, proto), filepath.Join(procNetDir, proto+"6")} {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Scan() // Заголовок
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 4 || fields[3] != state {
+				continue
+			}
+			addr, portHex, ok := strings.Cut(fields[1], ":")
+			if !ok {
+				continue
+			}
+			port, err := strconv.ParseInt(portHex, 16, 32)
+			if err != nil {
+				continue
+			}
+			// Сервис только на 127.0.0.1 или адресе туннеля снаружи не виден, и проброс ему не мешает
+			if ip := procNetIP(addr); ip != nil && (ip.IsLoopback() || tunnel[ip.String()]) {
+				continue
+			}
+			ports[int(port)] = true
+		}
+		file.Close()
+	}
+	return ports
+}
+
+// procNetIP адрес из /proc/net: слова по 4 байта в порядке байт хоста (little-endian)
+func procNetIP(value string) net.IP {
+	raw, err := hex.DecodeString(value)
+	if err != nil || len(raw)%4 != 0 {
+		return nil
+	}
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+	return ip
+}
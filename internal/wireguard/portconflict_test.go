@@ -0,0 +1,107 @@
+package wireguard
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wg-panel/internal/database"
+)
+
+const procHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+// procLine строка таблицы сокетов /proc/net
+func procLine(local, state string) string {
+	return "   0: " + local + " 00000000:0000 " + state + " 00000000:00000000 00:00000000 00000000     0        0 12345 2 0000000000000000 0\n"
+}
+
+// fakeProcNet таблицы сокетов: DNS на адресе туннеля, loopback и публичные сервисы
+func fakeProcNet(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"tcp": procLine("0100090A:0035", "0A") + // 10.9.0.1:53 - встроенный DNS
+			procLine("00000000:0050", "0A") + // 0.0.0.0:80
+			procLine("00000000:1F90", "01"), // 0.0.0.0:8080 - установленное соединение, не слушатель
+		"udp": procLine("0100090A:0035", "07") + // 10.9.0.1:53
+			procLine("0100007F:14E9", "07") + // 127.0.0.1:5353
+			procLine("057100CB:04AA", "07"), // 203.0.113.5:1194
+		"udp6": procLine("00000000000000000000000000000000:1388", "07") + // [::]:5000
+			procLine("00000000000000000000000001000000:1770", "07"), // [::1]:6000
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(procHeader+body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previous := procNetDir
+	procNetDir = dir
+	t.Cleanup(func() { procNetDir = previous })
+}
+
+func TestHostListeners(t *testing.T) {
+	fakeProcNet(t)
+	tunnel := map[string]bool{"10.9.0.1": true}
+
+	tests := []struct {
+		proto string
+		want  []int
+	}{
+		{"tcp", []int{80}},
+		{"udp", []int{1194, 5000}},
+	}
+	for _, tt := range tests {
+		got := hostListeners(tt.proto, tunnel)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s listeners = %v, want %v", tt.proto, got, tt.want)
+		}
+		for _, port := range tt.want {
+			if !got[port] {
+				t.Errorf("%s listeners = %v, want %v", tt.proto, got, tt.want)
+			}
+		}
+	}
+
+	// Без адресов туннелей DNS на 10.9.0.1 считается занятым портом
+	if !hostListeners("udp", nil)[53] {
+		t.Error("listener on a non-tunnel address is skipped")
+	}
+}
+
+func TestCheckForwardConflicts(t *testing.T) {
+	fakeProcNet(t)
+	db := &database.Database{
+		Servers: []database.Server{{ID: "s1", Name: "office", Address: "10.9.0.1/24", ListenPort: 51820}},
+		Clients: []database.Client{{Name: "nas", PortForwards: []database.PortForward{{Port: 9000, PortEnd: 9010, Protocol: "tcp"}}}},
+	}
+
+	tests := []struct {
+		pf   database.PortForward
+		want string // Часть ошибки, пусто - проброс разрешен
+	}{
+		{database.PortForward{Port: 53, Protocol: "both"}, ""},
+		{database.PortForward{Port: 5353, Protocol: "udp"}, ""},
+		{database.PortForward{Port: 8080, Protocol: "tcp"}, ""},
+		{database.PortForward{Port: 9005, Protocol: "udp"}, ""},
+		{database.PortForward{Port: 80, Protocol: "tcp"}, "уже слушает"},
+		{database.PortForward{Port: 1190, PortEnd: 1199, Protocol: "udp"}, "1194/udp уже слушает"},
+		{database.PortForward{Port: 5000, Protocol: "both"}, "5000/udp уже слушает"},
+		{database.PortForward{Port: 9010, Protocol: "both"}, "уже проброшен"},
+		{database.PortForward{Port: 51820, Protocol: "udp"}, "WireGuard"},
+		{database.PortForward{Port: 22, Protocol: "tcp"}, "SSH"},
+		{database.PortForward{Port: 25, Protocol: "tcp"}, "почта"},
+	}
+	reserved := []ReservedPort{{25, 25, "tcp", "почта"}}
+
+	for _, tt := range tests {
+		err := checkForwardConflicts(db, tt.pf, reserved)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%+v: unexpected error %v", tt.pf, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%+v: err = %v, want %q", tt.pf, err, tt.want)
+		}
+	}
+}
@@ -3,58 +3,114 @@ package wireguard
 import (
 	"fmt"
 	"log"
-	"os/exec"
+	"net"
+	"strings"
 
 	"wg-panel/internal/database"
 )
 
-// isPortAvailable проверяет доступен ли порт
-func isPortAvailable(db *database.Database, port int, protocol string) bool {
-	protocols := []string{protocol}
-	if protocol == "both" {
-		protocols = []string{"tcp", "udp", "both"}
+// forwardPorts внешний диапазон проброса (для одного порта начало и конец совпадают)
+func forwardPorts(pf database.PortForward) (int, int) {
+	if pf.PortEnd > pf.Port {
+		return pf.Port, pf.PortEnd
 	}
+	return pf.Port, pf.Port
+}
 
-	for _, client := range db.Clients {
-		for _, pf := range client.PortForwards {
-			if pf.Port == port {
-				// Проверяем конфликт протоколов
-				if pf.Protocol == "both" || protocol == "both" {
-					return false
-				}
-				for _, p := range protocols {
-					if pf.Protocol == p {
-						return false
-					}
-				}
-			}
-		}
+// forwardInternalPorts диапазон портов на клиенте
+func forwardInternalPorts(pf database.PortForward) (int, int) {
+	start, end := forwardPorts(pf)
+	if pf.InternalPort == 0 {
+		return start, end
 	}
-	return true
+	return pf.InternalPort, pf.InternalPort + end - start
 }
 
-// AddPortForward добавляет проброс порта для клиента
-func AddPortForward(db *database.Database, client *database.Client, port int, protocol, description string) error {
-	// Проверяем что порт свободен
-	if !isPortAvailable(db, port, protocol) {
-		return fmt.Errorf("порт %d/%s уже используется", port, protocol)
+// portRange порт или диапазон в синтаксисе iptables (8000:8010)
+func portRange(start, end int) string {
+	if start == end {
+		return fmt.Sprintf("%d", start)
 	}
+	return fmt.Sprintf("%d:%d", start, end)
+}
 
-	// Добавляем в список
-	portForward := database.PortForward{
-		Port:        port,
-		Protocol:    protocol,
-		Description: description,
+// ValidatePortForward проверяет порты и приводит список источников к виду iptables
+func ValidatePortForward(pf *database.PortForward) error {
+	if pf.Protocol != "tcp" && pf.Protocol != "udp" && pf.Protocol != "both" {
+		return fmt.Errorf("Protocol must be tcp, udp or both")
+	}
+	if pf.Port < 1 || pf.Port > 65535 {
+		return fmt.Errorf("Port must be between 1 and 65535")
+	}
+	if pf.PortEnd == pf.Port {
+		pf.PortEnd = 0
+	}
+	if pf.PortEnd != 0 && (pf.PortEnd < pf.Port || pf.PortEnd > 65535) {
+		return fmt.Errorf("Port range end must be between %d and 65535", pf.Port)
+	}
+	if pf.InternalPort == pf.Port {
+		pf.InternalPort = 0
+	}
+	if pf.InternalPort != 0 {
+		// DNAT диапазона сохраняет порт, сдвиг диапазона не поддерживается
+		if pf.PortEnd != 0 {
+			return fmt.Errorf("A port range is forwarded to the same ports on the client")
+		}
+		if pf.InternalPort < 1 || pf.InternalPort > 65535 {
+			return fmt.Errorf("Internal port must be between 1 and 65535")
+		}
 	}
-	client.PortForwards = append(client.PortForwards, portForward)
 
-	// Применяем правила iptables если клиент активен
-	if client.Enabled {
-		if err := applyPortForwardRules(client, portForward); err != nil {
-			return err
+	sources := []string{}
+	for _, cidr := range pf.SourceCIDRs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		network, err := normalizeCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("Invalid source CIDR %q", cidr)
 		}
+		// 0.0.0.0/0 - это и есть отсутствие ограничения
+		if network == "0.0.0.0/0" {
+			sources = nil
+			break
+		}
+		sources = append(sources, network)
+	}
+	pf.SourceCIDRs = sources
+	if len(pf.SourceCIDRs) == 0 {
+		pf.SourceCIDRs = nil
+	}
+	return nil
+}
+
+// normalizeCIDR IPv4 сеть в каноническом виде (адрес без маски - /32)
+func normalizeCIDR(cidr string) (string, error) {
+	if !strings.Contains(cidr, "/") {
+		cidr += "/32"
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil || network.IP.To4() == nil {
+		return "", fmt.Errorf("invalid CIDR %q", cidr)
+	}
+	return network.String(), nil
+}
+
+// AddPortForward добавляет проверенный ValidatePortForward проброс порта
+// для клиента (правила ставит ApplyFirewall)
+func AddPortForward(db *database.Database, client *database.Client, pf database.PortForward, reserved []ReservedPort) error {
+	// Проверяем что порт свободен
+	if err := checkForwardConflicts(db, pf, reserved); err != nil {
+		return err
 	}
 
+	client.PortForwards = append(client.PortForwards, pf)
+
+	internalStart, internalEnd := forwardInternalPorts(pf)
+	start, end := forwardPorts(pf)
+	log.Printf("    🔀 Проброс порта %s (%s) -> %s:%s", portRange(start, end), pf.Protocol, client.Address, portRange(internalStart, internalEnd))
+
 	return nil
 }
 
@@ -69,17 +125,10 @@ func updatePortForward(client *database.Client, port int, protocol, newDescripti
 	return fmt.Errorf("проброс порта не найден")
 }
 
-// RemovePortForward удаляет проброс порта
+// RemovePortForward удаляет проброс порта (правила убирает ApplyFirewall)
 func RemovePortForward(client *database.Client, port int, protocol string) error {
-	// Находим и удаляем проброс
 	for i, pf := range client.PortForwards {
 		if pf.Port == port && pf.Protocol == protocol {
-			// Удаляем правила iptables
-			if client.Enabled {
-				removePortForwardRules(client, pf)
-			}
-
-			// Удаляем из списка
 			client.PortForwards = append(client.PortForwards[:i], client.PortForwards[i+1:]...)
 			return nil
 		}
@@ -87,91 +136,3 @@ func RemovePortForward(client *database.Client, port int, protocol string) error
 
 	return fmt.Errorf("проброс порта не найден")
 }
-
-// applyPortForwardRules применяет правила iptables для проброса порта
-func applyPortForwardRules(client *database.Client, pf database.PortForward) error {
-	log.Printf("    🔀 Применяю проброс порта %d (%s)", pf.Port, pf.Protocol)
-
-	netInterface := database.GetDefaultInterface()
-
-	protocols := []string{pf.Protocol}
-	if pf.Protocol == "both" {
-		protocols = []string{"tcp", "udp"}
-	}
-
-	for _, proto := range protocols {
-		commands := [][]string{
-			// DNAT только для пакетов приходящих с внешнего интерфейса
-			{"iptables", "-t", "nat", "-A", "PREROUTING", "-i", netInterface, "-p", proto,
-				"--dport", fmt.Sprintf("%d", pf.Port), "-j", "DNAT",
-				"--to-destination", fmt.Sprintf("%s:%d", client.Address, pf.Port)},
-
-			// Разрешаем FORWARD для этого порта
-			{"iptables", "-I", "FORWARD", "1", "-p", proto, "-d", client.Address,
-				"--dport", fmt.Sprintf("%d", pf.Port), "-j", "ACCEPT"},
-		}
-
-		for _, cmdArgs := range commands {
-			cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
-			output, err := cmd.CombinedOutput()
-			if err != nil {
-				log.Printf("    ⚠️  Ошибка: %v (output: %s)", err, string(output))
-				return err
-			}
-		}
-	}
-
-	log.Printf("    ✅ Проброс порта настроен")
-	return nil
-}
-
-// removePortForwardRules удаляет правила iptables для проброса порта
-func removePortForwardRules(client *database.Client, pf database.PortForward) error {
-	netInterface := database.GetDefaultInterface()
-
-	protocols := []string{pf.Protocol}
-	if pf.Protocol == "both" {
-		protocols = []string{"tcp", "udp"}
-	}
-
-	for _, proto := range protocols {
-		commands := [][]string{
-			{"iptables", "-t", "nat", "-D", "PREROUTING", "-i", netInterface, "-p", proto,
-				"--dport", fmt.Sprintf("%d", pf.Port), "-j", "DNAT",
-				"--to-destination", fmt.Sprintf("%s:%d", client.Address, pf.Port)},
-
-			{"iptables", "-D", "FORWARD", "-p", proto, "-d", client.Address,
-				"--dport", fmt.Sprintf("%d", pf.Port), "-j", "ACCEPT"},
-		}
-
-		for _, cmdArgs := range commands {
-			cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
-			cmd.Run() // Игнорируем ошибки при удалении
-		}
-	}
-
-	return nil
-}
-
-// ApplyAllPortForwards применяет все пробросы портов для клиента
-func ApplyAllPortForwards(client *database.Client) error {
-	if !client.Enabled {
-		return nil
-	}
-
-	for _, pf := range client.PortForwards {
-		if err := applyPortForwardRules(client, pf); err != nil {
-			log.Printf("    ⚠️  Ошибка проброса порта %d: %v", pf.Port, err)
-		}
-	}
-
-	return nil
-}
-
-// removeAllPortForwards удаляет все пробросы портов для клиента
-func removeAllPortForwards(client *database.Client) error {
-	for _, pf := range client.PortForwards {
-		removePortForwardRules(client, pf)
-	}
-	return nil
-}
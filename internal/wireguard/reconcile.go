@@ -0,0 +1,279 @@
+package wireguard
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"wg-panel/internal/database"
+)
+
+// Синхронизация по разнице: желаемое состояние (БД) сравнивается с текущим
+// (конфиги, запущенные интерфейсы, peers, правила в цепочках WGSERF-*) и
+// применяются только нужные изменения - работающие туннели не рвутся
+
+// Действия синхронизации
+const (
+	actionConfigWrite      = "config_write"      // Перезаписать конфиг wg-quick
+	actionInterfaceUp      = "interface_up"      // Запустить интерфейс
+	actionInterfaceDown    = "interface_down"    // Остановить интерфейс
+	actionInterfaceRestart = "interface_restart" // Перезапустить (сменился адрес)
+	actionInterfaceUpdate  = "interface_update"  // Сменить ключ или порт без перезапуска
+	actionPeerAdd          = "peer_add"
+	actionPeerRemove       = "peer_remove"
+	actionPeerUpdate       = "peer_update" // AllowedIPs или preshared ключ
+	actionRuleAdd          = "rule_add"
+	actionRuleRemove       = "rule_remove"
+	actionRuleOrder        = "rule_order" // Правила на месте, но в неверном порядке
+)
+
+// ReconcileChange одно изменение
+type ReconcileChange struct {
+	Action    string `json:"action"`
+	Interface string `json:"interface,omitempty"`
+	Target    string `json:"target"` // Путь конфига, публичный ключ peer или правило (в синтаксисе iptables)
+	Detail    string `json:"detail,omitempty"`
+	Error     string `json:"error,omitempty"`
+
+	apply func() error
+}
+
+// ReconcileReport результат синхронизации
+type ReconcileReport struct {
+	DryRun  bool              `json:"dry_run"`
+	Changes []ReconcileChange `json:"changes"`
+	Applied int               `json:"applied"`
+	Failed  int               `json:"failed"`
+	Errors  []string          `json:"errors"` // Не удалось прочитать текущее состояние
+}
+
+// Reconcile сравнивает БД с системой и применяет разницу (dryRun - только отчет)
+func Reconcile(db *database.Database, dryRun bool) *ReconcileReport {
+	report := &ReconcileReport{DryRun: dryRun, Changes: []ReconcileChange{}, Errors: []string{}}
+
+	running := make(map[string]bool)
+	for _, iface := range runningInterfaces() {
+		running[iface] = true
+	}
+
+	for i := range db.Servers {
+		report.Changes = append(report.Changes, planServer(db, &db.Servers[i], running[db.Servers[i].Interface])...)
+	}
+
+	ruleChanges, err := planRules(db)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	}
+	report.Changes = append(report.Changes, ruleChanges...)
+
+	if dryRun {
+		return report
+	}
+
+	for i := range report.Changes {
+		change := &report.Changes[i]
+		if err := change.apply(); err != nil {
+			change.Error = err.Error()
+			report.Failed++
+			continue
+		}
+		report.Applied++
+	}
+
+	return report
+}
+
+// planServer изменения интерфейса, его конфига и peers
+func planServer(db *database.Database, server *database.Server, running bool) []ReconcileChange {
+	var changes []ReconcileChange
+	iface := server.Interface
+
+	// Конфиг нужен и остановленному серверу - его запустит ToggleServer
+	path := serverConfigPath(server)
+	content := serverConfigContent(server, db)
+	if current, err := os.ReadFile(path); err != nil || string(current) != content {
+		changes = append(changes, ReconcileChange{
+			Action: actionConfigWrite, Interface: iface, Target: path,
+			apply: func() error { return os.WriteFile(path, []byte(content), 0600) },
+		})
+	}
+
+	if !server.Enabled {
+		if running {
+			changes = append(changes, ReconcileChange{
+				Action: actionInterfaceDown, Interface: iface, Target: iface,
+				apply: func() error { return wgQuick("down", iface) },
+			})
+		}
+		return changes
+	}
+
+	// Запуск из конфига сразу поднимает всех peers
+	if !running {
+		changes = append(changes, ReconcileChange{
+			Action: actionInterfaceUp, Interface: iface, Target: iface,
+			// Ошибка только попадает в отчет: сервер остается включенным в БД,
+			// и следующая проверка попробует поднять интерфейс снова
+			apply: func() error {
+				database.EnableIPForwarding()
+				return wgQuick("up", iface)
+			},
+		})
+		return changes
+	}
+
+	dump := readInterfaceDump(iface)
+	if dump == nil {
+		return changes
+	}
+
+	// Адрес меняется только перезапуском
+	if !containsString(dump.Addresses, server.Address) {
+		changes = append(changes, ReconcileChange{
+			Action: actionInterfaceRestart, Interface: iface, Target: iface,
+			Detail: fmt.Sprintf("адрес %s -> %s", strings.Join(dump.Addresses, ", "), server.Address),
+			apply: func() error {
+				wgQuick("down", iface)
+				return wgQuick("up", iface)
+			},
+		})
+		return changes
+	}
+
+	if dump.PrivateKey != server.PrivateKey || dump.ListenPort != server.ListenPort {
+		detail := "приватный ключ"
+		if dump.ListenPort != server.ListenPort {
+			detail = fmt.Sprintf("порт %d -> %d", dump.ListenPort, server.ListenPort)
+		}
+		changes = append(changes, ReconcileChange{
+			Action: actionInterfaceUpdate, Interface: iface, Target: iface, Detail: detail,
+			apply: func() error {
+				cmd := exec.Command("wg", "set", iface, "listen-port", fmt.Sprintf("%d", server.ListenPort), "private-key", "/dev/stdin")
+				cmd.Stdin = strings.NewReader(server.PrivateKey)
+				if output, err := cmd.CombinedOutput(); err != nil {
+					return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+				}
+				return nil
+			},
+		})
+	}
+
+	return append(changes, planPeers(db, server, dump.Peers)...)
+}
+
+// planPeers разница peers интерфейса
+func planPeers(db *database.Database, server *database.Server, actual []wgQuickPeer) []ReconcileChange {
+	var changes []ReconcileChange
+	iface := server.Interface
+
+	desired := make(map[string]database.Client)
+	for _, client := range db.Clients {
+		if client.ServerID == server.ID && client.Enabled {
+			desired[client.PublicKey] = client
+		}
+	}
+
+	current := make(map[string]wgQuickPeer)
+	for _, peer := range actual {
+		current[peer.PublicKey] = peer
+
+		if _, ok := desired[peer.PublicKey]; !ok {
+			publicKey := peer.PublicKey
+			changes = append(changes, ReconcileChange{
+				Action: actionPeerRemove, Interface: iface, Target: publicKey,
+				Detail: strings.Join(peer.AllowedIPs, ", "),
+				apply: func() error {
+					return removePeerFromWireGuard(server, database.Client{PublicKey: publicKey})
+				},
+			})
+		}
+	}
+
+	for _, client := range db.Clients {
+		if _, ok := desired[client.PublicKey]; !ok || client.ServerID != server.ID {
+			continue
+		}
+		client := client
+
+		peer, exists := current[client.PublicKey]
+		switch {
+		case !exists:
+			changes = append(changes, ReconcileChange{
+				Action: actionPeerAdd, Interface: iface, Target: client.PublicKey, Detail: client.Name,
+				apply: func() error { return addPeerToWireGuard(server, client) },
+			})
+		case strings.Join(peer.AllowedIPs, ",") != client.Address+"/32":
+			changes = append(changes, ReconcileChange{
+				Action: actionPeerUpdate, Interface: iface, Target: client.PublicKey,
+				Detail: fmt.Sprintf("%s: %s -> %s/32", client.Name, strings.Join(peer.AllowedIPs, ", "), client.Address),
+				apply:  func() error { return addPeerToWireGuard(server, client) },
+			})
+		case peer.PresharedKey != client.PresharedKey:
+			changes = append(changes, ReconcileChange{
+				Action: actionPeerUpdate, Interface: iface, Target: client.PublicKey,
+				Detail: client.Name + ": preshared ключ",
+				apply:  func() error { return addPeerToWireGuard(server, client) },
+			})
+		}
+	}
+
+	return changes
+}
+
+// planRules разница правил в цепочках панели
+func planRules(db *database.Database) ([]ReconcileChange, error) {
+	desired := desiredRules(db)
+	actual, err := firewall.list()
+	if err != nil {
+		return nil, err
+	}
+
+	// Все правила применяются одной загрузкой (в nftables - одной транзакцией),
+	// поэтому у изменений общий результат
+	var loaded bool
+	var loadErr error
+	load := func() error {
+		if !loaded {
+			loaded = true
+			loadErr = firewall.load(desired)
+		}
+		return loadErr
+	}
+
+	var changes []ReconcileChange
+	remove, add := diffRules(desired, actual)
+	for _, rule := range remove {
+		changes = append(changes, ReconcileChange{Action: actionRuleRemove, Target: rule.key(), apply: load})
+	}
+	for _, rule := range add {
+		changes = append(changes, ReconcileChange{Action: actionRuleAdd, Target: rule.key(), apply: load})
+	}
+	// Порядок важен: политики клиентов должны стоять раньше разрешений интерфейсов
+	if len(changes) == 0 {
+		for _, chain := range misorderedChains(desired, actual) {
+			changes = append(changes, ReconcileChange{Action: actionRuleOrder, Target: chain, apply: load})
+		}
+	}
+
+	return changes, nil
+}
+
+// wgQuick запускает или останавливает интерфейс
+func wgQuick(action, iface string) error {
+	output, err := exec.Command("wg-quick", action, iface).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("wg-quick %s %s: %v, output: %s", action, iface, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// containsString проверяет наличие строки в списке
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -14,24 +15,48 @@ import (
 
 // UpdateServerConfig обновляет конфиг файл сервера
 func UpdateServerConfig(server *database.Server, db *database.Database) error {
-	configContent := fmt.Sprintf(`[Interface]
-PrivateKey = %s
-Address = %s
-ListenPort = %d
-PostUp = %s
-PostDown = %s
-`, server.PrivateKey, server.Address, server.ListenPort, server.PostUp, server.PostDown)
+	return os.WriteFile(serverConfigPath(server), []byte(serverConfigContent(server, db)), 0600)
+}
+
+// serverConfigPath путь к конфигу wg-quick сервера
+func serverConfigPath(server *database.Server) string {
+	return filepath.Join(ConfigDir, server.Interface+".conf")
+}
+
+// serverConfigContent конфиг wg-quick сервера со всеми включенными клиентами
+func serverConfigContent(server *database.Server, db *database.Database) string {
+	configContent := interfaceSection(server)
 
 	// Добавляем всех клиентов
 	for _, client := range db.Clients {
 		if client.ServerID == server.ID && client.Enabled {
 			configContent += fmt.Sprintf("\n[Peer]\nPublicKey = %s\nAllowedIPs = %s/32\n",
 				client.PublicKey, client.Address)
+			if client.PresharedKey != "" {
+				configContent += fmt.Sprintf("PresharedKey = %s\n", client.PresharedKey)
+			}
 		}
 	}
 
-	configPath := fmt.Sprintf("/etc/wireguard/%s.conf", server.Interface)
-	return os.WriteFile(configPath, []byte(configContent), 0600)
+	return configContent
+}
+
+// interfaceSection секция [Interface] конфига сервера (PostUp/PostDown только если заданы,
+// например у перенесенных серверов)
+func interfaceSection(server *database.Server) string {
+	section := fmt.Sprintf(`[Interface]
+PrivateKey = %s
+Address = %s
+ListenPort = %d
+`, server.PrivateKey, server.Address, server.ListenPort)
+
+	if server.PostUp != "" {
+		section += fmt.Sprintf("PostUp = %s\n", server.PostUp)
+	}
+	if server.PostDown != "" {
+		section += fmt.Sprintf("PostDown = %s\n", server.PostDown)
+	}
+	return section
 }
 
 // CreateServer создает новый WireGuard сервер
@@ -43,11 +68,7 @@ func CreateServer(db *database.Database, name, address string, port int, dns str
 	}
 
 	// Определяем имя интерфейса
-	interfaceName := fmt.Sprintf("wg%d", len(db.Servers))
-
-	// Получаем основной сетевой интерфейс
-	netInterface := database.GetDefaultInterface()
-	log.Printf("📡 Определен сетевой интерфейс для NAT: %s", netInterface)
+	interfaceName := nextInterfaceName(db)
 
 	// Создаем сервер
 	server := database.Server{
@@ -61,9 +82,7 @@ func CreateServer(db *database.Database, name, address string, port int, dns str
 		DNS:          dns,
 		Enabled:      true, // Запускаем сразу
 		CreatedAt:    time.Now(),
-		PostUp:       fmt.Sprintf("iptables -I FORWARD 1 -i %%i -j ACCEPT; iptables -I FORWARD 1 -o %%i -j ACCEPT; iptables -t nat -A POSTROUTING -o %s -j MASQUERADE", netInterface),
-		PostDown:     fmt.Sprintf("iptables -D FORWARD -i %%i -j ACCEPT; iptables -D FORWARD -o %%i -j ACCEPT; iptables -t nat -D POSTROUTING -o %s -j MASQUERADE", netInterface),
-		NextClientIP: 2,
+		NextClientIP: 2, // Правила iptables ставит панель в свои цепочки, PostUp не нужен
 	}
 
 	// Включаем IP forwarding
@@ -76,15 +95,9 @@ func CreateServer(db *database.Database, name, address string, port int, dns str
 
 	// Создаем конфиг файл
 	log.Printf("📝 Создаю конфиг %s...", interfaceName)
-	configContent := fmt.Sprintf(`[Interface]
-PrivateKey = %s
-Address = %s
-ListenPort = %d
-PostUp = %s
-PostDown = %s
-`, server.PrivateKey, server.Address, server.ListenPort, server.PostUp, server.PostDown)
+	configContent := interfaceSection(&server)
 
-	configPath := fmt.Sprintf("/etc/wireguard/%s.conf", interfaceName)
+	configPath := filepath.Join(ConfigDir, interfaceName+".conf")
 	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
 		return nil, err
 	}
@@ -99,10 +112,38 @@ PostDown = %s
 	}
 	log.Printf("✅ Интерфейс %s запущен", interfaceName)
 
+	database.RegisterInterface(db, interfaceName)
+
 	return &server, nil
 }
 
-// ToggleServer включает/выключает сервер
+// nextInterfaceName возвращает свободное имя wgN (не занятое БД и интерфейсами вне панели)
+func nextInterfaceName(db *database.Database) string {
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("wg%d", i)
+
+		taken := false
+		for _, server := range db.Servers {
+			if server.Interface == name {
+				taken = true
+				break
+			}
+		}
+		if taken {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(ConfigDir, name+".conf")); err == nil {
+			continue
+		}
+		if _, err := os.Stat("/sys/class/net/" + name); err == nil {
+			continue
+		}
+		return name
+	}
+}
+
+// ToggleServer включает/выключает сервер (правила firewall ставит ApplyFirewall)
 func ToggleServer(server *database.Server) error {
 	if server.Enabled {
 		// Выключаем
@@ -133,7 +174,7 @@ func DeleteServer(server *database.Server) error {
 	}
 
 	// Удаляем конфиг файл
-	configPath := fmt.Sprintf("/etc/wireguard/%s.conf", server.Interface)
+	configPath := filepath.Join(ConfigDir, server.Interface+".conf")
 	return os.Remove(configPath)
 }
 
@@ -185,12 +226,15 @@ func UpdateStats(db *database.Database) {
 	database.SaveDatabase(db)
 }
 
-// UpdateStatsLoop обновляет статистику каждые 5 секунд
+// UpdateStatsLoop обновляет статистику и отключает истекших клиентов каждые 5 секунд
 func UpdateStatsLoop(db *database.Database) {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
 	for range ticker.C {
+		database.Mu.Lock()
+		DisableExpiredClients(db)
 		UpdateStats(db)
+		database.Mu.Unlock()
 	}
 }
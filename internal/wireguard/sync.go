@@ -1,10 +1,10 @@
 package wireguard
 
 import (
-	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -12,211 +12,76 @@ import (
 )
 
 // SyncWireGuardWithDatabase синхронизирует WireGuard с базой данных
-// База данных - единственный источник истины
+// База данных - единственный источник истины для серверов панели
 func SyncWireGuardWithDatabase(db *database.Database) error {
 	log.Println("🔄 Синхронизация WireGuard с базой данных...")
-	log.Println("📋 База данных - единственный источник истины")
+	log.Println("📋 База данных - единственный источник истины для серверов панели")
 
-	// Получаем список всех интерфейсов WireGuard
-	cmd := exec.Command("wg", "show", "interfaces")
-	output, err := cmd.Output()
-
-	var activeInterfaces []string
-	if err == nil && len(output) > 0 {
-		activeInterfaces = strings.Fields(strings.TrimSpace(string(output)))
-	}
-
-	// Создаем карту интерфейсов из БД
-	dbInterfaces := make(map[string]*database.Server)
+	// Серверы из БД всегда в реестре (базы до появления реестра)
 	for i := range db.Servers {
-		dbInterfaces[db.Servers[i].Interface] = &db.Servers[i]
+		database.RegisterInterface(db, db.Servers[i].Interface)
+		migrateLegacyPostUp(&db.Servers[i])
 	}
 
-	// Удаляем все интерфейсы которых НЕТ в БД
-	for _, iface := range activeInterfaces {
-		if _, exists := dbInterfaces[iface]; !exists {
-			log.Printf("  ❌ Интерфейс %s не найден в БД - удаление...", iface)
+	// Свои интерфейсы без сервера в БД удаляем, чужие не трогаем -
+	// их можно перенести в панель (wg_serf server adopt)
+	for _, iface := range FindUnmanagedInterfaces(db) {
+		if database.IsManagedInterface(db, iface) {
+			log.Printf("  ❌ Интерфейс %s создан панелью, но сервера нет в БД - удаление...", iface)
 			if err := removeInterface(iface); err != nil {
 				log.Printf("    ⚠️  Ошибка удаления интерфейса: %v", err)
-			} else {
-				log.Printf("    ✅ Интерфейс %s удален", iface)
-			}
-		}
-	}
-
-	// Также удаляем конфиг файлы которых нет в БД (но интерфейс не запущен)
-	cmd = exec.Command("sh", "-c", "ls /etc/wireguard/*.conf 2>/dev/null | xargs -n1 basename | sed 's/.conf$//'")
-	output, err = cmd.Output()
-	if err == nil && len(output) > 0 {
-		configInterfaces := strings.Fields(strings.TrimSpace(string(output)))
-		for _, iface := range configInterfaces {
-			if _, exists := dbInterfaces[iface]; !exists {
-				configPath := fmt.Sprintf("/etc/wireguard/%s.conf", iface)
-				log.Printf("  🗑️  Удаление конфига %s (не в БД)...", configPath)
-				if err := os.Remove(configPath); err != nil {
-					log.Printf("    ⚠️  Ошибка удаления конфига: %v", err)
-				} else {
-					log.Printf("    ✅ Конфиг удален")
-				}
 			}
+			database.UnregisterInterface(db, iface)
+			continue
 		}
+		log.Printf("  ⚠️  Интерфейс %s не управляется панелью - оставлен без изменений", iface)
 	}
 
-	// Синхронизируем каждый сервер из БД
-	for i := range db.Servers {
-		server := &db.Servers[i]
-		log.Printf("  🔧 Синхронизация сервера %s (%s)...", server.Name, server.Interface)
-
-		// Проверяем запущен ли интерфейс
-		isRunning := false
-		for _, iface := range activeInterfaces {
-			if iface == server.Interface {
-				isRunning = true
-				break
-			}
-		}
-
-		if server.Enabled {
-			// Сервер должен быть запущен
-			if isRunning {
-				// Если уже запущен - перезапускаем (чтобы применить правила iptables после очистки)
-				log.Printf("    🔄 Интерфейс уже запущен, перезапускаю для применения правил...")
-				if err := stopInterface(server.Interface); err != nil {
-					log.Printf("    ⚠️  Ошибка остановки: %v", err)
-				}
-			}
-
-			log.Printf("    🚀 Запуск интерфейса %s...", server.Interface)
-			if err := startInterface(server, db); err != nil {
-				log.Printf("    ❌ Ошибка запуска: %v", err)
-				server.Enabled = false
-				continue
-			}
-
-			// Синхронизируем peers
-			log.Printf("    🧹 Очистка всех peers...")
-			if err := clearAllPeers(server.Interface); err != nil {
-				log.Printf("    ⚠️  Ошибка очистки: %v", err)
-			}
-
-			log.Printf("    📤 Загрузка peers из БД...")
-			loadedCount := 0
-			for j := range db.Clients {
-				client := &db.Clients[j]
-				if client.ServerID == server.ID && client.Enabled {
-					if err := addPeerToWireGuard(server, *client); err != nil {
-						log.Printf("    ⚠️  Ошибка добавления %s: %v", client.Name, err)
-					} else {
-						loadedCount++
-						// Применяем пробросы портов
-						if len(client.PortForwards) > 0 {
-							log.Printf("    🔀 Применяю %d пробросов портов для %s...", len(client.PortForwards), client.Name)
-							ApplyAllPortForwards(client)
-						}
-					}
-				}
-			}
-			log.Printf("    ✅ Загружено %d peers", loadedCount)
-
+	// Применяем только разницу между БД и системой
+	report := Reconcile(db, false)
+	for _, change := range report.Changes {
+		if change.Error != "" {
+			log.Printf("  ❌ %s %s: %s", change.Action, change.Target, change.Error)
 		} else {
-			// Сервер должен быть остановлен
-			if isRunning {
-				log.Printf("    🛑 Остановка интерфейса %s...", server.Interface)
-				if err := stopInterface(server.Interface); err != nil {
-					log.Printf("    ⚠️  Ошибка остановки: %v", err)
-				} else {
-					log.Printf("    ✅ Интерфейс остановлен")
-				}
-			}
+			log.Printf("  🔧 %s %s %s", change.Action, change.Target, change.Detail)
 		}
 	}
+	for _, e := range report.Errors {
+		log.Printf("  ⚠️  %s", e)
+	}
 
 	database.SaveDatabase(db)
-	log.Println("✅ Синхронизация завершена")
+	log.Printf("✅ Синхронизация завершена: изменений %d, ошибок %d", report.Applied, report.Failed)
 	return nil
 }
 
-// removeInterface удаляет интерфейс WireGuard
+// removeInterface останавливает интерфейс и удаляет его конфиг
 func removeInterface(iface string) error {
-	// Останавливаем интерфейс
-	cmd := exec.Command("wg-quick", "down", iface)
-	cmd.Run() // Игнорируем ошибку если уже остановлен
-
-	// Удаляем конфиг файл
-	configPath := fmt.Sprintf("/etc/wireguard/%s.conf", iface)
-	return os.Remove(configPath)
-}
-
-// stopInterface останавливает интерфейс WireGuard
-func stopInterface(iface string) error {
-	cmd := exec.Command("wg-quick", "down", iface)
-	return cmd.Run()
-}
-
-// startInterface запускает интерфейс WireGuard
-func startInterface(server *database.Server, db *database.Database) error {
-	log.Printf("    📝 Обновляю конфиг файл...")
-	// Убеждаемся что конфиг файл существует
-	if err := UpdateServerConfig(server, db); err != nil {
-		return fmt.Errorf("ошибка создания конфига: %v", err)
-	}
-
-	log.Printf("    🔧 Включаю IP forwarding...")
-	// Включаем IP forwarding
-	if err := database.EnableIPForwarding(); err != nil {
-		log.Printf("    ⚠️  Ошибка IP forwarding: %v", err)
-	}
-
-	log.Printf("    🚀 Запускаю wg-quick up %s...", server.Interface)
-	// Запускаем интерфейс
-	cmd := exec.Command("wg-quick", "up", server.Interface)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("    ❌ Вывод wg-quick: %s", string(output))
-		return fmt.Errorf("wg-quick up failed: %v, output: %s", err, string(output))
-	}
-	log.Printf("    ✅ Интерфейс запущен успешно")
+	exec.Command("wg-quick", "down", iface).Run() // Игнорируем ошибку если уже остановлен
 
-	// Применяем правила iptables вручную (не полагаемся на PostUp)
-	if err := ApplyWireGuardIPTablesRules(server); err != nil {
-		log.Printf("    ⚠️  Ошибка применения правил: %v", err)
+	configPath := filepath.Join(ConfigDir, iface+".conf")
+	if err := os.Remove(configPath); err != nil && !os.IsNotExist(err) {
+		return err
 	}
-
 	return nil
 }
 
-// clearAllPeers очищает все peers из интерфейса WireGuard
-func clearAllPeers(iface string) error {
-	// Получаем список всех peers
-	cmd := exec.Command("wg", "show", iface, "peers")
-	output, err := cmd.Output()
-	if err != nil {
-		return err
-	}
-
-	peers := strings.Split(strings.TrimSpace(string(output)), "\n")
-	removedCount := 0
-
-	for _, peer := range peers {
-		peer = strings.TrimSpace(peer)
-		if peer == "" {
-			continue
-		}
-
-		// Удаляем peer
-		cmd := exec.Command("wg", "set", iface, "peer", peer, "remove")
-		if err := cmd.Run(); err != nil {
-			log.Printf("      ⚠️  Не удалось удалить peer %s: %v", peer[:16]+"...", err)
-		} else {
-			removedCount++
-		}
+// migrateLegacyPostUp убирает PostUp/PostDown, которые панель раньше генерировала сама:
+// они добавляли правила прямо в FORWARD и POSTROUTING, теперь правила в цепочках WGSERF-*.
+// Интерфейс больше не перезапускается при старте, поэтому старые правила удаляем здесь
+func migrateLegacyPostUp(server *database.Server) {
+	if !strings.HasPrefix(server.PostUp, "iptables -I FORWARD 1 -i %i -j ACCEPT; iptables -I FORWARD 1 -o %i -j ACCEPT;") {
+		return
 	}
 
-	if removedCount > 0 {
-		log.Printf("      🗑️  Удалено %d peers", removedCount)
-	}
+	log.Printf("  🔧 %s: убираю старые PostUp/PostDown с правилами вне цепочек панели", server.Interface)
+	postDown := strings.ReplaceAll(server.PostDown, "%i", server.Interface)
+	exec.Command("sh", "-c", postDown).Run() // Правил может уже не быть
+	exec.Command("iptables", "-t", "nat", "-D", "POSTROUTING", "-s", getNetworkFromAddress(server.Address),
+		"-o", database.GetDefaultInterface(), "-j", "MASQUERADE").Run()
 
-	return nil
+	server.PostUp = ""
+	server.PostDown = ""
 }
 
 // updateNextClientIP обновляет счетчик следующего IP для клиентов
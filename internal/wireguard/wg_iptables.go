@@ -2,59 +2,60 @@ package wireguard
 
 import (
 	"log"
-	"os/exec"
 
 	"wg-panel/internal/database"
 )
 
-// ApplyWireGuardIPTablesRules применяет правила iptables для WireGuard сервера
-func ApplyWireGuardIPTablesRules(server *database.Server) error {
-	netInterface := database.GetDefaultInterface()
-	iface := server.Interface
-	network := getNetworkFromAddress(server.Address)
-
-	log.Printf("    🔧 Применяю правила iptables для %s (сеть: %s, интерфейс: %s)...", iface, network, netInterface)
-
-	// Правила FORWARD
-	commands := [][]string{
-		{"iptables", "-I", "FORWARD", "1", "-i", iface, "-j", "ACCEPT"},
-		{"iptables", "-I", "FORWARD", "1", "-o", iface, "-j", "ACCEPT"},
-		{"iptables", "-t", "nat", "-A", "POSTROUTING", "-s", network, "-o", netInterface, "-j", "MASQUERADE"},
-	}
-
-	for i, cmdArgs := range commands {
-		cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			log.Printf("    ⚠️  Команда #%d %v: %v (output: %s)", i+1, cmdArgs, err, string(output))
-		} else {
-			log.Printf("    ✅ Команда #%d выполнена: %v", i+1, cmdArgs)
-		}
+// ApplyFirewall ставит все правила панели одной загрузкой: набор строится
+// из БД целиком, при ошибке остаются прежние правила
+func ApplyFirewall(db *database.Database) error {
+	rules := desiredRules(db)
+	if err := firewall.load(rules); err != nil {
+		log.Printf("    ⚠️  Правила %s не применены: %v", firewall.name(), err)
+		return err
 	}
-
-	log.Printf("    ✅ Правила iptables применены")
+	log.Printf("    🛡️  Правила %s применены (%d)", firewall.name(), len(rules))
 	return nil
 }
 
-// RemoveWireGuardIPTablesRules удаляет правила iptables для WireGuard сервера
-func RemoveWireGuardIPTablesRules(server *database.Server) error {
-	netInterface := database.GetDefaultInterface()
-	iface := server.Interface
+// FirewallPreview правила, которые панель поставит для текущей БД
+type FirewallPreview struct {
+	Backend string   `json:"backend"`
+	Rules   []string `json:"rules"`  // Все правила в синтаксисе iptables
+	Script  string   `json:"script"` // Что получит iptables-restore или nft -f
+	Add     []string `json:"add"`    // Чего сейчас не хватает
+	Remove  []string `json:"remove"` // Что сейчас лишнее
+	Errors  []string `json:"errors"`
+}
 
-	log.Printf("    🗑️  Удаляю правила iptables для %s...", iface)
+// PreviewFirewall строит правила без применения
+func PreviewFirewall(db *database.Database) *FirewallPreview {
+	preview := &FirewallPreview{Backend: firewall.name(), Rules: []string{}, Add: []string{}, Remove: []string{}, Errors: []string{}}
 
-	commands := [][]string{
-		{"iptables", "-D", "FORWARD", "-i", iface, "-j", "ACCEPT"},
-		{"iptables", "-D", "FORWARD", "-o", iface, "-j", "ACCEPT"},
-		{"iptables", "-t", "nat", "-D", "POSTROUTING", "-s", getNetworkFromAddress(server.Address), "-o", netInterface, "-j", "MASQUERADE"},
+	rules := desiredRules(db)
+	for _, rule := range rules {
+		preview.Rules = append(preview.Rules, rule.key())
 	}
 
-	for _, cmdArgs := range commands {
-		cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
-		cmd.Run() // Игнорируем ошибки
+	script, err := firewall.render(rules)
+	if err != nil {
+		preview.Errors = append(preview.Errors, err.Error())
 	}
+	preview.Script = script
 
-	return nil
+	actual, err := firewall.list()
+	if err != nil {
+		preview.Errors = append(preview.Errors, err.Error())
+		return preview
+	}
+	remove, add := diffRules(rules, actual)
+	for _, rule := range remove {
+		preview.Remove = append(preview.Remove, rule.key())
+	}
+	for _, rule := range add {
+		preview.Add = append(preview.Add, rule.key())
+	}
+	return preview
 }
 
 // getNetworkFromAddress извлекает подсеть из адреса типа "10.0.0.1/24" -> "10.0.0.0/24"
@@ -10,7 +10,9 @@ import (
 	"strings"
 	"syscall"
 
+	"wg-panel/internal/certs"
 	"wg-panel/internal/database"
+	"wg-panel/internal/dns"
 	"wg-panel/internal/server"
 	"wg-panel/internal/wireguard"
 )
@@ -86,6 +88,16 @@ func main() {
 			showStatus()
 		case "uninstall", "delete":
 			deleteServer()
+		case "2fa-reset":
+			if len(os.Args) < 3 {
+				fmt.Println("Использование: wg_serf 2fa-reset <логин>")
+				os.Exit(1)
+			}
+			resetTwoFactor(os.Args[2])
+		case "health":
+			healthCheck()
+		case "server", "client", "forward", "acl", "egress", "sync", "drift", "dns":
+			runManageCommand(command, os.Args[2:])
 		default:
 			fmt.Printf("Неизвестная команда: %s\n\n", command)
 			showHelp()
@@ -155,7 +167,7 @@ func handleNoArgs() {
 			if serverIP == "127.0.0.1" {
 				serverIP = database.GetServerEndpoint()
 			}
-			fmt.Printf("🌐 Веб-панель: http://%s:%s\n", serverIP, config.Port)
+			fmt.Printf("🌐 Веб-панель: %s://%s:%s\n", database.PanelScheme(config), serverIP, config.Port)
 			fmt.Printf("👤 Логин: %s\n", config.Username)
 			fmt.Printf("🔒 Пароль: %s\n", config.Password)
 		}
@@ -219,11 +231,50 @@ func showHelp() {
    restart    Перезапустить сервер
    status     Показать статус сервера
    delete     Удалить wg_serf полностью
+   2fa-reset  Сбросить 2FA пользователя (wg_serf 2fa-reset <логин>)
+   health     Проверить работающий сервис через локальный сокет
+
+🔌 УПРАВЛЕНИЕ WIREGUARD:
+   server list                                  Список серверов
+   server create <имя> <подсеть> <порт> [dns]   Создать сервер
+   server delete <сервер>                       Удалить сервер и его клиентов
+   server bundle <сервер> <файл.zip>            ZIP с конфигами и QR всех клиентов
+   server adopt [интерфейс...]                  Показать / перенести существующие wg конфиги
+   server isolation <сервер> <on|off>           Запретить клиентам сервера видеть друг друга
+   client list [сервер]                         Список клиентов
+   client add <сервер> <имя> [комментарий]      Добавить клиента
+   client rm|enable|disable <клиент>            Удалить / включить / выключить
+   client show-config <клиент>                  Показать конфиг
+   client qr <клиент>                           QR код в терминале
+   client import <файл> [сервер] [--dry-run]    Импорт из CSV/JSON
+   client export [csv|json] [сервер]            Экспорт клиентов в stdout
+   client groups <клиент> <группа,...|->        Группы клиента для ACL (- очистить)
+   forward list <клиент>                        Пробросы портов клиента
+   forward add <клиент> <порт[-конец][:на клиенте]> <tcp|udp|both> [--from CIDR,...] [--all-interfaces] [описание]
+   forward rm <клиент> <порт> <протокол>
+   acl list <сервер>                            Разрешения между группами при изоляции
+   acl add <сервер> <из группы> <в группу> [tcp|udp|both [порты]] [описание]
+   acl rm <сервер> <номер>
+   egress show <клиент>                         Политика исходящего трафика клиента
+   egress internet <клиент> <on|off>            Разрешить / закрыть интернет (частные сети остаются)
+   egress allow|deny <клиент> <CIDR> [tcp|udp|both [порты]]
+   egress rm <клиент> <allow|deny> <номер>
+   egress clear <клиент>                        Снять все ограничения
+   sync [--dry-run]                             Привести WireGuard и iptables к БД (только разница)
+   drift [--now]                                Расхождения из фоновой проверки (--now - проверить сейчас)
+   dns [status|refresh]                         Списки блокировки DNS (refresh - скачать заново)
+   dns stats [сервер]                           Запросы и блокировки по клиентам
+   dns filter <сервер> <on|off>                 Блокировать домены из списков для клиентов сервера
+   dns list add|rm <URL|файл|имя>               Источник списка (hosts или adblock)
+   dns upload <имя> <файл>                      Загрузить список в панель
+   dns allow|block add|rm <домен>               Исключение / ручная блокировка (с поддоменами)
+   (сервер и клиент - ID или имя)
 
 🔧 ПРИМЕРЫ:
    sudo wg_serf install    # Сначала установить
    wg_serf status          # Проверить статус
    wg_serf restart         # Перезапустить
+   wg_serf client add wg0 laptop && wg_serf client qr laptop
 
 📡 ВЕБ-ИНТЕРФЕЙС:
    После запуска откройте в браузере: http://your-server-ip:8080
@@ -329,7 +380,7 @@ func showStatus() {
 		config, err := database.LoadConfig()
 		if err == nil {
 			fmt.Printf("✅ Сервер работает (PID: %d)\n", pid)
-			fmt.Printf("🌐 Веб-интерфейс: http://%s:%s\n", config.Address, config.Port)
+			fmt.Printf("🌐 Веб-интерфейс: %s://%s:%s\n", database.PanelScheme(config), config.Address, config.Port)
 			fmt.Printf("👤 Логин: %s\n", config.Username)
 		} else {
 			fmt.Printf("✅ Сервер работает (PID: %d)\n", pid)
@@ -340,6 +391,32 @@ func showStatus() {
 	}
 }
 
+// resetTwoFactor отключает 2FA пользователя, потерявшего доступ к аутентификатору
+func resetTwoFactor(username string) {
+	config, err := database.LoadConfig()
+	if err != nil {
+		fmt.Println("❌ Ошибка загрузки конфигурации:", err)
+		os.Exit(1)
+	}
+
+	if !database.SetTwoFactor(config, username, nil) {
+		fmt.Printf("❌ Пользователь %s не найден\n", username)
+		os.Exit(1)
+	}
+
+	if err := database.SaveConfig(config); err != nil {
+		fmt.Println("❌ Ошибка сохранения конфигурации:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("✅ 2FA для пользователя %s сброшена\n", username)
+
+	// Запущенный сервис держит конфигурацию в памяти - перезапускаем
+	if isRunning() {
+		restartServer()
+	}
+}
+
 func isRunning() bool {
 	pid, err := readPIDFile()
 	if err != nil {
@@ -479,15 +556,17 @@ WantedBy=multi-user.target
 	}
 	config, _ := database.LoadConfig()
 	port := "8080"
+	scheme := "http"
 	if config != nil {
 		port = config.Port
+		scheme = database.PanelScheme(config)
 	}
 
 	fmt.Println("╔══════════════════════════════════════════════════════════════╗")
 	fmt.Println("║                 ✅ Установка завершена!                      ║")
 	fmt.Println("╚══════════════════════════════════════════════════════════════╝")
 	fmt.Println("")
-	fmt.Printf("🌐 Откройте в браузере: http://%s:%s\n", serverIP, port)
+	fmt.Printf("🌐 Откройте в браузере: %s://%s:%s\n", scheme, serverIP, port)
 	if config != nil {
 		fmt.Printf("👤 Логин: %s\n", config.Username)
 		fmt.Printf("🔒 Пароль: %s\n", config.Password)
@@ -523,6 +602,10 @@ func deleteServer() {
 	fmt.Println("🔄 Отключение автозапуска...")
 	exec.Command("systemctl", "disable", "wg_serf").Run()
 
+	// Удаление цепочек панели в iptables и nftables
+	fmt.Println("🧹 Удаление правил firewall wg_serf...")
+	wireguard.RemoveFirewall()
+
 	// Удаление service файла
 	fmt.Println("🗑️  Удаление systemd service...")
 	os.Remove("/etc/systemd/system/wg_serf.service")
@@ -619,6 +702,7 @@ func runServer() {
 		log.Fatal("Ошибка загрузки конфигурации:", err)
 	}
 	server.Config = config
+	server.Version = version
 
 	// Загружаем базу данных
 	db, err := database.LoadDatabase()
@@ -632,14 +716,12 @@ func runServer() {
 	}
 	server.DB = db
 
-	// Очищаем iptables (так как сервер только для WireGuard)
-	if err := wireguard.CleanIPTables(); err != nil {
-		log.Println("Предупреждение: ошибка очистки iptables:", err)
-	}
+	// Встроенный DNS меняет конфиги клиентов и правила firewall
+	dns.Configure(config.DNS)
 
-	// Настраиваем базовые правила
-	if err := wireguard.SetupBasicIPTables(); err != nil {
-		log.Println("Предупреждение: ошибка настройки базовых правил:", err)
+	// Готовим цепочки панели в iptables или nftables (в режиме exclusive - с полной очисткой)
+	if err := wireguard.SetupFirewall(config.FirewallMode, config.FirewallBackend); err != nil {
+		log.Println("Предупреждение: ошибка настройки firewall:", err)
 	}
 
 	// Включаем IP forwarding
@@ -655,12 +737,49 @@ func runServer() {
 	// Настраиваем маршруты
 	server.SetupRoutes()
 
+	// Сокет для команд CLI (wg_serf server/client/forward)
+	go func() {
+		if err := server.ServeLocal(server.SocketPath); err != nil {
+			log.Println("Предупреждение: сокет CLI недоступен:", err)
+		}
+	}()
+
 	// Обновляем статистику каждые 5 секунд
 	go wireguard.UpdateStatsLoop(db)
 
+	// Следим за ручными изменениями WireGuard и iptables
+	go wireguard.DriftCheckLoop(db, config.DriftCheck)
+
+	// DNS на адресах серверов в туннеле
+	go dns.ServeLoop(db)
+
 	addr := config.Address + ":" + config.Port
-	log.Printf("🚀 Сервер запущен на http://%s\n", addr)
+	log.Printf("🚀 Сервер запущен на %s://%s\n", database.PanelScheme(config), addr)
 	log.Printf("👤 Логин: %s\n", config.Username)
 	log.Printf("🔒 Пароль: %s\n", config.Password)
-	log.Fatal(http.ListenAndServe(addr, nil))
+
+	if database.PanelScheme(config) == "http" {
+		log.Fatal(http.ListenAndServe(addr, nil))
+	}
+
+	// HTTPS: сертификат выдает менеджер (самоподписанный, из файла или ACME)
+	certManager, err := certs.NewManager(config.TLS)
+	if err != nil {
+		log.Fatal("Ошибка настройки TLS:", err)
+	}
+	certManager.Start()
+
+	if certManager.NeedsHTTPListener() {
+		httpAddr := config.Address + ":" + certManager.HTTPPort()
+		log.Printf("🔐 HTTP-01 и редирект на HTTPS: http://%s\n", httpAddr)
+		go func() {
+			log.Fatal(http.ListenAndServe(httpAddr, certManager.HTTPHandler(config.Port)))
+		}()
+	}
+
+	httpsServer := &http.Server{
+		Addr:      addr,
+		TLSConfig: certManager.TLSConfig(),
+	}
+	log.Fatal(httpsServer.ListenAndServeTLS("", ""))
 }
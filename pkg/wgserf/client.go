@@ -0,0 +1,589 @@
+// Package wgserf клиент HTTP API панели WG_SERF.
+//
+// Авторизация - API токеном (WithToken) или логином и паролем (Login):
+//
+//	panel, err := wgserf.New("https://vpn.example.com:8080", wgserf.WithToken("wgs_..."))
+//	servers, err := panel.Servers(ctx)
+//	if errors.Is(err, wgserf.ErrForbidden) { ... }
+package wgserf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const apiPrefix = "/api/v1"
+
+// Panel клиент API одной панели
+type Panel struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	token      string
+	csrfToken  string // Для сессии после Login
+}
+
+// Option настройка клиента
+type Option func(*Panel)
+
+// WithToken авторизация API токеном (Authorization: Bearer)
+func WithToken(token string) Option {
+	return func(p *Panel) { p.token = token }
+}
+
+// WithHTTPClient свой http.Client (таймауты, TLS). Для Login нужен Jar
+func WithHTTPClient(client *http.Client) Option {
+	return func(p *Panel) { p.httpClient = client }
+}
+
+// WithUnixSocket запросы через локальный unix сокет панели (/opt/wg_serf/wg_serf.sock).
+// Доступен только root, авторизация не нужна
+func WithUnixSocket(path string) Option {
+	return func(p *Panel) {
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		}
+		p.httpClient = &http.Client{Transport: transport}
+	}
+}
+
+// New создает клиент для панели по адресу baseURL (например http://host:8080)
+func New(baseURL string, opts ...Option) (*Panel, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("wgserf: invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("wgserf: base URL must be http or https")
+	}
+
+	p := &Panel{baseURL: u}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.httpClient == nil {
+		jar, _ := cookiejar.New(nil)
+		p.httpClient = &http.Client{Jar: jar}
+	}
+	return p, nil
+}
+
+var csrfMeta = regexp.MustCompile(`<meta name="csrf-token" content="([^"]*)">`)
+
+// Login входит по логину и паролю (otp - код 2FA, если включена)
+func (p *Panel) Login(ctx context.Context, username, password, otp string) error {
+	if p.httpClient.Jar == nil {
+		return fmt.Errorf("wgserf: Login requires an http.Client with a cookie jar")
+	}
+
+	form := url.Values{"username": {username}, "password": {password}, "otp": {otp}}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.url("/login", nil), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", p.baseURL.Scheme+"://"+p.baseURL.Host)
+
+	// Не следуем редиректу - по нему видно результат входа
+	client := *p.httpClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusSeeOther || strings.HasPrefix(location, "/login") {
+		message := "invalid credentials"
+		switch {
+		case strings.Contains(location, "error=2"):
+			message = "invalid 2FA code"
+		case strings.Contains(location, "error=3"):
+			message = "too many attempts, retry later"
+		case resp.StatusCode == http.StatusForbidden:
+			message = "cross-origin request rejected"
+		}
+		return &APIError{StatusCode: http.StatusUnauthorized, Code: "unauthorized", Message: message}
+	}
+
+	// CSRF токен сессии берем со страницы панели
+	body, err := p.raw(ctx, "GET", "/", nil)
+	if err != nil {
+		return err
+	}
+	match := csrfMeta.FindSubmatch(body)
+	if match == nil {
+		return fmt.Errorf("wgserf: CSRF token not found on panel page")
+	}
+	p.csrfToken = string(match[1])
+	return nil
+}
+
+// url собирает адрес запроса
+func (p *Panel) url(path string, query url.Values) string {
+	u := *p.baseURL
+	u.Path = p.baseURL.Path + path
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// do выполняет JSON запрос к /api/v1 и декодирует ответ в out
+func (p *Panel) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	resp, err := p.send(ctx, method, p.url(apiPrefix+path, query), body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return decodeError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("wgserf: invalid response: %w", err)
+	}
+	return nil
+}
+
+// raw выполняет запрос и возвращает тело ответа как есть
+func (p *Panel) raw(ctx context.Context, method, path string, query url.Values) ([]byte, error) {
+	resp, err := p.send(ctx, method, p.url(path, query), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, decodeError(resp)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// send отправляет запрос с авторизацией
+func (p *Panel) send(ctx context.Context, method, rawURL string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	} else if p.csrfToken != "" {
+		req.Header.Set("X-CSRF-Token", p.csrfToken)
+	}
+	return p.httpClient.Do(req)
+}
+
+// decodeError разбирает ответ с ошибкой
+func decodeError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Error.Code != "" {
+		return &APIError{StatusCode: resp.StatusCode, Code: body.Error.Code, Message: body.Error.Message}
+	}
+
+	code := statusCodes[resp.StatusCode]
+	if code == "" {
+		code = "http_" + strconv.Itoa(resp.StatusCode)
+	}
+	return &APIError{StatusCode: resp.StatusCode, Code: code, Message: strings.TrimSpace(string(data))}
+}
+
+// === ПОЛЬЗОВАТЕЛЬ ===
+
+// Me возвращает текущего пользователя
+func (p *Panel) Me(ctx context.Context) (*User, error) {
+	var user User
+	if err := p.do(ctx, "GET", "/me", nil, nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Health возвращает состояние сервиса
+func (p *Panel) Health(ctx context.Context) (*Health, error) {
+	data, err := p.raw(ctx, "GET", "/api/health", nil)
+	if err != nil {
+		return nil, err
+	}
+	var health Health
+	if err := json.Unmarshal(data, &health); err != nil {
+		return nil, fmt.Errorf("wgserf: invalid response: %w", err)
+	}
+	return &health, nil
+}
+
+// === СЕРВЕРЫ ===
+
+// Servers возвращает доступные серверы
+func (p *Panel) Servers(ctx context.Context) ([]Server, error) {
+	var servers []Server
+	if err := p.do(ctx, "GET", "/servers", nil, nil, &servers); err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+// Server возвращает сервер по ID
+func (p *Panel) Server(ctx context.Context, id string) (*Server, error) {
+	var server Server
+	if err := p.do(ctx, "GET", "/servers/"+url.PathEscape(id), nil, nil, &server); err != nil {
+		return nil, err
+	}
+	return &server, nil
+}
+
+// CreateServer создает сервер
+func (p *Panel) CreateServer(ctx context.Context, req CreateServerRequest) (*Server, error) {
+	var server Server
+	if err := p.do(ctx, "POST", "/servers", nil, req, &server); err != nil {
+		return nil, err
+	}
+	return &server, nil
+}
+
+// UpdateServer изменяет сервер
+func (p *Panel) UpdateServer(ctx context.Context, id string, req UpdateServerRequest) (*Server, error) {
+	var server Server
+	if err := p.do(ctx, "PATCH", "/servers/"+url.PathEscape(id), nil, req, &server); err != nil {
+		return nil, err
+	}
+	return &server, nil
+}
+
+// DeleteServer удаляет сервер вместе с клиентами
+func (p *Panel) DeleteServer(ctx context.Context, id string) error {
+	return p.do(ctx, "DELETE", "/servers/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// AdoptPreview показывает интерфейсы WireGuard вне панели и что из них получится
+func (p *Panel) AdoptPreview(ctx context.Context) ([]AdoptCandidate, error) {
+	var candidates []AdoptCandidate
+	if err := p.do(ctx, "GET", "/servers/adopt", nil, nil, &candidates); err != nil {
+		return nil, err
+	}
+	return candidates, nil
+}
+
+// Adopt переносит интерфейсы в панель (все или ни одного)
+func (p *Panel) Adopt(ctx context.Context, interfaces ...string) ([]AdoptCandidate, error) {
+	var candidates []AdoptCandidate
+	req := struct {
+		Interfaces []string `json:"interfaces"`
+	}{interfaces}
+	if err := p.do(ctx, "POST", "/servers/adopt", nil, req, &candidates); err != nil {
+		return nil, err
+	}
+	return candidates, nil
+}
+
+// Reconcile сравнивает БД панели с системой; dryRun - только отчет, иначе применяет разницу
+func (p *Panel) Reconcile(ctx context.Context, dryRun bool) (*ReconcileReport, error) {
+	method := "POST"
+	if dryRun {
+		method = "GET"
+	}
+	var report ReconcileReport
+	if err := p.do(ctx, method, "/reconcile", nil, nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Drift результат последней фоновой проверки расхождений; now - проверить сейчас
+func (p *Panel) Drift(ctx context.Context, now bool) (*DriftStatus, error) {
+	method := "GET"
+	if now {
+		method = "POST"
+	}
+	var status DriftStatus
+	if err := p.do(ctx, method, "/drift", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// === КЛИЕНТЫ ===
+
+// values параметры запроса списка клиентов
+func (o ListClientsOptions) values() url.Values {
+	v := url.Values{}
+	if o.ServerID != "" {
+		v.Set("server_id", o.ServerID)
+	}
+	if o.Enabled != nil {
+		v.Set("enabled", strconv.FormatBool(*o.Enabled))
+	}
+	if o.Online != nil {
+		v.Set("online", strconv.FormatBool(*o.Online))
+	}
+	if o.Query != "" {
+		v.Set("q", o.Query)
+	}
+	if o.Page > 0 {
+		v.Set("page", strconv.Itoa(o.Page))
+	}
+	if o.PerPage > 0 {
+		v.Set("per_page", strconv.Itoa(o.PerPage))
+	}
+	return v
+}
+
+// Clients возвращает страницу списка клиентов
+func (p *Panel) Clients(ctx context.Context, opts ListClientsOptions) (*ClientPage, error) {
+	var page ClientPage
+	if err := p.do(ctx, "GET", "/clients", opts.values(), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// AllClients возвращает всех клиентов, проходя по страницам
+func (p *Panel) AllClients(ctx context.Context, opts ListClientsOptions) ([]Client, error) {
+	var clients []Client
+	opts.Page = 1
+	for {
+		page, err := p.Clients(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, page.Items...)
+		if len(page.Items) == 0 || len(clients) >= page.Total {
+			return clients, nil
+		}
+		opts.Page++
+	}
+}
+
+// Client возвращает клиента по ID
+func (p *Panel) Client(ctx context.Context, id string) (*Client, error) {
+	var client Client
+	if err := p.do(ctx, "GET", "/clients/"+url.PathEscape(id), nil, nil, &client); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// CreateClient создает клиента на сервере
+func (p *Panel) CreateClient(ctx context.Context, serverID string, req CreateClientRequest) (*Client, error) {
+	var client Client
+	if err := p.do(ctx, "POST", "/servers/"+url.PathEscape(serverID)+"/clients", nil, req, &client); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// UpdateClient изменяет клиента
+func (p *Panel) UpdateClient(ctx context.Context, id string, req UpdateClientRequest) (*Client, error) {
+	var client Client
+	if err := p.do(ctx, "PATCH", "/clients/"+url.PathEscape(id), nil, req, &client); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// DeleteClient удаляет клиента
+func (p *Panel) DeleteClient(ctx context.Context, id string) error {
+	return p.do(ctx, "DELETE", "/clients/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// ClientConfig возвращает конфиг клиента (.conf)
+func (p *Panel) ClientConfig(ctx context.Context, id string) (string, error) {
+	data, err := p.raw(ctx, "GET", apiPrefix+"/clients/"+url.PathEscape(id)+"/config", nil)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ClientQR возвращает QR код конфига клиента (PNG)
+func (p *Panel) ClientQR(ctx context.Context, id string) ([]byte, error) {
+	return p.raw(ctx, "GET", apiPrefix+"/clients/"+url.PathEscape(id)+"/qr", nil)
+}
+
+// Stats обновляет статистику на панели и возвращает клиентов
+func (p *Panel) Stats(ctx context.Context, opts ListClientsOptions) (*ClientPage, error) {
+	var page ClientPage
+	if err := p.do(ctx, "GET", "/stats", opts.values(), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// ImportClients загружает клиентов из CSV (с заголовком) или JSON массива.
+// При ошибках в строках ничего не создается: возвращается отчет и ошибка ErrInvalidRequest
+func (p *Panel) ImportClients(ctx context.Context, data []byte, opts ImportOptions) (*ImportReport, error) {
+	query := url.Values{}
+	if opts.DryRun {
+		query.Set("dry_run", "true")
+	}
+	if opts.ServerID != "" {
+		query.Set("server_id", opts.ServerID)
+	}
+
+	resp, err := p.send(ctx, "POST", p.url(apiPrefix+"/clients/import", query), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 && resp.StatusCode != http.StatusUnprocessableEntity {
+		return nil, decodeError(resp)
+	}
+
+	var report ImportReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("wgserf: invalid response: %w", err)
+	}
+	if !report.Valid {
+		message := fmt.Sprintf("%d of %d rows failed", report.Errors, len(report.Rows))
+		return &report, &APIError{StatusCode: resp.StatusCode, Code: "invalid_request", Message: message}
+	}
+	return &report, nil
+}
+
+// ExportClients выгружает клиентов в формате csv или json (serverID пусто - все)
+func (p *Panel) ExportClients(ctx context.Context, format, serverID string) ([]byte, error) {
+	query := url.Values{"format": {format}}
+	if serverID != "" {
+		query.Set("server_id", serverID)
+	}
+	return p.raw(ctx, "GET", apiPrefix+"/clients/export", query)
+}
+
+// ServerBundle возвращает ZIP с конфигами и QR кодами всех клиентов сервера
+func (p *Panel) ServerBundle(ctx context.Context, serverID string) ([]byte, error) {
+	return p.raw(ctx, "GET", apiPrefix+"/servers/"+url.PathEscape(serverID)+"/bundle", nil)
+}
+
+// === ПРОБРОСЫ ПОРТОВ ===
+
+// PortForwards возвращает пробросы портов клиента
+func (p *Panel) PortForwards(ctx context.Context, clientID string) ([]PortForward, error) {
+	var forwards []PortForward
+	if err := p.do(ctx, "GET", "/clients/"+url.PathEscape(clientID)+"/port-forwards", nil, nil, &forwards); err != nil {
+		return nil, err
+	}
+	return forwards, nil
+}
+
+// AddPortForward добавляет проброс порта, возвращает обновленного клиента
+func (p *Panel) AddPortForward(ctx context.Context, clientID string, forward PortForward) (*Client, error) {
+	var client Client
+	if err := p.do(ctx, "POST", "/clients/"+url.PathEscape(clientID)+"/port-forwards", nil, forward, &client); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// RemovePortForward удаляет проброс порта, возвращает обновленного клиента
+func (p *Panel) RemovePortForward(ctx context.Context, clientID string, port int, protocol string) (*Client, error) {
+	var client Client
+	path := fmt.Sprintf("/clients/%s/port-forwards/%d/%s", url.PathEscape(clientID), port, url.PathEscape(protocol))
+	if err := p.do(ctx, "DELETE", path, nil, nil, &client); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// === DNS ===
+
+// DNS возвращает настройки встроенного DNS и состояние списков блокировки
+func (p *Panel) DNS(ctx context.Context) (*DNSStatus, error) {
+	var status DNSStatus
+	if err := p.do(ctx, "GET", "/dns", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// UpdateDNS меняет источники списков, исключения и ручной список блокировки
+func (p *Panel) UpdateDNS(ctx context.Context, req UpdateDNSRequest) (*DNSStatus, error) {
+	var status DNSStatus
+	if err := p.do(ctx, "PATCH", "/dns", nil, req, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// RefreshDNS скачивает списки по URL заново
+func (p *Panel) RefreshDNS(ctx context.Context) (*DNSStatus, error) {
+	var status DNSStatus
+	if err := p.do(ctx, "POST", "/dns/refresh", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// UploadBlocklist загружает список блокировки (hosts, adblock или домен на строку) под именем name
+func (p *Panel) UploadBlocklist(ctx context.Context, name string, data []byte) (*DNSList, error) {
+	resp, err := p.send(ctx, "PUT", p.url(apiPrefix+"/dns/lists/"+url.PathEscape(name), nil), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, decodeError(resp)
+	}
+	var list DNSList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("wgserf: invalid response: %w", err)
+	}
+	return &list, nil
+}
+
+// DeleteBlocklist удаляет загруженный список
+func (p *Panel) DeleteBlocklist(ctx context.Context, name string) error {
+	return p.do(ctx, "DELETE", "/dns/lists/"+url.PathEscape(name), nil, nil, nil)
+}
+
+// DNSStats статистика запросов по клиентам (serverID пусто - все, top - сколько
+// заблокированных доменов на клиента, 0 - по умолчанию)
+func (p *Panel) DNSStats(ctx context.Context, serverID string, top int) ([]DNSClientStats, error) {
+	query := url.Values{}
+	if serverID != "" {
+		query.Set("server_id", serverID)
+	}
+	if top > 0 {
+		query.Set("top", strconv.Itoa(top))
+	}
+	var stats []DNSClientStats
+	if err := p.do(ctx, "GET", "/dns/stats", query, nil, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
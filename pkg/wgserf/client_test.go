@@ -0,0 +1,279 @@
+package wgserf_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"wg-panel/internal/database"
+	"wg-panel/internal/server"
+	"wg-panel/internal/wireguard"
+	"wg-panel/pkg/wgserf"
+)
+
+const (
+	ownerToken  = "wgs_owner_test_token"
+	viewerToken = "wgs_viewer_test_token"
+)
+
+// fakeTool заменяет wg, wg-quick, ip, sysctl и iptables: тесты не трогают систему
+const fakeTool = `#!/bin/sh
+case "$(basename "$0") $1" in
+"wg genkey") head -c 32 /dev/urandom | base64 ;;
+"wg pubkey") cat | sha256sum | head -c 43; echo "=" ;;
+"ip route"*) echo "default via 192.0.2.1 dev eth0" ;;
+"iptables-restore"*) cat >/dev/null ;;
+esac
+exit 0
+`
+
+var routesOnce sync.Once
+
+// newTestPanel поднимает панель на httptest с временными БД, конфигом и каталогом
+// wg-quick и возвращает ее адрес
+func newTestPanel(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, tool := range []string{"wg", "wg-quick", "ip", "sysctl", "iptables", "iptables-restore", "nft"} {
+		if err := os.WriteFile(filepath.Join(bin, tool), []byte(fakeTool), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	wireguard.ConfigDir = filepath.Join(dir, "wireguard")
+	if err := os.Mkdir(wireguard.ConfigDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	database.DBFile = filepath.Join(dir, "db.json")
+	database.ConfigFile = filepath.Join(dir, "config.json")
+
+	server.Config = &database.Config{
+		Username: "admin",
+		Password: "admin",
+		Port:     "8080",
+		APITokens: []database.APIToken{
+			{ID: "1", Name: "owner", Username: "admin", Role: database.RoleOwner, TokenHash: database.HashAPIToken(ownerToken)},
+			{ID: "2", Name: "viewer", Username: "admin", Role: database.RoleViewer, TokenHash: database.HashAPIToken(viewerToken)},
+		},
+	}
+	server.DB = &database.Database{
+		Servers: []database.Server{{
+			ID:           "srv1",
+			Name:         "Office",
+			Interface:    "wgtest0",
+			PrivateKey:   "c2VydmVyLXByaXZhdGUta2V5LXNob3VsZC1uZXZlci1sZWFr",
+			PublicKey:    "server-public-key",
+			Address:      "10.77.0.1/24",
+			ListenPort:   51899,
+			Enabled:      true,
+			CreatedAt:    time.Now(),
+			NextClientIP: 2,
+		}},
+	}
+
+	routesOnce.Do(server.SetupRoutes)
+	ts := httptest.NewServer(http.DefaultServeMux)
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// newClient клиент SDK с токеном
+func newClient(t *testing.T, baseURL, token string) *wgserf.Panel {
+	t.Helper()
+	panel, err := wgserf.New(baseURL, wgserf.WithToken(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return panel
+}
+
+func TestServers(t *testing.T) {
+	owner := newClient(t, newTestPanel(t), ownerToken)
+	ctx := context.Background()
+
+	servers, err := owner.Servers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 1 || servers[0].ID != "srv1" || servers[0].Interface != "wgtest0" {
+		t.Fatalf("servers = %+v", servers)
+	}
+
+	got, err := owner.Server(ctx, "srv1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Office" || got.Address != "10.77.0.1/24" || got.ListenPort != 51899 {
+		t.Errorf("server = %+v", got)
+	}
+
+	name := "Main office"
+	updated, err := owner.UpdateServer(ctx, "srv1", wgserf.UpdateServerRequest{Name: &name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Name != name {
+		t.Errorf("updated name = %q, want %q", updated.Name, name)
+	}
+}
+
+// Приватный ключ сервера не отдается ни в каком виде
+func TestServerPrivateKeyNotSerialized(t *testing.T) {
+	newTestPanel(t)
+
+	for _, path := range []string{"/api/v1/servers", "/api/v1/servers/srv1", "/api/servers"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Authorization", "Bearer "+ownerToken)
+		http.DefaultServeMux.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d", path, w.Code)
+		}
+		if body := w.Body.String(); strings.Contains(body, "private_key") || strings.Contains(body, server.DB.Servers[0].PrivateKey) {
+			t.Errorf("GET %s exposes the server private key: %s", path, body)
+		}
+	}
+}
+
+func TestClients(t *testing.T) {
+	baseURL := newTestPanel(t)
+	owner, viewer := newClient(t, baseURL, ownerToken), newClient(t, baseURL, viewerToken)
+	ctx := context.Background()
+
+	client, err := owner.CreateClient(ctx, "srv1", wgserf.CreateClientRequest{Name: "laptop", Comment: "Anna"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Address != "10.77.0.2" || client.ServerID != "srv1" || !client.Enabled {
+		t.Fatalf("client = %+v", client)
+	}
+	if client.PrivateKey == "" || !client.HasConfig {
+		t.Errorf("owner should get the client private key: %+v", client)
+	}
+
+	// Наблюдатель видит клиента, но не его ключи
+	seen, err := viewer.Client(ctx, client.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen.PrivateKey != "" || !seen.HasConfig {
+		t.Errorf("viewer client = %+v", seen)
+	}
+
+	comment := "Anna, work laptop"
+	updated, err := owner.UpdateClient(ctx, client.ID, wgserf.UpdateClientRequest{Comment: &comment})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Comment != comment {
+		t.Errorf("comment = %q, want %q", updated.Comment, comment)
+	}
+
+	page, err := owner.Clients(ctx, wgserf.ListClientsOptions{ServerID: "srv1", Query: "work"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || len(page.Items) != 1 || page.Items[0].ID != client.ID {
+		t.Errorf("page = %+v", page)
+	}
+
+	config, err := owner.ClientConfig(ctx, client.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(config, "Address = 10.77.0.2") || !strings.Contains(config, "PublicKey = server-public-key") {
+		t.Errorf("config = %s", config)
+	}
+
+	if err := owner.DeleteClient(ctx, client.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := owner.Client(ctx, client.ID); !errors.Is(err, wgserf.ErrNotFound) {
+		t.Errorf("deleted client: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPortForwards(t *testing.T) {
+	owner := newClient(t, newTestPanel(t), ownerToken)
+	ctx := context.Background()
+
+	client, err := owner.CreateClient(ctx, "srv1", wgserf.CreateClientRequest{Name: "nas"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := owner.AddPortForward(ctx, client.ID, wgserf.PortForward{Port: 8443, InternalPort: 443, Protocol: "tcp", Description: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated.PortForwards) != 1 || updated.PortForwards[0].InternalPort != 443 {
+		t.Fatalf("port forwards = %+v", updated.PortForwards)
+	}
+
+	// Тот же внешний порт занят
+	if _, err := owner.AddPortForward(ctx, client.ID, wgserf.PortForward{Port: 8443, Protocol: "tcp"}); !errors.Is(err, wgserf.ErrConflict) {
+		t.Errorf("duplicate port: err = %v, want ErrConflict", err)
+	}
+
+	forwards, err := owner.PortForwards(ctx, client.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forwards) != 1 || forwards[0].Port != 8443 {
+		t.Errorf("forwards = %+v", forwards)
+	}
+
+	updated, err = owner.RemovePortForward(ctx, client.ID, 8443, "tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated.PortForwards) != 0 {
+		t.Errorf("port forwards after remove = %+v", updated.PortForwards)
+	}
+	if _, err := owner.RemovePortForward(ctx, client.ID, 8443, "tcp"); !errors.Is(err, wgserf.ErrNotFound) {
+		t.Errorf("removed twice: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestErrorDecoding(t *testing.T) {
+	baseURL := newTestPanel(t)
+	owner, viewer := newClient(t, baseURL, ownerToken), newClient(t, baseURL, viewerToken)
+	ctx := context.Background()
+
+	_, err := owner.Server(ctx, "missing")
+	var apiErr *wgserf.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %T %v, want *APIError", err, err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Code != "not_found" || apiErr.Message == "" {
+		t.Errorf("APIError = %+v", apiErr)
+	}
+	if !errors.Is(err, wgserf.ErrNotFound) {
+		t.Errorf("errors.Is(%v, ErrNotFound) = false", err)
+	}
+
+	if _, err := viewer.CreateClient(ctx, "srv1", wgserf.CreateClientRequest{Name: "phone"}); !errors.Is(err, wgserf.ErrForbidden) {
+		t.Errorf("viewer create: err = %v, want ErrForbidden", err)
+	}
+	if _, err := owner.CreateClient(ctx, "srv1", wgserf.CreateClientRequest{}); !errors.Is(err, wgserf.ErrInvalidRequest) {
+		t.Errorf("empty name: err = %v, want ErrInvalidRequest", err)
+	}
+
+	if _, err := newClient(t, baseURL, "wgs_wrong").Servers(ctx); !errors.Is(err, wgserf.ErrUnauthorized) {
+		t.Errorf("wrong token: err = %v, want ErrUnauthorized", err)
+	}
+}
@@ -0,0 +1,55 @@
+package wgserf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Ошибки API по коду ответа (проверяются через errors.Is)
+var (
+	ErrInvalidRequest   = errors.New("wgserf: invalid request")
+	ErrUnauthorized     = errors.New("wgserf: unauthorized")
+	ErrForbidden        = errors.New("wgserf: forbidden")
+	ErrNotFound         = errors.New("wgserf: not found")
+	ErrConflict         = errors.New("wgserf: conflict")
+	ErrMethodNotAllowed = errors.New("wgserf: method not allowed")
+	ErrInternal         = errors.New("wgserf: internal server error")
+)
+
+// codeErrors соответствие кодов ошибок API и ошибок пакета
+var codeErrors = map[string]error{
+	"invalid_request":    ErrInvalidRequest,
+	"unauthorized":       ErrUnauthorized,
+	"forbidden":          ErrForbidden,
+	"not_found":          ErrNotFound,
+	"conflict":           ErrConflict,
+	"method_not_allowed": ErrMethodNotAllowed,
+	"internal_error":     ErrInternal,
+}
+
+// statusCodes код ошибки по HTTP статусу (если тело ответа не JSON)
+var statusCodes = map[int]string{
+	400: "invalid_request",
+	401: "unauthorized",
+	403: "forbidden",
+	404: "not_found",
+	405: "method_not_allowed",
+	409: "conflict",
+	500: "internal_error",
+}
+
+// APIError ошибка, возвращенная панелью
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("wgserf: %s (HTTP %d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// Is позволяет сравнивать с ErrNotFound, ErrForbidden и т.д.
+func (e *APIError) Is(target error) bool {
+	return codeErrors[e.Code] == target
+}
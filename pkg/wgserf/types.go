@@ -0,0 +1,276 @@
+package wgserf
+
+import "time"
+
+// Server WireGuard сервер (интерфейс)
+type Server struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Interface    string    `json:"interface"`
+	PublicKey    string    `json:"public_key"`
+	Address      string    `json:"address"`
+	ListenPort   int       `json:"listen_port"`
+	DNS          string    `json:"dns"`
+	Enabled      bool      `json:"enabled"`
+	CreatedAt    time.Time `json:"created_at"`
+	NextClientIP int       `json:"next_client_ip"`
+	Isolation    bool      `json:"isolation"`
+	ACLs         []ACLRule `json:"acls,omitempty"`
+	DNSFilter    bool      `json:"dns_filter"`
+}
+
+// ACLRule разрешение между группами клиентов изолированного сервера
+type ACLRule struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Protocol    string `json:"protocol,omitempty"` // tcp, udp или both (пусто - любой)
+	Ports       string `json:"ports,omitempty"`    // 22 или 8000-8100
+	Description string `json:"description,omitempty"`
+}
+
+// PortForward проброс порта на клиента
+type PortForward struct {
+	Port          int      `json:"port"`                     // Внешний порт (начало диапазона)
+	PortEnd       int      `json:"port_end,omitempty"`       // Конец внешнего диапазона
+	InternalPort  int      `json:"internal_port,omitempty"`  // Порт на клиенте (0 - как внешний)
+	Protocol      string   `json:"protocol"`                 // tcp, udp или both
+	SourceCIDRs   []string `json:"source_cidrs,omitempty"`   // Разрешенные источники (пусто - любые)
+	AllInterfaces bool     `json:"all_interfaces,omitempty"` // Принимать на всех интерфейсах, а не только на внешнем
+	Description   string   `json:"description"`
+}
+
+// Client клиент (peer) WireGuard
+type Client struct {
+	ID            string        `json:"id"`
+	ServerID      string        `json:"server_id"`
+	Name          string        `json:"name"`
+	PublicKey     string        `json:"public_key"`
+	PrivateKey    string        `json:"private_key,omitempty"` // Только с правом clients
+	PresharedKey  string        `json:"preshared_key,omitempty"`
+	HasConfig     bool          `json:"has_config"` // false у перенесенных клиентов без приватного ключа
+	Address       string        `json:"address"`
+	Enabled       bool          `json:"enabled"`
+	Comment       string        `json:"comment"`
+	CreatedAt     time.Time     `json:"created_at"`
+	RxBytes       int64         `json:"rx_bytes"`
+	TxBytes       int64         `json:"tx_bytes"`
+	LastHandshake time.Time     `json:"last_handshake"`
+	Endpoint      string        `json:"endpoint"`
+	PortForwards  []PortForward `json:"port_forwards"`
+	ExpiresAt     *time.Time    `json:"expires_at,omitempty"`
+	Groups        []string      `json:"groups,omitempty"`
+	Egress        *EgressPolicy `json:"egress,omitempty"`
+}
+
+// EgressPolicy ограничения исходящего трафика клиента: запреты проверяются первыми,
+// при непустом Allow запрещено все остальное, BlockInternet оставляет только частные сети
+type EgressPolicy struct {
+	BlockInternet bool         `json:"block_internet"`
+	Allow         []EgressRule `json:"allow,omitempty"`
+	Deny          []EgressRule `json:"deny,omitempty"`
+}
+
+// EgressRule назначение исходящего трафика
+type EgressRule struct {
+	CIDR     string `json:"cidr"`
+	Protocol string `json:"protocol,omitempty"` // tcp, udp или both (пусто - любой)
+	Ports    string `json:"ports,omitempty"`    // 443 или 8000-8100
+}
+
+// User пользователь панели
+type User struct {
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	Servers   []string  `json:"servers"`
+	CreatedAt time.Time `json:"created_at"`
+	Primary   bool      `json:"primary"`
+}
+
+// Health состояние сервиса
+type Health struct {
+	Status        string `json:"status"`
+	Version       string `json:"version"`
+	UptimeSeconds int    `json:"uptime_seconds"`
+	TLS           bool   `json:"tls"`
+	Servers       int    `json:"servers"`
+	ServersActive int    `json:"servers_active"`
+	Clients       int    `json:"clients"`
+}
+
+// ClientPage страница списка клиентов
+type ClientPage struct {
+	Items   []Client `json:"items"`
+	Total   int      `json:"total"`
+	Page    int      `json:"page"`
+	PerPage int      `json:"per_page"`
+}
+
+// ListClientsOptions фильтры и пагинация списка клиентов (нулевые значения - без фильтра)
+type ListClientsOptions struct {
+	ServerID string
+	Enabled  *bool
+	Online   *bool
+	Query    string // Подстрока имени, комментария или адреса
+	Page     int
+	PerPage  int
+}
+
+// CreateServerRequest параметры нового сервера
+type CreateServerRequest struct {
+	Name       string `json:"name"`
+	Address    string `json:"address"` // Подсеть, /24 добавляется если не указано
+	ListenPort int    `json:"listen_port"`
+	DNS        string `json:"dns,omitempty"`
+}
+
+// UpdateServerRequest изменяемые поля сервера (nil - не менять)
+type UpdateServerRequest struct {
+	Name       *string    `json:"name,omitempty"`
+	ListenPort *int       `json:"listen_port,omitempty"`
+	DNS        *string    `json:"dns,omitempty"`
+	Enabled    *bool      `json:"enabled,omitempty"`
+	Isolation  *bool      `json:"isolation,omitempty"`
+	ACLs       *[]ACLRule `json:"acls,omitempty"` // Заменяет все правила сервера
+	DNSFilter  *bool      `json:"dns_filter,omitempty"`
+}
+
+// CreateClientRequest параметры нового клиента
+type CreateClientRequest struct {
+	Name      string     `json:"name"`
+	Comment   string     `json:"comment,omitempty"`
+	Address   string     `json:"address,omitempty"`    // Статический IP (пусто - следующий свободный)
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // После этого времени клиент отключается
+}
+
+// UpdateClientRequest изменяемые поля клиента (nil - не менять)
+type UpdateClientRequest struct {
+	Name    *string       `json:"name,omitempty"`
+	Comment *string       `json:"comment,omitempty"`
+	Enabled *bool         `json:"enabled,omitempty"`
+	Groups  *[]string     `json:"groups,omitempty"` // Заменяет все группы клиента
+	Egress  *EgressPolicy `json:"egress,omitempty"` // Заменяет политику целиком (пустая - снять ограничения)
+}
+
+// AdoptCandidate интерфейс WireGuard вне панели, который можно перенести
+type AdoptCandidate struct {
+	Interface  string   `json:"interface"`
+	ConfigPath string   `json:"config_path,omitempty"`
+	Running    bool     `json:"running"`
+	Server     Server   `json:"server"`
+	Clients    []Client `json:"clients"`
+	Warnings   []string `json:"warnings"`
+	Errors     []string `json:"errors"` // Не пусто - перенос невозможен
+}
+
+// ReconcileChange изменение, нужное чтобы система совпала с БД
+type ReconcileChange struct {
+	Action    string `json:"action"` // config_write, interface_up, peer_add, rule_remove, rule_order, ...
+	Interface string `json:"interface,omitempty"`
+	Target    string `json:"target"`
+	Detail    string `json:"detail,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ReconcileReport отчет синхронизации
+type ReconcileReport struct {
+	DryRun  bool              `json:"dry_run"`
+	Changes []ReconcileChange `json:"changes"`
+	Applied int               `json:"applied"`
+	Failed  int               `json:"failed"`
+	Errors  []string          `json:"errors"`
+}
+
+// DriftStatus результат проверки расхождений системы с БД
+type DriftStatus struct {
+	Enabled      bool              `json:"enabled"`
+	AutoRepair   bool              `json:"auto_repair"`
+	Interval     int               `json:"interval_seconds"`
+	CheckedAt    *time.Time        `json:"checked_at,omitempty"`
+	DriftSince   *time.Time        `json:"drift_since,omitempty"`
+	Changes      []ReconcileChange `json:"changes"`
+	Errors       []string          `json:"errors"`
+	LastRepairAt *time.Time        `json:"last_repair_at,omitempty"`
+	LastRepair   *ReconcileReport  `json:"last_repair,omitempty"`
+}
+
+// ImportOptions параметры пакетного импорта
+type ImportOptions struct {
+	DryRun   bool   // Только проверить
+	ServerID string // Сервер для строк без колонки server
+}
+
+// ImportRowResult результат строки импорта
+type ImportRowResult struct {
+	Row      int    `json:"row"`
+	Name     string `json:"name"`
+	ServerID string `json:"server_id,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Status   string `json:"status"` // ok, created, error
+	Error    string `json:"error,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// ImportReport отчет импорта
+type ImportReport struct {
+	DryRun  bool              `json:"dry_run"`
+	Valid   bool              `json:"valid"`
+	Created int               `json:"created"`
+	Errors  int               `json:"errors"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+// DNSStatus настройки встроенного DNS и состояние списков блокировки
+type DNSStatus struct {
+	Enabled        bool      `json:"enabled"`
+	Domain         string    `json:"domain"`
+	RefreshHours   int       `json:"refresh_hours"`
+	Lists          []DNSList `json:"lists"`
+	BlockedDomains int       `json:"blocked_domains"`
+	Blocklist      []string  `json:"blocklist"`
+	Allowlist      []string  `json:"allowlist"`
+}
+
+// DNSList список блокировки
+type DNSList struct {
+	Source    string     `json:"source"` // URL, путь к файлу или имя загруженного списка
+	Kind      string     `json:"kind"`   // url, file или upload
+	Domains   int        `json:"domains"`
+	Allowed   int        `json:"allowed"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// UpdateDNSRequest изменяемые настройки фильтрации (nil - не менять, список заменяется целиком)
+type UpdateDNSRequest struct {
+	Blocklists   *[]string `json:"blocklists,omitempty"`
+	Allowlist    *[]string `json:"allowlist,omitempty"`
+	Blocklist    *[]string `json:"blocklist,omitempty"`
+	RefreshHours *int      `json:"refresh_hours,omitempty"`
+}
+
+// DNSClientStats запросы клиента к встроенному DNS с момента запуска панели
+type DNSClientStats struct {
+	ClientID    string        `json:"client_id"`
+	ServerID    string        `json:"server_id"`
+	Name        string        `json:"name"`
+	Address     string        `json:"address"`
+	Queries     int           `json:"queries"`
+	Blocked     int           `json:"blocked"`
+	LastQueryAt *time.Time    `json:"last_query_at,omitempty"`
+	TopBlocked  []DomainCount `json:"top_blocked"`
+}
+
+// DomainCount домен и число запросов к нему
+type DomainCount struct {
+	Domain string `json:"domain"`
+	Count  int    `json:"count"`
+}
+
+// Bool возвращает указатель на значение (для фильтров и Update запросов)
+func Bool(v bool) *bool { return &v }
+
+// Int возвращает указатель на значение
+func Int(v int) *int { return &v }
+
+// String возвращает указатель на значение
+func String(v string) *string { return &v }